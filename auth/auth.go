@@ -1,44 +1,122 @@
 package auth
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
+
+	"github.com/johnson-oragui/golang-todo-api/tracing"
 )
 
-var jwtSecret []byte = []byte("mybigsecretkey")
+// signs and verifies access tokens, random until SetJWTSecret is called so
+// no guessable key is ever used
+var jwtSecret = randomSecret()
 
-// hashes password
-func HashPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
+// MinJWTSecretBytes is the shortest secret SetJWTSecret accepts
+const MinJWTSecretBytes = 32
+
+// SetJWTSecret replaces the key access tokens are signed with, tokens signed
+// with the previous key stop being accepted
+func SetJWTSecret(secret []byte) error {
+	if len(secret) < MinJWTSecretBytes {
+		return fmt.Errorf("the JWT secret must be at least %v bytes long", MinJWTSecretBytes)
 	}
+	jwtSecret = secret
+	return nil
+}
 
-	return string(hashedPassword), nil
+func randomSecret() []byte {
+	secret := make([]byte, MinJWTSecretBytes)
+	rand.Read(secret)
+	return secret
 }
 
-func ComparePasswords(plainPassword string, hashedPassword string) error {
-	plainpwdBytes := []byte(plainPassword)
+// audience of the short-lived tokens issued while a login awaits a second factor
+const mfaAudience = "mfa"
 
-	hashedpwdBytes := []byte(hashedPassword)
+// MFATokenTTL is how long a user has to complete the second login step
+var MFATokenTTL = 5 * time.Minute
 
-	err := bcrypt.CompareHashAndPassword(hashedpwdBytes, plainpwdBytes)
+// hashes password with the configured algorithm
+func HashPassword(ctx context.Context, password string) (string, error) {
+	config := PasswordHashing
 
-	if err != nil {
-		return err
+	_, span := tracing.Start(ctx, "auth.HashPassword", attribute.String("auth.hash_algorithm", config.Algorithm))
+	defer span.End()
+
+	switch config.Algorithm {
+	case AlgorithmArgon2id:
+		return hashArgon2id(password, config)
+	case AlgorithmBcrypt:
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), config.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+
+		return string(hashedPassword), nil
+	default:
+		return "", fmt.Errorf("unsupported password hashing algorithm %v", config.Algorithm)
 	}
+}
 
-	return nil
+// checks a password against a bcrypt or argon2id hash
+func ComparePasswords(ctx context.Context, plainPassword string, hashedPassword string) error {
+	algorithm := hashAlgorithm(hashedPassword)
+
+	_, span := tracing.Start(ctx, "auth.ComparePasswords", attribute.String("auth.hash_algorithm", algorithm))
+	defer span.End()
+
+	switch algorithm {
+	case AlgorithmArgon2id:
+		parsed, err := parseArgon2id(hashedPassword)
+		if err != nil {
+			return err
+		}
+
+		key := argon2.IDKey([]byte(plainPassword), parsed.salt, parsed.time, parsed.memory, parsed.threads, uint32(len(parsed.key)))
+		if subtle.ConstantTimeCompare(key, parsed.key) != 1 {
+			return ErrPasswordMismatch
+		}
+		return nil
+	case AlgorithmBcrypt:
+		plainpwdBytes := []byte(plainPassword)
+
+		hashedpwdBytes := []byte(hashedPassword)
+
+		err := bcrypt.CompareHashAndPassword(hashedpwdBytes, plainpwdBytes)
+
+		if err != nil {
+			return err
+		}
+
+		return nil
+	default:
+		return ErrUnknownHashFormat
+	}
+}
+
+// claims of an access token
+type accessClaims struct {
+	jwt.StandardClaims
+	Version int `json:"ver"` // see RevokeTokens
 }
 
 func GenerateJWT(username string) (string, error) {
-	claims := &jwt.StandardClaims{
-		Subject:   username,
-		ExpiresAt: time.Now().Add(time.Hour * 1).Unix(),
-		IssuedAt:  time.Now().Unix(),
+	claims := &accessClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   username,
+			ExpiresAt: time.Now().Add(time.Hour * 1).Unix(),
+			IssuedAt:  time.Now().Unix(),
+		},
+		Version: tokenVersion(username),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -55,11 +133,62 @@ func DecodeJWT(tokenString string) (string, error) {
 		return jwtSecret, nil
 	})
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		username := claims["sub"].(string)
-
-		return username, nil
-	} else {
+	// a malformed token comes back without claims
+	if err != nil {
 		return "", err
 	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return "", errors.New("invalid token")
+	}
+
+	// MFA challenge tokens must not be usable as access tokens
+	if aud, _ := claims["aud"].(string); aud == mfaAudience {
+		return "", errors.New("invalid token audience")
+	}
+
+	username, ok := claims["sub"].(string)
+	if !ok || username == "" {
+		return "", errors.New("token has no subject")
+	}
+
+	if version, _ := claims["ver"].(float64); int(version) != tokenVersion(username) {
+		return "", errors.New("token has been revoked")
+	}
+
+	return username, nil
+}
+
+// generates the token exchanged for an access token once the second factor is verified
+func GenerateMFAToken(username string) (string, error) {
+	claims := &jwt.StandardClaims{
+		Subject:   username,
+		Audience:  mfaAudience,
+		ExpiresAt: time.Now().Add(MFATokenTTL).Unix(),
+		IssuedAt:  time.Now().Unix(),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	return token.SignedString(jwtSecret)
+}
+
+func DecodeMFAToken(tokenString string) (string, error) {
+	claims := &jwt.StandardClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("invalid signing method")
+		}
+		return jwtSecret, nil
+	})
+	if err != nil || !token.Valid {
+		return "", errors.New("invalid mfa token")
+	}
+
+	if !claims.VerifyAudience(mfaAudience, true) || claims.Subject == "" {
+		return "", errors.New("invalid mfa token")
+	}
+
+	return claims.Subject, nil
 }
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+type LoginGuardConfig struct {
+	FreeAttempts  int           // failures allowed before backoff starts
+	BaseDelay     time.Duration // first backoff delay, doubled on every further failure
+	MaxDelay      time.Duration // longest backoff delay before lockout
+	LockoutAfter  int           // failures after which the key is locked out
+	LockoutPeriod time.Duration // how long a lockout lasts
+	ResetAfter    time.Duration // failures are forgotten after this long without a new one
+}
+
+type loginAttempts struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// LoginGuard tracks failed login attempts per key (a username or an IP address)
+// and blocks further attempts with exponential backoff, then a temporary lockout.
+type LoginGuard struct {
+	mu       sync.Mutex
+	config   LoginGuardConfig
+	attempts map[string]*loginAttempts
+}
+
+func NewLoginGuard(config LoginGuardConfig) *LoginGuard {
+	return &LoginGuard{
+		config:   config,
+		attempts: map[string]*loginAttempts{},
+	}
+}
+
+// guards failed logins against a single account
+var UsernameLoginGuard = NewLoginGuard(LoginGuardConfig{
+	FreeAttempts:  3,
+	BaseDelay:     time.Second,
+	MaxDelay:      time.Minute,
+	LockoutAfter:  10,
+	LockoutPeriod: 15 * time.Minute,
+	ResetAfter:    time.Hour,
+})
+
+// guards failed logins from a single client, more leniently since clients may share an address
+var IPLoginGuard = NewLoginGuard(LoginGuardConfig{
+	FreeAttempts:  20,
+	BaseDelay:     time.Second,
+	MaxDelay:      time.Minute,
+	LockoutAfter:  100,
+	LockoutPeriod: 15 * time.Minute,
+	ResetAfter:    time.Hour,
+})
+
+// returns how long the key must wait before its next attempt, zero when it may try now
+func (g *LoginGuard) RetryAfter(key string, now time.Time) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	attempts, exists := g.attempts[key]
+	if !exists {
+		return 0
+	}
+
+	if now.Sub(attempts.lastFailure) > g.config.ResetAfter && now.After(attempts.blockedUntil) {
+		delete(g.attempts, key)
+		return 0
+	}
+
+	if now.Before(attempts.blockedUntil) {
+		return attempts.blockedUntil.Sub(now)
+	}
+	return 0
+}
+
+// records a failed attempt for the key
+func (g *LoginGuard) Failure(key string, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	attempts, exists := g.attempts[key]
+	if !exists || (now.Sub(attempts.lastFailure) > g.config.ResetAfter && now.After(attempts.blockedUntil)) {
+		attempts = &loginAttempts{}
+		g.attempts[key] = attempts
+	}
+
+	attempts.failures++
+	attempts.lastFailure = now
+
+	switch {
+	case attempts.failures >= g.config.LockoutAfter:
+		attempts.blockedUntil = now.Add(g.config.LockoutPeriod)
+	case attempts.failures > g.config.FreeAttempts:
+		delay := g.config.BaseDelay << (attempts.failures - g.config.FreeAttempts - 1)
+		if delay <= 0 || delay > g.config.MaxDelay {
+			delay = g.config.MaxDelay
+		}
+		attempts.blockedUntil = now.Add(delay)
+	}
+}
+
+// clears the failures recorded for the key, unlocking it
+func (g *LoginGuard) Reset(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(g.attempts, key)
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// supported password hashing algorithms
+const (
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmArgon2id = "argon2id"
+)
+
+type PasswordHashConfig struct {
+	Algorithm     string // algorithm used for new hashes
+	BcryptCost    int
+	Argon2Memory  uint32 // in KiB
+	Argon2Time    uint32 // number of passes
+	Argon2Threads uint8
+	Argon2KeyLen  uint32
+	Argon2SaltLen uint32
+}
+
+// PasswordHashing configures new password hashes. Stored hashes created with
+// other settings keep verifying and are upgraded on the next successful login.
+var PasswordHashing = PasswordHashConfig{
+	Algorithm:     AlgorithmArgon2id,
+	BcryptCost:    bcrypt.DefaultCost,
+	Argon2Memory:  19 * 1024,
+	Argon2Time:    2,
+	Argon2Threads: 1,
+	Argon2KeyLen:  32,
+	Argon2SaltLen: 16,
+}
+
+var ErrPasswordMismatch = errors.New("password does not match")
+
+var ErrUnknownHashFormat = errors.New("unknown password hash format")
+
+// parameters of a hash in the PHC string format:
+// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>
+type argon2Hash struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func hashArgon2id(password string, config PasswordHashConfig) (string, error) {
+	salt := make([]byte, config.Argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, config.Argon2Time, config.Argon2Memory, config.Argon2Threads, config.Argon2KeyLen)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, config.Argon2Memory, config.Argon2Time, config.Argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func parseArgon2id(hash string) (argon2Hash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != AlgorithmArgon2id {
+		return argon2Hash{}, ErrUnknownHashFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return argon2Hash{}, ErrUnknownHashFormat
+	}
+
+	parsed := argon2Hash{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &parsed.memory, &parsed.time, &parsed.threads); err != nil {
+		return argon2Hash{}, ErrUnknownHashFormat
+	}
+	// argon2 needs at least one pass and lane, and 8 KiB of memory per lane
+	if parsed.time == 0 || parsed.threads == 0 || parsed.memory < 8*uint32(parsed.threads) {
+		return argon2Hash{}, ErrUnknownHashFormat
+	}
+
+	var err error
+	if parsed.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return argon2Hash{}, ErrUnknownHashFormat
+	}
+	if parsed.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return argon2Hash{}, ErrUnknownHashFormat
+	}
+	if len(parsed.salt) == 0 || len(parsed.key) == 0 {
+		return argon2Hash{}, ErrUnknownHashFormat
+	}
+
+	return parsed, nil
+}
+
+// returns the algorithm a stored hash was created with
+func hashAlgorithm(hash string) string {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return AlgorithmArgon2id
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return AlgorithmBcrypt
+	default:
+		return ""
+	}
+}
+
+// NeedsRehash reports whether a stored hash was created with an outdated
+// algorithm or parameters and should be replaced
+func NeedsRehash(hash string) bool {
+	config := PasswordHashing
+
+	algorithm := hashAlgorithm(hash)
+	if algorithm == "" {
+		return false
+	}
+	if algorithm != config.Algorithm {
+		return true
+	}
+
+	switch algorithm {
+	case AlgorithmBcrypt:
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err == nil && cost != config.BcryptCost
+	case AlgorithmArgon2id:
+		parsed, err := parseArgon2id(hash)
+		return err == nil && (parsed.memory != config.Argon2Memory ||
+			parsed.time != config.Argon2Time ||
+			parsed.threads != config.Argon2Threads ||
+			uint32(len(parsed.key)) != config.Argon2KeyLen)
+	}
+	return false
+}
@@ -0,0 +1,25 @@
+package auth
+
+import "sync"
+
+// every access token carries the version its user had when it was issued.
+// Bumping the version revokes all tokens issued before.
+var tokenVersions = struct {
+	sync.Mutex
+	versions map[string]int
+}{versions: map[string]int{}}
+
+func tokenVersion(username string) int {
+	tokenVersions.Lock()
+	defer tokenVersions.Unlock()
+
+	return tokenVersions.versions[username]
+}
+
+// RevokeTokens invalidates every access token issued to username so far
+func RevokeTokens(username string) {
+	tokenVersions.Lock()
+	defer tokenVersions.Unlock()
+
+	tokenVersions.versions[username]++
+}
@@ -0,0 +1,16 @@
+package auth
+
+import "slices"
+
+// user roles, ordered from least to most privileged
+const (
+	RoleUser    = "user"
+	RoleSupport = "support"
+	RoleAdmin   = "admin"
+)
+
+var Roles = []string{RoleUser, RoleSupport, RoleAdmin}
+
+func IsValidRole(role string) bool {
+	return slices.Contains(Roles, role)
+}
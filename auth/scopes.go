@@ -0,0 +1,43 @@
+package auth
+
+import "slices"
+
+// scopes that can be granted to personal access tokens and OAuth clients
+const (
+	ScopeTodosRead    = "todos:read"
+	ScopeTodosWrite   = "todos:write"
+	ScopeProfileRead  = "profile:read"
+	ScopeProfileWrite = "profile:write"
+)
+
+var Scopes = []string{ScopeTodosRead, ScopeTodosWrite, ScopeProfileRead, ScopeProfileWrite}
+
+// PersonalAccessTokenPrefix lets the auth middleware tell personal access tokens apart from JWTs
+const PersonalAccessTokenPrefix = "tdp_"
+
+// OAuthAccessTokenPrefix marks access tokens issued to OAuth clients
+const OAuthAccessTokenPrefix = "tdo_"
+
+func IsValidScope(scope string) bool {
+	return slices.Contains(Scopes, scope)
+}
+
+// generates a new personal access token, shown to the user only once
+func GeneratePersonalAccessToken() (string, error) {
+	token, err := GenerateRandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	return PersonalAccessTokenPrefix + token, nil
+}
+
+// generates an access token for an OAuth client
+func GenerateOAuthAccessToken() (string, error) {
+	token, err := GenerateRandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	return OAuthAccessTokenPrefix + token, nil
+}
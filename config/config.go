@@ -0,0 +1,541 @@
+// Package config loads the server settings from defaults, a YAML or TOML file,
+// environment variables and command-line flags, each overriding the last
+package config
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/johnson-oragui/golang-todo-api/auth"
+	"github.com/johnson-oragui/golang-todo-api/mailer"
+	"github.com/johnson-oragui/golang-todo-api/ratelimit"
+	"github.com/johnson-oragui/golang-todo-api/tlsconfig"
+	"github.com/johnson-oragui/golang-todo-api/tracing"
+	"github.com/johnson-oragui/golang-todo-api/utils"
+)
+
+// Config holds every setting of the server. Each field is named in files by
+// its section and key, e.g. server.read_timeout, on the command line by the
+// same name with dashes, e.g. -server-read-timeout, and in the environment by
+// its env tag. Fields tagged secret are masked when the config is printed
+type Config struct {
+	Server    Server    `key:"server"`
+	TLS       TLS       `key:"tls"`
+	Auth      Auth      `key:"auth"`
+	Admin     Admin     `key:"admin"`
+	OIDC      OIDC      `key:"oidc"`
+	OAuth     OAuth     `key:"oauth"`
+	Mail      Mail      `key:"mail"`
+	CORS      CORS      `key:"cors"`
+	RateLimit RateLimit `key:"rate_limit"`
+	Log       Log       `key:"log"`
+	Metrics   Metrics   `key:"metrics"`
+	Tracing   Tracing   `key:"tracing"`
+}
+
+type Server struct {
+	Addr            string        `key:"addr" env:"LISTEN_ADDR" usage:"address the server listens on"`
+	BaseURL         string        `key:"base_url" env:"BASE_URL" usage:"public address of the API, used to build links sent to users"`
+	ReadTimeout     time.Duration `key:"read_timeout" env:"READ_TIMEOUT" usage:"longest time to read a request"`
+	WriteTimeout    time.Duration `key:"write_timeout" env:"WRITE_TIMEOUT" usage:"longest time to write a response"`
+	MaxHeaderBytes  int           `key:"max_header_bytes" env:"MAX_HEADER_BYTES" usage:"largest request header size in bytes"`
+	ShutdownTimeout time.Duration `key:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" usage:"how long requests in flight get to finish on shutdown"`
+	ShutdownDelay   time.Duration `key:"shutdown_delay" env:"SHUTDOWN_DELAY" usage:"how long readiness fails before the server stops accepting connections"`
+}
+
+// TLS is terminated by the server when CertFile is set
+type TLS struct {
+	CertFile       string        `key:"cert_file" env:"TLS_CERT_FILE" usage:"PEM certificate chain served to clients"`
+	KeyFile        string        `key:"key_file" env:"TLS_KEY_FILE" usage:"PEM private key of the certificate"`
+	MinVersion     string        `key:"min_version" env:"TLS_MIN_VERSION" usage:"lowest TLS version accepted, 1.2 or 1.3"`
+	CipherPolicy   string        `key:"cipher_policy" env:"TLS_CIPHER_POLICY" usage:"modern (TLS 1.3 only) or intermediate"`
+	HTTP2          bool          `key:"http2" env:"TLS_HTTP2" usage:"offer HTTP/2 to clients"`
+	ClientCAFile   string        `key:"client_ca_file" env:"TLS_CLIENT_CA_FILE" usage:"PEM CA bundle client certificates are verified against"`
+	ClientAuth     string        `key:"client_auth" env:"TLS_CLIENT_AUTH" usage:"none, optional or require client certificates"`
+	ReloadInterval time.Duration `key:"reload_interval" env:"TLS_RELOAD_INTERVAL" usage:"how often the certificate files are checked for changes, 0 never"`
+	RedirectAddr   string        `key:"redirect_addr" env:"TLS_REDIRECT_ADDR" usage:"address of a plain HTTP listener redirecting to HTTPS, off when empty"`
+}
+
+// Enabled reports whether the server serves HTTPS
+func (t TLS) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != ""
+}
+
+// Options returns the settings tlsconfig builds the server configuration from
+func (t TLS) Options() tlsconfig.Options {
+	return tlsconfig.Options{
+		CertFile:     t.CertFile,
+		KeyFile:      t.KeyFile,
+		MinVersion:   t.MinVersion,
+		CipherPolicy: t.CipherPolicy,
+		ClientCAFile: t.ClientCAFile,
+		ClientAuth:   t.ClientAuth,
+		HTTP2:        t.HTTP2,
+	}
+}
+
+type Auth struct {
+	JWTSecret                  string         `key:"jwt_secret" env:"JWT_SECRET" secret:"true" usage:"key access tokens are signed with, at least 32 bytes, random when empty"`
+	PasswordHashAlgorithm      string         `key:"password_hash_algorithm" env:"PASSWORD_HASH_ALGORITHM" usage:"argon2id or bcrypt"`
+	Argon2Memory               int            `key:"argon2_memory" env:"ARGON2_MEMORY" usage:"memory in KiB each argon2id hash takes"`
+	Argon2Time                 int            `key:"argon2_time" env:"ARGON2_TIME" usage:"number of passes of argon2id over the memory"`
+	Argon2Threads              int            `key:"argon2_threads" env:"ARGON2_THREADS" usage:"number of threads argon2id hashes with"`
+	BcryptCost                 int            `key:"bcrypt_cost" env:"BCRYPT_COST" usage:"cost of bcrypt hashes, each step doubling the work"`
+	BreachedPasswordsFile      string         `key:"breached_passwords_file" env:"BREACHED_PASSWORDS_FILE" usage:"file of breached passwords users may not choose"`
+	PasswordPolicy             PasswordPolicy `key:"password_policy"`
+	RequireVerifiedEmail       bool           `key:"require_verified_email" env:"REQUIRE_VERIFIED_EMAIL" usage:"block todo creation until the email address is verified"`
+	EmailVerificationTTL       time.Duration  `key:"email_verification_ttl" env:"EMAIL_VERIFICATION_TTL" usage:"how long an email verification link stays valid"`
+	PasswordResetTTL           time.Duration  `key:"password_reset_ttl" env:"PASSWORD_RESET_TTL" usage:"how long a password reset token stays valid"`
+	TOTPIssuer                 string         `key:"totp_issuer" env:"TOTP_ISSUER" usage:"account issuer shown in authenticator apps"`
+	RecoveryCodes              int            `key:"recovery_codes" env:"RECOVERY_CODES" usage:"number of recovery codes issued when 2FA is enabled"`
+	UsernameCooldown           time.Duration  `key:"username_cooldown" env:"USERNAME_COOLDOWN" usage:"how long a username given up by a rename stays reserved"`
+	AccountDeletionGracePeriod time.Duration  `key:"account_deletion_grace_period" env:"ACCOUNT_DELETION_GRACE_PERIOD" usage:"delay before a deleted account is removed, 0 removes it right away"`
+	AccountDeletionInterval    time.Duration  `key:"account_deletion_interval" env:"ACCOUNT_DELETION_INTERVAL" usage:"how often accounts past their grace period are looked for"`
+}
+
+// PasswordPolicy is what new passwords are checked against, a subsection of
+// auth, e.g. auth.password_policy.min_length
+type PasswordPolicy struct {
+	MinLength        int     `key:"min_length" env:"PASSWORD_MIN_LENGTH" usage:"fewest characters a new password may have"`
+	MaxLength        int     `key:"max_length" env:"PASSWORD_MAX_LENGTH" usage:"most characters a new password may have, 0 allows up to the 1024 logins accept"`
+	MinCharClasses   int     `key:"min_char_classes" env:"PASSWORD_MIN_CHAR_CLASSES" usage:"how many of lowercase letters, uppercase letters, digits and symbols a new password needs"`
+	PassphraseLength int     `key:"passphrase_length" env:"PASSWORD_PASSPHRASE_LENGTH" usage:"new passwords this long need no character classes, 0 never"`
+	MinEntropyBits   float64 `key:"min_entropy_bits" env:"PASSWORD_MIN_ENTROPY_BITS" usage:"lowest estimated entropy of a new password, 0 skips the check"`
+	CheckBreached    bool    `key:"check_breached" env:"PASSWORD_CHECK_BREACHED" usage:"reject new passwords on the breached password list"`
+}
+
+// Policy returns the policy utils validates new passwords with
+func (p PasswordPolicy) Policy() utils.PasswordPolicyConfig {
+	return utils.PasswordPolicyConfig{
+		MinLength:        p.MinLength,
+		MaxLength:        p.MaxLength,
+		MinCharClasses:   p.MinCharClasses,
+		PassphraseLength: p.PassphraseLength,
+		MinEntropyBits:   p.MinEntropyBits,
+		CheckBreached:    p.CheckBreached,
+	}
+}
+
+// Admin is the first administrator, created at startup when Username is set
+type Admin struct {
+	Username string `key:"username" env:"ADMIN_USERNAME" usage:"username of the administrator created at startup"`
+	Email    string `key:"email" env:"ADMIN_EMAIL" usage:"email address of the administrator"`
+	Password string `key:"password" env:"ADMIN_PASSWORD" secret:"true" usage:"password of the administrator"`
+}
+
+// OIDC is the identity provider users can sign in with, off when Issuer is empty
+type OIDC struct {
+	Issuer       string        `key:"issuer" env:"OIDC_ISSUER" usage:"issuer URL of the OpenID Connect provider"`
+	ClientID     string        `key:"client_id" env:"OIDC_CLIENT_ID" usage:"client ID registered with the provider"`
+	ClientSecret string        `key:"client_secret" env:"OIDC_CLIENT_SECRET" secret:"true" usage:"client secret registered with the provider"`
+	RedirectURL  string        `key:"redirect_url" env:"OIDC_REDIRECT_URL" usage:"callback URL registered with the provider, derived from server.base_url when empty"`
+	LoginTTL     time.Duration `key:"login_ttl" env:"OIDC_LOGIN_TTL" usage:"how long a user has to complete a sign in at the provider"`
+}
+
+// OAuth is the authorization server issuing tokens to registered clients
+type OAuth struct {
+	CodeTTL        time.Duration `key:"code_ttl" env:"OAUTH_CODE_TTL" usage:"how long a client has to redeem an authorization code"`
+	AccessTokenTTL time.Duration `key:"access_token_ttl" env:"OAUTH_ACCESS_TOKEN_TTL" usage:"how long access tokens issued to clients stay valid"`
+}
+
+// Mail delivers verification and password reset emails, which are dropped
+// unless Transport is smtp
+type Mail struct {
+	Transport    string        `key:"transport" env:"MAIL_TRANSPORT" usage:"log (emails are dropped) or smtp"`
+	From         string        `key:"from" env:"MAIL_FROM" usage:"sender address of the emails"`
+	SMTPHost     string        `key:"smtp_host" env:"SMTP_HOST" usage:"SMTP server host"`
+	SMTPPort     int           `key:"smtp_port" env:"SMTP_PORT" usage:"SMTP server port"`
+	SMTPUsername string        `key:"smtp_username" env:"SMTP_USERNAME" usage:"SMTP username, no authentication when empty"`
+	SMTPPassword string        `key:"smtp_password" env:"SMTP_PASSWORD" secret:"true" usage:"SMTP password"`
+	SMTPTimeout  time.Duration `key:"smtp_timeout" env:"SMTP_TIMEOUT" usage:"longest time to spend delivering an email"`
+}
+
+// CORS lets browser front ends on other origins call the API, off when AllowedOrigins is empty
+type CORS struct {
+	AllowedOrigins   []string      `key:"allowed_origins" env:"CORS_ALLOWED_ORIGINS" usage:"origins allowed to call the API, * for any"`
+	AllowedMethods   []string      `key:"allowed_methods" env:"CORS_ALLOWED_METHODS" usage:"methods allowed in cross-origin requests"`
+	AllowedHeaders   []string      `key:"allowed_headers" env:"CORS_ALLOWED_HEADERS" usage:"request headers allowed in cross-origin requests, * for any"`
+	AllowCredentials bool          `key:"allow_credentials" env:"CORS_ALLOW_CREDENTIALS" usage:"let browsers send cookies"`
+	MaxAge           time.Duration `key:"max_age" env:"CORS_MAX_AGE" usage:"how long browsers may cache a preflight response"`
+}
+
+// RateLimit caps the requests each user, or each IP address before sign in, can
+// make to a route. Limits are written as requests/period, such as 20/1m, and an
+// empty limit lets every request through
+type RateLimit struct {
+	Default string   `key:"default" env:"RATE_LIMIT_DEFAULT" usage:"limit of each route without a limit of its own"`
+	IP      string   `key:"ip" env:"RATE_LIMIT_IP" usage:"limit of each IP address across the routes needing a token, checked before the token"`
+	Routes  []string `key:"routes" env:"RATE_LIMIT_ROUTES" usage:"limits of single routes, such as /api/v1/auth/login=20/1m"`
+}
+
+// Limits returns the limit of each route template, routes that are not listed
+// or have a malformed limit are left out
+func (r RateLimit) Limits() map[string]ratelimit.Limit {
+	limits := map[string]ratelimit.Limit{}
+	for _, entry := range r.Routes {
+		route, text, _ := strings.Cut(entry, "=")
+		if limit, err := ratelimit.ParseLimit(text); err == nil {
+			limits[strings.TrimSpace(route)] = limit
+		}
+	}
+	return limits
+}
+
+type Log struct {
+	Level string `key:"level" env:"LOG_LEVEL" usage:"debug, info, warn or error"`
+}
+
+// Metrics is how Prometheus scrapes /metrics
+type Metrics struct {
+	Addr        string `key:"addr" env:"METRICS_ADDR" usage:"address of a separate listener serving /metrics, such as 127.0.0.1:9090, served with the API when empty"`
+	BearerToken string `key:"bearer_token" env:"METRICS_BEARER_TOKEN" secret:"true" usage:"token scrapers must send as a bearer token, none when empty"`
+}
+
+type Tracing struct {
+	Exporter    string `key:"exporter" env:"OTEL_TRACES_EXPORTER" usage:"none, otlp, stdout or console, another name for stdout"`
+	ServiceName string `key:"service_name" env:"OTEL_SERVICE_NAME" usage:"service name traces are reported under"`
+}
+
+// Default returns the settings used when nothing overrides them
+func Default() Config {
+	return Config{
+		Server: Server{
+			Addr:            ":5000",
+			BaseURL:         "http://localhost:5000",
+			ReadTimeout:     10 * time.Second,
+			WriteTimeout:    10 * time.Second,
+			MaxHeaderBytes:  1 << 20,
+			ShutdownTimeout: 30 * time.Second,
+		},
+		TLS: TLS{
+			MinVersion:     "1.2",
+			CipherPolicy:   tlsconfig.PolicyIntermediate,
+			HTTP2:          true,
+			ClientAuth:     tlsconfig.ClientAuthNone,
+			ReloadInterval: 30 * time.Second,
+		},
+		Auth: Auth{
+			PasswordHashAlgorithm:   auth.AlgorithmArgon2id,
+			Argon2Memory:            19 * 1024,
+			Argon2Time:              2,
+			Argon2Threads:           1,
+			BcryptCost:              bcrypt.DefaultCost,
+			EmailVerificationTTL:    24 * time.Hour,
+			PasswordResetTTL:        time.Hour,
+			TOTPIssuer:              "golang-todo-api",
+			RecoveryCodes:           10,
+			UsernameCooldown:        30 * 24 * time.Hour,
+			AccountDeletionInterval: time.Minute,
+			PasswordPolicy: PasswordPolicy{
+				MinLength:        8,
+				MaxLength:        128,
+				MinCharClasses:   3,
+				PassphraseLength: 16,
+				MinEntropyBits:   40,
+				CheckBreached:    true,
+			},
+		},
+		OIDC: OIDC{
+			LoginTTL: 10 * time.Minute,
+		},
+		OAuth: OAuth{
+			CodeTTL:        time.Minute,
+			AccessTokenTTL: time.Hour,
+		},
+		Mail: Mail{
+			Transport:   mailer.TransportLog,
+			SMTPPort:    587,
+			SMTPTimeout: 10 * time.Second,
+		},
+		CORS: CORS{
+			AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete},
+			AllowedHeaders: []string{"Authorization", "Content-Type", "X-Request-ID", "traceparent", "tracestate"},
+			MaxAge:         10 * time.Minute,
+		},
+		RateLimit: RateLimit{
+			Default: "300/1m",
+			IP:      "600/1m",
+			Routes: []string{
+				"/api/v1/auth/register=5/1h",
+				"/api/v1/auth/login=20/1m",
+				"/api/v1/auth/login/mfa=20/1m",
+				"/api/v1/auth/password-reset/confirm=20/1m",
+				"/api/v1/oauth/token=60/1m",
+				"/api/v1/users/todos=120/1m",
+			},
+		},
+		Log: Log{
+			Level: "info",
+		},
+		Tracing: Tracing{
+			Exporter:    tracing.ExporterNone,
+			ServiceName: "todo-api",
+		},
+	}
+}
+
+// Validate reports every setting that is out of range or malformed
+func (c Config) Validate() error {
+	var errs []error
+	invalid := func(setting string, format string, v ...any) {
+		errs = append(errs, fmt.Errorf("%v: %v", setting, fmt.Sprintf(format, v...)))
+	}
+
+	if _, _, err := net.SplitHostPort(c.Server.Addr); err != nil {
+		invalid("server.addr", "%v", err)
+	}
+	if !absoluteURL(c.Server.BaseURL) {
+		invalid("server.base_url", "must be an absolute http or https URL, got %q", c.Server.BaseURL)
+	}
+	if c.Server.ReadTimeout <= 0 {
+		invalid("server.read_timeout", "must be positive, got %v", c.Server.ReadTimeout)
+	}
+	if c.Server.WriteTimeout <= 0 {
+		invalid("server.write_timeout", "must be positive, got %v", c.Server.WriteTimeout)
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		invalid("server.shutdown_timeout", "must be positive, got %v", c.Server.ShutdownTimeout)
+	}
+	if c.Server.ShutdownDelay < 0 {
+		invalid("server.shutdown_delay", "must not be negative, got %v", c.Server.ShutdownDelay)
+	}
+	if c.Server.MaxHeaderBytes <= 0 {
+		invalid("server.max_header_bytes", "must be positive, got %v", c.Server.MaxHeaderBytes)
+	}
+
+	if c.TLS.Enabled() {
+		if c.TLS.CertFile == "" {
+			invalid("tls.cert_file", "is required when tls.key_file is set")
+		}
+		if c.TLS.KeyFile == "" {
+			invalid("tls.key_file", "is required when tls.cert_file is set")
+		}
+	}
+	if _, err := tlsconfig.ParseMinVersion(c.TLS.MinVersion); err != nil {
+		invalid("tls.min_version", "must be 1.2 or 1.3, got %q", c.TLS.MinVersion)
+	}
+	switch c.TLS.CipherPolicy {
+	case tlsconfig.PolicyModern:
+		if c.TLS.MinVersion != "1.3" {
+			invalid("tls.cipher_policy", "%v needs tls.min_version 1.3", tlsconfig.PolicyModern)
+		}
+	case tlsconfig.PolicyIntermediate:
+	default:
+		invalid("tls.cipher_policy", "must be %v or %v, got %q", tlsconfig.PolicyModern, tlsconfig.PolicyIntermediate, c.TLS.CipherPolicy)
+	}
+	if clientAuth, err := tlsconfig.ParseClientAuth(c.TLS.ClientAuth); err != nil {
+		invalid("tls.client_auth", "must be %v, %v or %v, got %q", tlsconfig.ClientAuthNone, tlsconfig.ClientAuthOptional, tlsconfig.ClientAuthRequire, c.TLS.ClientAuth)
+	} else if clientAuth != tls.NoClientCert && c.TLS.ClientCAFile == "" {
+		invalid("tls.client_ca_file", "is required to verify client certificates")
+	}
+	if c.TLS.ClientCAFile != "" && !c.TLS.Enabled() {
+		invalid("tls.client_ca_file", "needs tls.cert_file and tls.key_file")
+	}
+	if c.TLS.ReloadInterval < 0 {
+		invalid("tls.reload_interval", "must not be negative, got %v", c.TLS.ReloadInterval)
+	}
+	if c.TLS.RedirectAddr != "" {
+		if !c.TLS.Enabled() {
+			invalid("tls.redirect_addr", "needs tls.cert_file and tls.key_file")
+		}
+		if _, _, err := net.SplitHostPort(c.TLS.RedirectAddr); err != nil {
+			invalid("tls.redirect_addr", "%v", err)
+		}
+	}
+
+	if c.Auth.JWTSecret != "" && len(c.Auth.JWTSecret) < auth.MinJWTSecretBytes {
+		invalid("auth.jwt_secret", "must be at least %v bytes long", auth.MinJWTSecretBytes)
+	}
+	if c.Auth.PasswordHashAlgorithm != auth.AlgorithmArgon2id && c.Auth.PasswordHashAlgorithm != auth.AlgorithmBcrypt {
+		invalid("auth.password_hash_algorithm", "must be %v or %v, got %q", auth.AlgorithmArgon2id, auth.AlgorithmBcrypt, c.Auth.PasswordHashAlgorithm)
+	}
+	if c.Auth.Argon2Threads < 1 || c.Auth.Argon2Threads > 255 {
+		invalid("auth.argon2_threads", "must be between 1 and 255, got %v", c.Auth.Argon2Threads)
+	}
+	if c.Auth.Argon2Memory < 8*c.Auth.Argon2Threads || int64(c.Auth.Argon2Memory) > math.MaxUint32 {
+		invalid("auth.argon2_memory", "must be at least 8 KiB per thread, got %v", c.Auth.Argon2Memory)
+	}
+	if c.Auth.Argon2Time < 1 || int64(c.Auth.Argon2Time) > math.MaxUint32 {
+		invalid("auth.argon2_time", "must be at least 1, got %v", c.Auth.Argon2Time)
+	}
+	if c.Auth.BcryptCost < bcrypt.MinCost || c.Auth.BcryptCost > bcrypt.MaxCost {
+		invalid("auth.bcrypt_cost", "must be between %v and %v, got %v", bcrypt.MinCost, bcrypt.MaxCost, c.Auth.BcryptCost)
+	}
+	policy := c.Auth.PasswordPolicy
+	if policy.MinLength < 1 {
+		invalid("auth.password_policy.min_length", "must be at least 1, got %v", policy.MinLength)
+	}
+	if policy.MaxLength != 0 && (policy.MaxLength < policy.MinLength || policy.MaxLength > 1024) {
+		invalid("auth.password_policy.max_length", "must be 0 or between min_length and 1024, got %v", policy.MaxLength)
+	}
+	if policy.MinCharClasses < 0 || policy.MinCharClasses > 4 {
+		invalid("auth.password_policy.min_char_classes", "must be between 0 and 4, got %v", policy.MinCharClasses)
+	}
+	if policy.PassphraseLength < 0 {
+		invalid("auth.password_policy.passphrase_length", "must not be negative, got %v", policy.PassphraseLength)
+	}
+	if policy.MinEntropyBits < 0 {
+		invalid("auth.password_policy.min_entropy_bits", "must not be negative, got %v", policy.MinEntropyBits)
+	}
+	if c.Auth.EmailVerificationTTL <= 0 {
+		invalid("auth.email_verification_ttl", "must be positive, got %v", c.Auth.EmailVerificationTTL)
+	}
+	if c.Auth.PasswordResetTTL <= 0 {
+		invalid("auth.password_reset_ttl", "must be positive, got %v", c.Auth.PasswordResetTTL)
+	}
+	if c.Auth.TOTPIssuer == "" || strings.Contains(c.Auth.TOTPIssuer, ":") {
+		invalid("auth.totp_issuer", "must be a name without colons, got %q", c.Auth.TOTPIssuer)
+	}
+	if c.Auth.RecoveryCodes < 1 || c.Auth.RecoveryCodes > 100 {
+		invalid("auth.recovery_codes", "must be between 1 and 100, got %v", c.Auth.RecoveryCodes)
+	}
+	if c.Auth.UsernameCooldown < 0 {
+		invalid("auth.username_cooldown", "must not be negative, got %v", c.Auth.UsernameCooldown)
+	}
+	if c.Auth.AccountDeletionGracePeriod < 0 {
+		invalid("auth.account_deletion_grace_period", "must not be negative, got %v", c.Auth.AccountDeletionGracePeriod)
+	}
+	if c.Auth.AccountDeletionInterval <= 0 {
+		invalid("auth.account_deletion_interval", "must be positive, got %v", c.Auth.AccountDeletionInterval)
+	}
+
+	if c.Admin.Username != "" && c.Admin.Password == "" {
+		invalid("admin.password", "is required to create the administrator %v", c.Admin.Username)
+	}
+
+	if c.OIDC.Issuer != "" {
+		if !absoluteURL(c.OIDC.Issuer) {
+			invalid("oidc.issuer", "must be an absolute http or https URL, got %q", c.OIDC.Issuer)
+		}
+		if c.OIDC.ClientID == "" {
+			invalid("oidc.client_id", "is required when oidc.issuer is set")
+		}
+		if c.OIDC.RedirectURL != "" && !absoluteURL(c.OIDC.RedirectURL) {
+			invalid("oidc.redirect_url", "must be an absolute http or https URL, got %q", c.OIDC.RedirectURL)
+		}
+	}
+
+	if c.OIDC.LoginTTL <= 0 {
+		invalid("oidc.login_ttl", "must be positive, got %v", c.OIDC.LoginTTL)
+	}
+
+	if c.OAuth.CodeTTL <= 0 {
+		invalid("oauth.code_ttl", "must be positive, got %v", c.OAuth.CodeTTL)
+	}
+	if c.OAuth.AccessTokenTTL <= 0 {
+		invalid("oauth.access_token_ttl", "must be positive, got %v", c.OAuth.AccessTokenTTL)
+	}
+
+	switch c.Mail.Transport {
+	case mailer.TransportLog:
+	case mailer.TransportSMTP:
+		if c.Mail.SMTPHost == "" {
+			invalid("mail.smtp_host", "is required when mail.transport is %v", mailer.TransportSMTP)
+		}
+		if _, err := mail.ParseAddress(c.Mail.From); err != nil {
+			invalid("mail.from", "must be an email address, got %q", c.Mail.From)
+		}
+		if c.Mail.SMTPPort <= 0 || c.Mail.SMTPPort > 65535 {
+			invalid("mail.smtp_port", "must be a port number, got %v", c.Mail.SMTPPort)
+		}
+		if c.Mail.SMTPTimeout <= 0 {
+			invalid("mail.smtp_timeout", "must be positive, got %v", c.Mail.SMTPTimeout)
+		}
+	default:
+		invalid("mail.transport", "must be %v or %v, got %q", mailer.TransportLog, mailer.TransportSMTP, c.Mail.Transport)
+	}
+
+	for _, origin := range c.CORS.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		if parsed, err := url.Parse(origin); err != nil || !absoluteURL(origin) || parsed.Path != "" || parsed.RawQuery != "" {
+			invalid("cors.allowed_origins", "%q is not an origin such as https://app.example.com", origin)
+		}
+	}
+	if c.CORS.MaxAge < 0 {
+		invalid("cors.max_age", "must not be negative, got %v", c.CORS.MaxAge)
+	}
+
+	if c.RateLimit.Default != "" {
+		if _, err := ratelimit.ParseLimit(c.RateLimit.Default); err != nil {
+			invalid("rate_limit.default", "%v", err)
+		}
+	}
+	if c.RateLimit.IP != "" {
+		if _, err := ratelimit.ParseLimit(c.RateLimit.IP); err != nil {
+			invalid("rate_limit.ip", "%v", err)
+		}
+	}
+	for _, entry := range c.RateLimit.Routes {
+		route, text, found := strings.Cut(entry, "=")
+		if !found || !strings.HasPrefix(strings.TrimSpace(route), "/") {
+			invalid("rate_limit.routes", "%q is not a route and limit such as /api/v1/auth/login=20/1m", entry)
+		} else if _, err := ratelimit.ParseLimit(text); err != nil {
+			invalid("rate_limit.routes", "%v: %v", strings.TrimSpace(route), err)
+		}
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		invalid("log.level", "must be debug, info, warn or error, got %q", c.Log.Level)
+	}
+
+	if c.Metrics.Addr != "" {
+		if _, _, err := net.SplitHostPort(c.Metrics.Addr); err != nil {
+			invalid("metrics.addr", "%v", err)
+		} else if c.Metrics.Addr == c.Server.Addr || c.Metrics.Addr == c.TLS.RedirectAddr {
+			invalid("metrics.addr", "must differ from the address of the other listeners, got %q", c.Metrics.Addr)
+		}
+	}
+
+	switch c.Tracing.Exporter {
+	case "", tracing.ExporterNone, tracing.ExporterOTLP, tracing.ExporterStdout, tracing.ExporterConsole:
+	default:
+		invalid("tracing.exporter", "must be %v, %v, %v or %v, got %q", tracing.ExporterNone, tracing.ExporterOTLP, tracing.ExporterStdout, tracing.ExporterConsole, c.Tracing.Exporter)
+	}
+
+	return errors.Join(errs...)
+}
+
+func absoluteURL(raw string) bool {
+	parsed, err := url.Parse(raw)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+// Print writes every setting and its value, one per line, with secrets masked
+func (c Config) Print(w io.Writer) {
+	for _, setting := range c.settings() {
+		fmt.Fprintf(w, "%v = %v\n", setting.path, setting.masked())
+	}
+}
+
+// LogValue logs the settings grouped by section, with secrets masked
+func (c Config) LogValue() slog.Value {
+	sections := map[string][]slog.Attr{}
+	order := []string{}
+	for _, setting := range c.settings() {
+		if _, exists := sections[setting.section]; !exists {
+			order = append(order, setting.section)
+		}
+		sections[setting.section] = append(sections[setting.section], slog.String(setting.key, setting.masked()))
+	}
+
+	attrs := []slog.Attr{}
+	for _, section := range order {
+		attrs = append(attrs, slog.Attr{Key: section, Value: slog.GroupValue(sections[section]...)})
+	}
+	return slog.GroupValue(attrs...)
+}
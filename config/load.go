@@ -0,0 +1,283 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv names the config file when the -config flag is not given
+const FileEnv = "CONFIG_FILE"
+
+// ErrPrinted is returned by Load once -print-config has printed the
+// configuration, as flag.ErrHelp is after -help
+var ErrPrinted = errors.New("configuration printed")
+
+// Load builds the configuration from the defaults, the file named by -config
+// or CONFIG_FILE, the environment and the command-line flags in args, each
+// overriding the one before, and validates the result
+func Load(args []string) (Config, error) {
+	config := Default()
+	settings := config.settings()
+
+	flags := flag.NewFlagSet("todo-api", flag.ContinueOnError)
+	file := flags.String("config", os.Getenv(FileEnv), "YAML or TOML file to read the settings from")
+	printConfig := flags.Bool("print-config", false, "print the configuration with secrets masked and exit")
+	// flags are applied last, so they are only collected while parsing
+	flagged := map[string]string{}
+	for _, setting := range settings {
+		flags.Var(&flagValue{setting: setting, flagged: flagged}, setting.flagName(), setting.usage)
+	}
+	if err := flags.Parse(args); err != nil {
+		return config, err
+	}
+
+	if *file != "" {
+		if err := loadFile(*file, settings); err != nil {
+			return config, err
+		}
+	}
+
+	for _, setting := range settings {
+		if setting.env == "" {
+			continue
+		}
+		if text := os.Getenv(setting.env); text != "" {
+			if err := setting.set(text); err != nil {
+				return config, fmt.Errorf("%v: %w", setting.env, err)
+			}
+		}
+	}
+
+	for _, setting := range settings {
+		if text, ok := flagged[setting.path]; ok {
+			if err := setting.set(text); err != nil {
+				return config, fmt.Errorf("-%v: %w", setting.flagName(), err)
+			}
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		return config, err
+	}
+	if *printConfig {
+		config.Print(os.Stdout)
+		return config, ErrPrinted
+	}
+	return config, nil
+}
+
+// reads a YAML file, or a TOML file when its name ends in .toml
+func loadFile(path string, settings []setting) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	values := map[string]any{}
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		err = toml.Unmarshal(data, &values)
+	} else {
+		err = yaml.Unmarshal(data, &values)
+	}
+	if err != nil {
+		return fmt.Errorf("%v: %w", path, err)
+	}
+
+	known := map[string]setting{}
+	for _, setting := range settings {
+		known[setting.path] = setting
+	}
+
+	for section, entries := range values {
+		entries, ok := entries.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%v: %v must be a section of settings", path, section)
+		}
+		if err := loadSection(path, section, entries, known); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sets the settings of a section, or of a subsection such as auth.password_policy
+func loadSection(path, prefix string, entries map[string]any, known map[string]setting) error {
+	for key, value := range entries {
+		if subsection, ok := value.(map[string]any); ok {
+			if err := loadSection(path, prefix+"."+key, subsection, known); err != nil {
+				return err
+			}
+			continue
+		}
+		setting, exists := known[prefix+"."+key]
+		if !exists {
+			return fmt.Errorf("%v: unknown setting %v.%v", path, prefix, key)
+		}
+		if err := setting.setValue(value); err != nil {
+			return fmt.Errorf("%v: %v: %w", path, setting.path, err)
+		}
+	}
+	return nil
+}
+
+// setting is one field of Config, found through its tags
+type setting struct {
+	section string
+	key     string // subsection.key for the settings of a subsection
+	path    string // section.key
+	env     string
+	usage   string
+	secret  bool
+	value   reflect.Value
+}
+
+// the settings of c, in the order of the fields
+func (c *Config) settings() []setting {
+	settings := []setting{}
+	sections := reflect.ValueOf(c).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Type().Field(i).Tag.Get("key")
+		settings = appendSettings(settings, section, "", sections.Field(i))
+	}
+	return settings
+}
+
+// appends the fields of a section, descending into subsections, whose keys
+// are prefixed with the subsection key
+func appendSettings(settings []setting, section, prefix string, fields reflect.Value) []setting {
+	for j := 0; j < fields.NumField(); j++ {
+		field := fields.Type().Field(j)
+		key := prefix + field.Tag.Get("key")
+		if field.Type.Kind() == reflect.Struct {
+			settings = appendSettings(settings, section, key+".", fields.Field(j))
+			continue
+		}
+		settings = append(settings, setting{
+			section: section,
+			key:     key,
+			path:    section + "." + key,
+			env:     field.Tag.Get("env"),
+			usage:   field.Tag.Get("usage"),
+			secret:  field.Tag.Get("secret") == "true",
+			value:   fields.Field(j),
+		})
+	}
+	return settings
+}
+
+// server.read_timeout is set with -server-read-timeout
+func (s setting) flagName() string {
+	return strings.ReplaceAll(strings.ReplaceAll(s.path, ".", "-"), "_", "-")
+}
+
+// parses text as the type of the setting, lists are comma separated
+func (s setting) set(text string) error {
+	switch s.value.Interface().(type) {
+	case string:
+		s.value.SetString(text)
+	case bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", text)
+		}
+		s.value.SetBool(b)
+	case int:
+		i, err := strconv.Atoi(text)
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number", text)
+		}
+		s.value.SetInt(int64(i))
+	case float64:
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", text)
+		}
+		s.value.SetFloat(f)
+	case time.Duration:
+		d, err := time.ParseDuration(text)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration such as 30s or 5m", text)
+		}
+		s.value.SetInt(int64(d))
+	case []string:
+		items := []string{}
+		for _, item := range strings.Split(text, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		s.value.Set(reflect.ValueOf(items))
+	}
+	return nil
+}
+
+// sets a value decoded from a file, where lists are lists rather than comma separated
+func (s setting) setValue(value any) error {
+	list, isList := value.([]any)
+	if _, wantsList := s.value.Interface().([]string); wantsList && isList {
+		items := []string{}
+		for _, item := range list {
+			items = append(items, fmt.Sprint(item))
+		}
+		s.value.Set(reflect.ValueOf(items))
+		return nil
+	}
+	if isList || value == nil {
+		return fmt.Errorf("expected a single value, got %v", value)
+	}
+	return s.set(fmt.Sprint(value))
+}
+
+func (s setting) String() string {
+	switch value := s.value.Interface().(type) {
+	case []string:
+		return strings.Join(value, ",")
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+func (s setting) masked() string {
+	if s.secret && !s.value.IsZero() {
+		return "********"
+	}
+	return s.String()
+}
+
+// collects a setting from the command line
+type flagValue struct {
+	setting setting
+	flagged map[string]string
+}
+
+func (f *flagValue) Set(text string) error {
+	// check the value now so the error names the flag
+	if err := f.setting.set(text); err != nil {
+		return err
+	}
+	f.flagged[f.setting.path] = text
+	return nil
+}
+
+// the default shown by -help
+func (f *flagValue) String() string {
+	if f.flagged == nil || f.setting.secret {
+		return ""
+	}
+	return f.setting.String()
+}
+
+// lets -auth-require-verified-email stand for -auth-require-verified-email=true
+func (f *flagValue) IsBoolFlag() bool {
+	return f.flagged != nil && f.setting.value.Kind() == reflect.Bool
+}
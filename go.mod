@@ -7,3 +7,56 @@ require github.com/gorilla/mux v1.8.1
 require golang.org/x/crypto v0.28.0
 
 require github.com/golang-jwt/jwt v3.2.2+incompatible
+
+require github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+
+require github.com/prometheus/client_golang v1.20.5
+
+require go.opentelemetry.io/otel v1.31.0
+
+require go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.31.0
+
+require go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.31.0
+
+require go.opentelemetry.io/otel/sdk v1.31.0
+
+require go.opentelemetry.io/otel/trace v1.31.0
+
+require github.com/andybalholm/brotli v1.1.1
+
+require github.com/klauspost/compress v1.17.11
+
+require github.com/vmihailenco/msgpack/v5 v5.4.1
+
+require github.com/fxamacker/cbor/v2 v2.7.0
+
+require gopkg.in/yaml.v3 v3.0.1
+
+require github.com/BurntSushi/toml v1.4.0
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cenkalti/backoff/v4 v4.3.0 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/go-logr/logr v1.4.2 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/grpc-ecosystem/grpc-gateway/v2 v2.22.0 // indirect
+	github.com/kylelemons/godebug v1.1.0 // indirect
+	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
+	github.com/prometheus/client_model v0.6.1 // indirect
+	github.com/prometheus/common v0.55.0 // indirect
+	github.com/prometheus/procfs v0.15.1 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace v1.31.0 // indirect
+	go.opentelemetry.io/otel/metric v1.31.0 // indirect
+	go.opentelemetry.io/proto/otlp v1.3.1 // indirect
+	golang.org/x/net v0.30.0 // indirect
+	golang.org/x/sys v0.26.0 // indirect
+	golang.org/x/text v0.19.0 // indirect
+	google.golang.org/genproto/googleapis/api v0.0.0-20241007155032-5fefd90f89a9 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20241007155032-5fefd90f89a9 // indirect
+	google.golang.org/grpc v1.67.1 // indirect
+	google.golang.org/protobuf v1.35.1 // indirect
+)
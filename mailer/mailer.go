@@ -0,0 +1,146 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// transports selectable in the configuration
+const (
+	TransportLog  = "log"
+	TransportSMTP = "smtp"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers emails to users
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// LogMailer drops emails instead of delivering them. Bodies hold verification
+// links and reset tokens, so only the recipient and subject are logged, at debug level
+type LogMailer struct{}
+
+func (LogMailer) Send(msg Message) error {
+	slog.Debug("email not delivered, no mail transport is configured", "to", msg.To, "subject", msg.Subject)
+	return nil
+}
+
+// SMTPMailer delivers emails through an SMTP server, upgrading the connection
+// with STARTTLS when the server offers it
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string // no authentication when empty
+	Password string
+	From     string
+	Timeout  time.Duration // for the whole exchange with the server, none when 0
+}
+
+func (m SMTPMailer) Send(msg Message) error {
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return errors.New("email headers cannot contain line breaks")
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(m.Host, strconv.Itoa(m.Port)), m.Timeout)
+	if err != nil {
+		return err
+	}
+	if m.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(m.Timeout))
+	}
+
+	client, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: m.Host}); err != nil {
+			return err
+		}
+	}
+	if m.Username != "" {
+		// PlainAuth refuses to send the password over an unencrypted connection
+		if err := client.Auth(smtp.PlainAuth("", m.Username, m.Password, m.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(m.From); err != nil {
+		return err
+	}
+	if err := client.Rcpt(msg.To); err != nil {
+		return err
+	}
+	data, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := data.Write(m.compose(msg)); err != nil {
+		return err
+	}
+	if err := data.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// formats msg as a plain text email
+func (m SMTPMailer) compose(msg Message) []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "From: %v\r\n", m.From)
+	fmt.Fprintf(buf, "To: %v\r\n", msg.To)
+	fmt.Fprintf(buf, "Subject: %v\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(buf, "Date: %v\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	buf.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return buf.Bytes()
+}
+
+// MemoryMailer keeps sent emails in memory, useful for tests
+type MemoryMailer struct {
+	mu       sync.Mutex
+	Messages []Message
+}
+
+func (m *MemoryMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Messages = append(m.Messages, msg)
+	return nil
+}
+
+// returns the most recent email sent to the address
+func (m *MemoryMailer) Last(to string) (Message, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.Messages) - 1; i >= 0; i-- {
+		if m.Messages[i].To == to {
+			return m.Messages[i], true
+		}
+	}
+	return Message{}, false
+}
+
+// Default is the mailer used by the route handlers, set from the mail settings at startup
+var Default Mailer = LogMailer{}
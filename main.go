@@ -1,22 +1,232 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/johnson-oragui/golang-todo-api/auth"
+	"github.com/johnson-oragui/golang-todo-api/config"
+	"github.com/johnson-oragui/golang-todo-api/mailer"
+	"github.com/johnson-oragui/golang-todo-api/middleware"
 	"github.com/johnson-oragui/golang-todo-api/routes"
+	"github.com/johnson-oragui/golang-todo-api/schema"
+	"github.com/johnson-oragui/golang-todo-api/tlsconfig"
+	"github.com/johnson-oragui/golang-todo-api/tracing"
+	"github.com/johnson-oragui/golang-todo-api/utils"
 )
 
 func main() {
+	// defaults, then the config file, the environment and the flags
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) || errors.Is(err, config.ErrPrinted) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+
+	// log as JSON, including what handlers write with the log package
+	middleware.LogLevel.UnmarshalText([]byte(cfg.Log.Level))
+	slog.SetDefault(middleware.Logger)
+	slog.Info("loaded configuration", "config", cfg)
+
+	// export traces to an OpenTelemetry collector or stdout
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:    cfg.Tracing.Exporter,
+		ServiceName: cfg.Tracing.ServiceName,
+	})
+	if err != nil {
+		log.Fatalf("could not set up tracing: %v", err)
+	}
+
+	if cfg.Auth.JWTSecret != "" {
+		if err := auth.SetJWTSecret([]byte(cfg.Auth.JWTSecret)); err != nil {
+			log.Fatalf("invalid JWT secret: %v", err)
+		}
+	} else {
+		slog.Warn("no JWT secret is configured, access tokens are signed with a random key and stop working on restart")
+	}
+	auth.PasswordHashing.Algorithm = cfg.Auth.PasswordHashAlgorithm
+	auth.PasswordHashing.Argon2Memory = uint32(cfg.Auth.Argon2Memory)
+	auth.PasswordHashing.Argon2Time = uint32(cfg.Auth.Argon2Time)
+	auth.PasswordHashing.Argon2Threads = uint8(cfg.Auth.Argon2Threads)
+	auth.PasswordHashing.BcryptCost = cfg.Auth.BcryptCost
+
+	utils.PasswordPolicy = cfg.Auth.PasswordPolicy.Policy()
+	if cfg.Auth.BreachedPasswordsFile != "" {
+		if err := utils.LoadBreachedPasswords(cfg.Auth.BreachedPasswordsFile); err != nil {
+			log.Fatalf("could not load breached passwords: %v", err)
+		}
+	}
+
+	// deliver verification and password reset emails
+	if cfg.Mail.Transport == mailer.TransportSMTP {
+		mailer.Default = mailer.SMTPMailer{
+			Host:     cfg.Mail.SMTPHost,
+			Port:     cfg.Mail.SMTPPort,
+			Username: cfg.Mail.SMTPUsername,
+			Password: cfg.Mail.SMTPPassword,
+			From:     cfg.Mail.From,
+			Timeout:  cfg.Mail.SMTPTimeout,
+		}
+	} else {
+		slog.Warn("no mail transport is configured, verification and password reset emails are not delivered")
+	}
+
+	// bootstrap the first administrator
+	if cfg.Admin.Username != "" {
+		err := routes.SeedAdmin(schema.UserSchemaInput{
+			Username:  cfg.Admin.Username,
+			FirstName: "Admin",
+			LastName:  "Admin",
+			Email:     cfg.Admin.Email,
+			Password:  cfg.Admin.Password,
+		})
+		if err != nil {
+			log.Fatalf("could not create admin user: %v", err)
+		}
+	}
+
+	// stop on Ctrl+C or when the platform asks the process to stop
+	signals, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	// purge accounts past their grace period, when deletion is delayed at all
+	workers, stopWorkers := context.WithCancel(context.Background())
+	deletionWorkerDone := routes.StartDeletionWorker(workers, cfg)
+
 	server := &http.Server{
-		Addr:           ":5000",
-		Handler:        routes.MyHandler(),
-		ReadTimeout:    10 * time.Second,
-		WriteTimeout:   10 * time.Second,
-		MaxHeaderBytes: 1 << 20,
-	}
-	fmt.Println("Server running on http://localhost:5000")
-	log.Fatal(server.ListenAndServe())
+		Addr:           cfg.Server.Addr,
+		Handler:        routes.MyHandler(cfg),
+		ReadTimeout:    cfg.Server.ReadTimeout,
+		WriteTimeout:   cfg.Server.WriteTimeout,
+		MaxHeaderBytes: cfg.Server.MaxHeaderBytes,
+	}
+	serve := server.ListenAndServe
+	serverErr := make(chan error, 3)
+
+	// terminate TLS in the process, reloading the certificate when it is renewed
+	var redirectServer *http.Server
+	if cfg.TLS.Enabled() {
+		tlsConfig, certificates, err := tlsconfig.New(cfg.TLS.Options())
+		if err != nil {
+			log.Fatalf("could not set up TLS: %v", err)
+		}
+		server.TLSConfig = tlsConfig
+		if !cfg.TLS.HTTP2 {
+			// an empty map stops net/http from adding HTTP/2 itself
+			server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		}
+		if cfg.TLS.ReloadInterval > 0 {
+			go certificates.Watch(workers, cfg.TLS.ReloadInterval)
+		}
+		serve = func() error {
+			return server.ListenAndServeTLS("", "")
+		}
+
+		if cfg.TLS.RedirectAddr != "" {
+			_, httpsPort, _ := net.SplitHostPort(cfg.Server.Addr)
+			redirectServer = &http.Server{
+				Addr:           cfg.TLS.RedirectAddr,
+				Handler:        middleware.RedirectToHTTPS(httpsPort),
+				ReadTimeout:    cfg.Server.ReadTimeout,
+				WriteTimeout:   cfg.Server.WriteTimeout,
+				MaxHeaderBytes: cfg.Server.MaxHeaderBytes,
+			}
+			go func() {
+				if err := redirectServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+					serverErr <- err
+				}
+			}()
+			slog.Info("redirecting plain HTTP to HTTPS", "addr", cfg.TLS.RedirectAddr)
+		}
+	}
+
+	// keep the metrics off the public listener when they have an address of their own
+	var metricsServer *http.Server
+	if cfg.Metrics.Addr != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("GET /metrics", routes.MetricsHandler(cfg))
+		metricsServer = &http.Server{
+			Addr:           cfg.Metrics.Addr,
+			Handler:        metricsMux,
+			ReadTimeout:    cfg.Server.ReadTimeout,
+			WriteTimeout:   cfg.Server.WriteTimeout,
+			MaxHeaderBytes: cfg.Server.MaxHeaderBytes,
+		}
+		go func() {
+			if err := metricsServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				serverErr <- err
+			}
+		}()
+		slog.Info("serving metrics", "addr", cfg.Metrics.Addr)
+	}
+
+	go func() {
+		serverErr <- serve()
+	}()
+	slog.Info("server running", "url", cfg.Server.BaseURL, "addr", cfg.Server.Addr)
+
+	// a listener that fails shuts the others down cleanly before the process exits with an error
+	exitCode := 0
+	select {
+	case err := <-serverErr:
+		slog.Error("a listener stopped, shutting down", "error", err)
+		exitCode = 1
+	case <-signals.Done():
+	}
+	// a second signal stops the process straight away
+	stopSignals()
+
+	// fail the readiness probe and give load balancers time to notice before
+	// new connections are refused
+	slog.Info("shutting down", "timeout", cfg.Server.ShutdownTimeout.String(), "delay", cfg.Server.ShutdownDelay.String())
+	routes.BeginShutdown()
+	time.Sleep(cfg.Server.ShutdownDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("requests still in flight at the shutdown deadline were cut off", "error", err)
+		server.Close()
+		exitCode = 1
+	}
+	if redirectServer != nil {
+		if err := redirectServer.Shutdown(ctx); err != nil {
+			redirectServer.Close()
+		}
+	}
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(ctx); err != nil {
+			metricsServer.Close()
+		}
+	}
+
+	stopWorkers()
+	select {
+	case <-deletionWorkerDone:
+	case <-ctx.Done():
+		slog.Error("the account deletion worker did not stop before the shutdown deadline")
+		exitCode = 1
+	}
+
+	// the in-memory store has nothing to flush, spans still buffered are exported
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("could not flush traces", "error", err)
+		exitCode = 1
+	}
+
+	slog.Info("shut down")
+	os.Exit(exitCode)
 }
@@ -0,0 +1,114 @@
+// Package metrics holds the Prometheus metrics exposed on /metrics
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/johnson-oragui/golang-todo-api/schema"
+)
+
+const namespace = "todo_api"
+
+// Registry holds every metric the API exposes, kept apart from the global
+// default registry so tests and libraries cannot add to it by accident
+var Registry = prometheus.NewRegistry()
+
+var (
+	// HTTPRequests counts requests by method, mux route template and status
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route template and status code.",
+	}, []string{"method", "route", "status"})
+
+	// HTTPRequestDuration observes request latency by method, mux route template and status
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method, route template and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	// AuthFailures counts requests rejected by JWTAuthMiddleware by reason
+	AuthFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_failures_total",
+		Help:      "Requests rejected by the authentication middleware, by reason.",
+	}, []string{"reason"})
+
+	// RateLimited counts requests rejected by the rate limiter by route template
+	RateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "Requests rejected for exceeding a rate limit, by route template.",
+	}, []string{"route"})
+
+	// Panics counts handler panics caught by the recovery middleware by route template
+	Panics = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "panics_total",
+		Help:      "Panics recovered while serving requests, by route template.",
+	}, []string{"route"})
+
+	// Logins counts login attempts by method (password, mfa or oidc) and result (success or failure)
+	Logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Login attempts by method and result.",
+	}, []string{"method", "result"})
+)
+
+// reasons JWTAuthMiddleware rejects a request for
+const (
+	AuthMissingToken          = "missing_token"
+	AuthInvalidToken          = "invalid_token"
+	AuthAccountDisabled       = "account_disabled"
+	AuthPasswordResetRequired = "password_reset_required"
+)
+
+// login methods and results
+const (
+	LoginPassword = "password"
+	LoginMFA      = "mfa"
+	LoginOIDC     = "oidc"
+
+	LoginSuccess = "success"
+	LoginFailure = "failure"
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPRequestDuration,
+		AuthFailures,
+		RateLimited,
+		Panics,
+		Logins,
+		// the store keeps running counts, which are safe to read while requests change it
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "users",
+			Help:      "Number of users in the store.",
+		}, func() float64 {
+			return float64(schema.UserCount())
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "todos",
+			Help:      "Number of todos in the store.",
+		}, func() float64 {
+			return float64(schema.TodoCount())
+		}),
+	)
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,176 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// MinCompressBytes is the smallest response body worth compressing
+var MinCompressBytes = 1024
+
+// content encodings in the order they are preferred when a client accepts several equally
+var encodingPreference = []string{"zstd", "br", "gzip"}
+
+type compressor interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// compressors are pooled, zstd encoders in particular are expensive to set up
+var compressors = map[string]*sync.Pool{
+	"zstd": {New: func() any {
+		encoder, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		return encoder
+	}},
+	"br": {New: func() any {
+		return brotli.NewWriterLevel(nil, 4)
+	}},
+	"gzip": {New: func() any {
+		return gzip.NewWriter(nil)
+	}},
+}
+
+// CompressMiddleware compresses responses of MinCompressBytes or more with
+// zstd, brotli or gzip, whichever the Accept-Encoding header of the request
+// prefers. Content that is compressed already, such as images, is sent as is
+func CompressMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(req.Header.Get("Accept-Encoding"))
+		if encoding == "" || req.Method == http.MethodHead {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		writer := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer writer.close()
+		next.ServeHTTP(writer, req)
+	})
+}
+
+// picks the encoding with the highest quality in accept
+func negotiateEncoding(accept string) string {
+	qualities := map[string]float64{}
+	for _, entry := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(entry, ";")
+		quality := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		qualities[strings.ToLower(strings.TrimSpace(name))] = quality
+	}
+
+	best, bestQuality := "", 0.0
+	for _, encoding := range encodingPreference {
+		quality, ok := qualities[encoding]
+		if !ok {
+			quality, ok = qualities["*"]
+		}
+		if ok && quality > bestQuality {
+			best, bestQuality = encoding, quality
+		}
+	}
+	return best
+}
+
+// compressWriter holds back the status and the start of the body until it
+// knows whether the response is worth compressing
+type compressWriter struct {
+	http.ResponseWriter
+	encoding   string
+	status     int
+	buf        []byte
+	started    bool
+	compressor compressor
+}
+
+func (c *compressWriter) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+}
+
+func (c *compressWriter) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	if c.started {
+		if c.compressor != nil {
+			return c.compressor.Write(b)
+		}
+		return c.ResponseWriter.Write(b)
+	}
+
+	c.buf = append(c.buf, b...)
+	if len(c.buf) >= MinCompressBytes {
+		if err := c.start(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// writes the status and what was held back, compressing from here on when it is worth it
+func (c *compressWriter) start() error {
+	c.started = true
+
+	header := c.ResponseWriter.Header()
+	if len(c.buf) >= MinCompressBytes && compressible(header, c.status) {
+		header.Set("Content-Encoding", c.encoding)
+		header.Del("Content-Length")
+		c.compressor = compressors[c.encoding].Get().(compressor)
+		c.compressor.Reset(c.ResponseWriter)
+	}
+	c.ResponseWriter.WriteHeader(c.status)
+
+	buf := c.buf
+	c.buf = nil
+	if c.compressor != nil {
+		_, err := c.compressor.Write(buf)
+		return err
+	}
+	_, err := c.ResponseWriter.Write(buf)
+	return err
+}
+
+func (c *compressWriter) close() {
+	// nothing was written, leave the response to the server
+	if c.status == 0 {
+		return
+	}
+	if !c.started {
+		c.start()
+	}
+	if c.compressor != nil {
+		c.compressor.Close()
+		c.compressor.Reset(nil)
+		compressors[c.encoding].Put(c.compressor)
+	}
+}
+
+func compressible(header http.Header, status int) bool {
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		return false
+	}
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	contentType := header.Get("Content-Type")
+	for _, compressed := range []string{"image/", "audio/", "video/", "application/zip", "application/gzip", "application/zstd"} {
+		if strings.HasPrefix(contentType, compressed) {
+			return false
+		}
+	}
+	return true
+}
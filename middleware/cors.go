@@ -0,0 +1,156 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// CORSConfig lists what browsers on other origins may do with the API
+type CORSConfig struct {
+	// AllowedOrigins are the origins, such as https://app.example.com, that may
+	// call the API. "*" allows any origin; none leaves CORS off
+	AllowedOrigins []string
+	// AllowedMethods may be used in cross-origin requests, on routes that serve them
+	AllowedMethods []string
+	// AllowedHeaders may be sent in cross-origin requests, "*" allows any header
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers scripts are allowed to read
+	ExposedHeaders []string
+	// AllowCredentials lets browsers send cookies. It is ignored for the "*" origin,
+	// which browsers will not send credentials to
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight response
+	MaxAge time.Duration
+}
+
+// CORSMiddleware adds CORS headers for allowed origins and answers preflight
+// requests for the routes of router itself, before next is called, so routes
+// that require a JWT can be preflighted without one
+func CORSMiddleware(config CORSConfig, router *mux.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		origin := req.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		// the answer depends on the origin, caches must not share it
+		w.Header().Add("Vary", "Origin")
+
+		preflight := req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != ""
+		allowed := config.allowsOrigin(origin)
+
+		if !preflight {
+			if allowed {
+				config.setOrigin(w, origin)
+				if len(config.ExposedHeaders) > 0 {
+					w.Header().Set("Access-Control-Expose-Headers", strings.Join(config.ExposedHeaders, ", "))
+				}
+			}
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+		if !allowed {
+			Printf(req, "CORS preflight from %v rejected, origin not allowed", origin)
+			Error(w, req, "origin not allowed", http.StatusForbidden)
+			return
+		}
+
+		method := strings.ToUpper(req.Header.Get("Access-Control-Request-Method"))
+		methods := config.routeMethods(router, req)
+		if len(methods) == 0 {
+			// no such route, let the router answer
+			next.ServeHTTP(w, req)
+			return
+		}
+		if !slices.Contains(methods, method) {
+			Printf(req, "CORS preflight from %v rejected, method %v not allowed", origin, method)
+			Error(w, req, "method not allowed", http.StatusForbidden)
+			return
+		}
+
+		requested := requestedHeaders(req)
+		for _, header := range requested {
+			if !config.allowsHeader(header) {
+				Printf(req, "CORS preflight from %v rejected, header %v not allowed", origin, header)
+				Error(w, req, "header not allowed", http.StatusForbidden)
+				return
+			}
+		}
+
+		config.setOrigin(w, origin)
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		if len(requested) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+		}
+		if config.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(config.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (c CORSConfig) allowsOrigin(origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c CORSConfig) allowsHeader(header string) bool {
+	for _, allowed := range c.AllowedHeaders {
+		if allowed == "*" || strings.EqualFold(allowed, header) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c CORSConfig) setOrigin(w http.ResponseWriter, origin string) {
+	if slices.Contains(c.AllowedOrigins, "*") {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	if c.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// the allowed methods router has a route for at the preflighted path
+func (c CORSConfig) routeMethods(router *mux.Router, req *http.Request) []string {
+	methods := []string{}
+	for _, method := range c.AllowedMethods {
+		method = strings.ToUpper(method)
+		probe := req.Clone(req.Context())
+		probe.Method = method
+		var match mux.RouteMatch
+		if router.Match(probe, &match) && match.Route != nil {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+func requestedHeaders(req *http.Request) []string {
+	headers := []string{}
+	for _, value := range req.Header.Values("Access-Control-Request-Headers") {
+		for _, header := range strings.Split(value, ",") {
+			if header = strings.TrimSpace(header); header != "" {
+				headers = append(headers, strings.ToLower(header))
+			}
+		}
+	}
+	return headers
+}
@@ -2,42 +2,212 @@ package middleware
 
 import (
 	"bytes"
-	"fmt"
+	"context"
+	"encoding/json"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
 )
 
-// loggingMiddleware is a middleware that logs HTTP requests
-func LogginMiddleware(next http.Handler) http.Handler {
+// LogLevel is the minimum level Logger writes
+var LogLevel = new(slog.LevelVar)
 
+// Logger writes the access log, one JSON object per request
+var Logger = slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: LogLevel}))
+
+// MaxLoggedBodyBytes caps how much of a request body LogRequestBody records
+var MaxLoggedBodyBytes int64 = 4096
+
+const redacted = "[REDACTED]"
+
+// headers, query parameters and body fields that are never written to the logs
+var sensitiveHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization"}
+
+var sensitiveFields = []string{"password", "token", "secret", "code", "recovery_code", "verifier", "assertion"}
+
+// reports whether a field or parameter name holds a credential
+func isSensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, field := range sensitiveFields {
+		if strings.Contains(name, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// per-request details filled in by the handlers the logger wraps
+type logEntry struct {
+	user string
+	body any
+}
+
+type logEntryKey struct{}
+
+// records the authenticated user in the access log of the request
+func setLoggedUser(ctx context.Context, username string) {
+	if entry, ok := ctx.Value(logEntryKey{}).(*logEntry); ok {
+		entry.user = username
+	}
+}
+
+// captures the status and size of the response
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// lets http.ResponseController reach the underlying writer
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func redactHeaders(header http.Header) map[string]string {
+	headers := map[string]string{}
+	for key, values := range header {
+		value := strings.Join(values, ", ")
+		for _, sensitive := range sensitiveHeaders {
+			if strings.EqualFold(key, sensitive) {
+				value = redacted
+			}
+		}
+		headers[key] = value
+	}
+	return headers
+}
+
+func redactValues(values url.Values) map[string]string {
+	redactedValues := map[string]string{}
+	for key := range values {
+		if isSensitive(key) {
+			redactedValues[key] = redacted
+		} else {
+			redactedValues[key] = values.Get(key)
+		}
+	}
+	return redactedValues
+}
+
+// replaces sensitive fields anywhere in a decoded JSON document
+func redactJSON(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, field := range v {
+			if isSensitive(key) {
+				v[key] = redacted
+			} else {
+				v[key] = redactJSON(field)
+			}
+		}
+	case []any:
+		for i := range v {
+			v[i] = redactJSON(v[i])
+		}
+	}
+	return value
+}
+
+// LoggingMiddleware writes a structured access log entry for every request.
+// Request bodies are only logged on routes wrapped with LogRequestBody.
+func LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		logger := make(map[string]string)
-		header := make(map[string]any)
+		start := time.Now()
+		entry := &logEntry{}
+		recorder := &responseRecorder{ResponseWriter: w}
 
-		logger["Method"] = req.Method
-		logger["Path"] = req.URL.Path
-		logger["RemoteAddr"] = req.RemoteAddr
+		next.ServeHTTP(recorder, req.WithContext(context.WithValue(req.Context(), logEntryKey{}, entry)))
 
-		// Convert the header to a string format that can be logged
-		for key, values := range req.Header {
-			header[key] = fmt.Sprintf("%s", values)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
 		}
 
-		// Read the body into memory for logging, then restore it for further processing
-		bodyBytes, err := io.ReadAll(req.Body)
-		if err != nil {
-			log.Printf("error in logger: %v", err)
+		attrs := []slog.Attr{
+			slog.String("method", req.Method),
+			slog.String("path", req.URL.Path),
+			slog.Int("status", recorder.status),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int("bytes", recorder.bytes),
+			slog.String("user", entry.user),
+		}
+		attrs = append(attrs, correlationAttrs(req.Context())...)
+		attrs = append(attrs,
+			slog.String("remote_addr", req.RemoteAddr),
+			slog.Any("headers", redactHeaders(req.Header)),
+		)
+		if req.URL.RawQuery != "" {
+			attrs = append(attrs, slog.Any("query", redactValues(req.URL.Query())))
+		}
+		if entry.body != nil {
+			attrs = append(attrs, slog.Any("body", entry.body))
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case recorder.status >= 500:
+			level = slog.LevelError
+		case recorder.status >= 400:
+			level = slog.LevelWarn
+		}
+		Logger.LogAttrs(req.Context(), level, "request", attrs...)
+	})
+}
+
+// LogRequestBody opts a route in to having its request body logged, with
+// credentials redacted. Bodies that are not JSON or forms are left out.
+func LogRequestBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		entry, ok := req.Context().Value(logEntryKey{}).(*logEntry)
+		if !ok || req.Body == nil {
+			next.ServeHTTP(w, req)
+			return
 		}
 
-		// Log request details
-		log.Printf("{ method: %v, path: %v, Body: %v}", logger["Method"], logger["Path"], string(bodyBytes))
+		// read the start of the body for the log, then hand the whole body on
+		logged, err := io.ReadAll(io.LimitReader(req.Body, MaxLoggedBodyBytes))
+		if err != nil {
+			Logger.Warn("could not read the request body for logging", slog.String("error", err.Error()))
+		}
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(logged), req.Body), req.Body}
 
-		// Restore the body so it can be read again by the actual handler
-		req.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+		contentType := req.Header.Get("Content-Type")
+		switch {
+		case strings.HasPrefix(contentType, "application/json"):
+			var body any
+			if err := json.Unmarshal(logged, &body); err != nil {
+				entry.body = "[unparsable JSON omitted]"
+			} else {
+				entry.body = redactJSON(body)
+			}
+		case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+			if values, err := url.ParseQuery(string(logged)); err == nil {
+				entry.body = redactValues(values)
+			}
+		}
 
-		// Call the next handler in the chain
 		next.ServeHTTP(w, req)
-	},
-	)
+	})
 }
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/johnson-oragui/golang-todo-api/metrics"
+)
+
+var knownMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodOptions: true,
+}
+
+// RequireBearerToken only lets through requests sending token as a bearer
+// token, such as a Prometheus scraper configured with it. Every request is let
+// through when token is empty
+func RequireBearerToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			Error(w, req, "a valid bearer token is required", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// MetricsMiddleware records the count and latency of requests served by
+// router, labeled with the route template so /todos/1 and /todos/2 share a series.
+// A request whose handler panics is recorded as the 500 RecoverMiddleware answers it with
+func MetricsMiddleware(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		recorder := &responseRecorder{ResponseWriter: w}
+
+		completed := false
+		defer func() {
+			if !completed && recorder.status == 0 {
+				recorder.status = http.StatusInternalServerError
+			}
+			recordRequest(router, req, recorder.status, start)
+		}()
+
+		router.ServeHTTP(recorder, req)
+		completed = true
+	})
+}
+
+// counts a request served by router and observes how long it took
+func recordRequest(router *mux.Router, req *http.Request, status int, start time.Time) {
+	route := matchedRoute(router, req)
+
+	if status == 0 {
+		status = http.StatusOK
+	}
+	code := strconv.Itoa(status)
+
+	method := req.Method
+	if !knownMethods[method] {
+		method = "other"
+	}
+
+	metrics.HTTPRequests.WithLabelValues(method, route, code).Inc()
+	metrics.HTTPRequestDuration.WithLabelValues(method, route, code).Observe(time.Since(start).Seconds())
+}
+
+// the template of the route of router matching the request, requests that match
+// no route share one series, keeping the labels bounded
+func matchedRoute(router *mux.Router, req *http.Request) string {
+	var match mux.RouteMatch
+	if router.Match(req, &match) && match.Route != nil {
+		if template, err := match.Route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return "unmatched"
+}
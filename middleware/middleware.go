@@ -2,37 +2,197 @@ package middleware
 
 import (
 	"context"
-	"log"
+	"errors"
 	"net/http"
+	"slices"
 	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/johnson-oragui/golang-todo-api/auth"
+	"github.com/johnson-oragui/golang-todo-api/metrics"
+	"github.com/johnson-oragui/golang-todo-api/schema"
+	"github.com/johnson-oragui/golang-todo-api/tracing"
 )
 
 func JWTAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx, span := tracing.Start(req.Context(), "JWTAuthMiddleware")
+		defer span.End()
+
 		// get token from authorization header
 		authHeader := req.Header.Get("Authorization")
 		if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer") {
-			log.Println("Authorization token not provided")
-			http.Error(w, "Authorization token not provided", http.StatusUnauthorized)
+			Println(req, "Authorization token not provided")
+			authFailed(span, metrics.AuthMissingToken)
+			Error(w, req, "Authorization token not provided", http.StatusUnauthorized)
 			return
 		}
 
 		// extract token
 		token := strings.TrimPrefix(authHeader, "Bearer ")
 
-		// validate token
-		username, err := auth.DecodeJWT(token)
-		if err != nil {
-			log.Println("Invalid token")
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
-			return
+		var username string
+		var err error
+
+		// personal access tokens and OAuth tokens carry their own scopes
+		if strings.HasPrefix(token, auth.PersonalAccessTokenPrefix) {
+			var scopes []string
+			username, scopes, err = authenticatePersonalAccessToken(token)
+			if err != nil {
+				Println(req, err)
+				authFailed(span, metrics.AuthInvalidToken)
+				Error(w, req, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+			ctx = context.WithValue(ctx, "scopes", scopes)
+			span.SetAttributes(attribute.String("auth.token_type", "personal_access_token"))
+		} else if strings.HasPrefix(token, auth.OAuthAccessTokenPrefix) {
+			var scopes []string
+			username, scopes, err = authenticateOAuthToken(token)
+			if err != nil {
+				Println(req, err)
+				authFailed(span, metrics.AuthInvalidToken)
+				Error(w, req, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+			ctx = context.WithValue(ctx, "scopes", scopes)
+			span.SetAttributes(attribute.String("auth.token_type", "oauth"))
+		} else {
+			// validate token
+			username, err = auth.DecodeJWT(token)
+			if err != nil {
+				Println(req, "Invalid token")
+				authFailed(span, metrics.AuthInvalidToken)
+				Error(w, req, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+			span.SetAttributes(attribute.String("auth.token_type", "jwt"))
+		}
+
+		if user, exists := schema.Database.Users[username]; exists {
+			if user.Disabled {
+				Printf(req, "account %v is disabled", username)
+				authFailed(span, metrics.AuthAccountDisabled)
+				Error(w, req, "account is disabled", http.StatusForbidden)
+				return
+			}
+			if user.PasswordResetRequired {
+				Printf(req, "account %v must reset their password", username)
+				authFailed(span, metrics.AuthPasswordResetRequired)
+				Error(w, req, "password reset required", http.StatusForbidden)
+				return
+			}
 		}
 
 		// add the username to request context and call next handler
-		ctx := req.Context()
+		span.SetAttributes(attribute.String("enduser.id", username))
+		setLoggedUser(ctx, username)
 		ctx = context.WithValue(ctx, "username", username)
 		next.ServeHTTP(w, req.WithContext(ctx))
 	})
 }
+
+// counts a rejected request and marks the authentication span as failed
+func authFailed(span trace.Span, reason string) {
+	metrics.AuthFailures.WithLabelValues(reason).Inc()
+	span.SetStatus(codes.Error, reason)
+}
+
+// looks up a personal access token and returns its owner and scopes
+func authenticatePersonalAccessToken(token string) (string, []string, error) {
+	hash := auth.HashToken(token)
+
+	pat, exists := schema.TokensDataBase.Tokens[hash]
+	if !exists {
+		return "", nil, errors.New("unknown personal access token")
+	}
+
+	now := time.Now()
+	if pat.ExpiresAt != nil && now.After(*pat.ExpiresAt) {
+		return "", nil, errors.New("personal access token has expired")
+	}
+
+	schema.TouchToken(hash, now)
+
+	return pat.Username, pat.Scopes, nil
+}
+
+// looks up an access token issued to an OAuth client and returns its user and scopes
+func authenticateOAuthToken(token string) (string, []string, error) {
+	hash := auth.HashToken(token)
+
+	accessToken, exists := schema.OAuthStore.Tokens[hash]
+	if !exists {
+		return "", nil, errors.New("unknown OAuth access token")
+	}
+
+	if time.Now().After(accessToken.ExpiresAt) {
+		delete(schema.OAuthStore.Tokens, hash)
+		return "", nil, errors.New("OAuth access token has expired")
+	}
+
+	return accessToken.Username, accessToken.Scopes, nil
+}
+
+// RequireScope rejects scoped tokens that were not granted scope.
+// Requests authenticated with a JWT are not restricted.
+func RequireScope(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		scopes, scoped := req.Context().Value("scopes").([]string)
+		if scoped && !slices.Contains(scopes, scope) {
+			Printf(req, "token is missing the %v scope", scope)
+			Error(w, req, "token is missing the "+scope+" scope", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// RequireMethodScopes is RequireScope for routes serving several methods.
+// Scoped tokens are rejected for methods that have no scope.
+func RequireMethodScopes(scopes map[string]string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		scope, exists := scopes[req.Method]
+		if !exists {
+			RequireSession(next).ServeHTTP(w, req)
+			return
+		}
+
+		RequireScope(scope, next).ServeHTTP(w, req)
+	})
+}
+
+// RequireSession only allows requests authenticated with a login session,
+// keeping account management out of reach of scoped tokens
+func RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if _, scoped := req.Context().Value("scopes").([]string); scoped {
+			Println(req, "scoped tokens cannot access this route")
+			Error(w, req, "this route requires a login session", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// RequireRole only allows users holding one of roles
+func RequireRole(roles []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		username, _ := req.Context().Value("username").(string)
+
+		user, exists := schema.Database.Users[username]
+		if !exists || !slices.Contains(roles, user.Role) {
+			Printf(req, "user %v does not have any of the roles %v", username, roles)
+			Error(w, req, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// media types responses can be encoded in
+const (
+	MediaJSON    = "application/json"
+	MediaMsgPack = "application/msgpack"
+	MediaCBOR    = "application/cbor"
+)
+
+// encoders by the media type they produce. MessagePack and CBOR use the json
+// struct tags, so fields hidden from JSON stay hidden in every format
+var encoders = map[string]func(v any) ([]byte, error){
+	MediaJSON: func(v any) ([]byte, error) {
+		buf := &bytes.Buffer{}
+		err := json.NewEncoder(buf).Encode(v)
+		return buf.Bytes(), err
+	},
+	MediaMsgPack: func(v any) ([]byte, error) {
+		buf := &bytes.Buffer{}
+		encoder := msgpack.NewEncoder(buf)
+		encoder.SetCustomStructTag("json")
+		err := encoder.Encode(v)
+		return buf.Bytes(), err
+	},
+	MediaCBOR: cbor.Marshal,
+}
+
+// other names clients use for the media types
+var mediaAliases = map[string]string{
+	"application/x-msgpack":   MediaMsgPack,
+	"application/vnd.msgpack": MediaMsgPack,
+}
+
+// Respond writes v with status in the format the Accept header of req prefers,
+// JSON unless the client asks for MessagePack or CBOR. Clients accepting none of
+// them get JSON too rather than a 406, the handler has done its work by now
+func Respond(w http.ResponseWriter, req *http.Request, status int, v any) {
+	mediaType := Negotiate(req, MediaJSON, MediaMsgPack, MediaCBOR)
+
+	body, err := encoders[mediaType](v)
+	if err != nil {
+		Printf(req, "error encoding %v response: %v", mediaType, err)
+		Error(w, req, "could not encode the response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("Content-Type", mediaType)
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// Negotiate picks the offer with the highest quality in the Accept header of
+// req, the first offer when it accepts none of them. Earlier entries win ties,
+// and wildcards such as */* or image/* match the first offer they cover
+func Negotiate(req *http.Request, offers ...string) string {
+	best, bestQuality := offers[0], 0.0
+	for _, entry := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(entry)
+		if err != nil {
+			continue
+		}
+		if alias, ok := mediaAliases[mediaType]; ok {
+			mediaType = alias
+		}
+		offer, ok := matchOffer(mediaType, offers)
+		if !ok {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		if quality > bestQuality {
+			best, bestQuality = offer, quality
+		}
+	}
+	return best
+}
+
+// the first offer mediaType covers, wildcards included
+func matchOffer(mediaType string, offers []string) (string, bool) {
+	for _, offer := range offers {
+		if mediaType == offer || mediaType == "*/*" {
+			return offer, true
+		}
+		if prefix, ok := strings.CutSuffix(mediaType, "/*"); ok && strings.HasPrefix(offer, prefix+"/") {
+			return offer, true
+		}
+	}
+	return "", false
+}
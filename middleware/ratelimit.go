@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/johnson-oragui/golang-todo-api/metrics"
+	"github.com/johnson-oragui/golang-todo-api/ratelimit"
+)
+
+// DefaultRateLimit is the key in the limits given to RateLimit used for routes without their own limit
+const DefaultRateLimit = "default"
+
+// IPRateLimit is the key in the limits given to RateLimitIP
+const IPRateLimit = "ip"
+
+// RateLimit limits requests to each route template, keyed by the username set
+// by JWTAuthMiddleware or by client IP address when no one is signed in. Routes
+// take their limit from limits, or the DefaultRateLimit entry, and have a bucket
+// of their own. Requests are let through if the store fails
+func RateLimit(store ratelimit.Store, limits map[string]ratelimit.Limit, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		route := routeTemplate(req)
+
+		limit, exists := limits[route]
+		if !exists {
+			limit, exists = limits[DefaultRateLimit]
+		}
+		if !exists || limit.Requests <= 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		client := "ip:" + ClientIP(req)
+		if username, ok := req.Context().Value("username").(string); ok {
+			client = "user:" + username
+		}
+
+		if takeRequest(w, req, store, route, route+"|"+client, limit) {
+			next.ServeHTTP(w, req)
+		}
+	})
+}
+
+// RateLimitIP limits the requests from each client IP address to the
+// IPRateLimit entry of limits, in one bucket shared by every route it guards.
+// In front of JWTAuthMiddleware it throttles clients sending bad or missing
+// tokens, which never get as far as a per-user limit
+func RateLimitIP(store ratelimit.Store, limits map[string]ratelimit.Limit, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		limit, exists := limits[IPRateLimit]
+		if !exists || limit.Requests <= 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		if takeRequest(w, req, store, routeTemplate(req), IPRateLimit+"|ip:"+ClientIP(req), limit) {
+			next.ServeHTTP(w, req)
+		}
+	})
+}
+
+// the route template matched by the request, or its path outside of a router
+func routeTemplate(req *http.Request) string {
+	if current := mux.CurrentRoute(req); current != nil {
+		if template, err := current.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return req.URL.Path
+}
+
+// takes a request from the bucket named key, answering with 429 and returning
+// false when it is empty
+func takeRequest(w http.ResponseWriter, req *http.Request, store ratelimit.Store, route, key string, limit ratelimit.Limit) bool {
+	result, err := store.Take(req.Context(), key, limit, time.Now())
+	if err != nil {
+		Printf(req, "rate limit store failed, allowing the request: %v", err)
+		return true
+	}
+
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(int(result.Reset.Seconds())))
+	w.Header().Set("RateLimit-Policy", fmt.Sprintf("%v;w=%v", limit.Requests, int(limit.Per.Seconds())))
+
+	if !result.Allowed {
+		retryAfter := int(result.RetryAfter.Seconds())
+		_, client, _ := strings.Cut(key, "|")
+		Printf(req, "%v exceeded the rate limit of %v", client, route)
+		metrics.RateLimited.WithLabelValues(route).Inc()
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		Error(w, req, fmt.Sprintf("rate limit exceeded, try again in %v seconds", retryAfter), http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// ClientIP returns the address of the client, used to limit and track
+// requests from clients that are not signed in
+func ClientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
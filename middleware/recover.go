@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/johnson-oragui/golang-todo-api/metrics"
+)
+
+// RecoverMiddleware turns a panic in next into a 500 problem response,
+// logging the stack with the request ID so the crash can be found from the
+// response. Wrap everything inside the request ID and logging middleware with
+// it, so the 500 is logged like any other response and a panic in any
+// middleware, or in the 404 and 405 handlers, is caught too. Panics are
+// counted under the template of the route of router matching the request
+func RecoverMiddleware(router *mux.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		recorder := &responseRecorder{ResponseWriter: w}
+
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			// the server uses this panic to abort a response on purpose
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			metrics.Panics.WithLabelValues(matchedRoute(router, req)).Inc()
+
+			stack := debug.Stack()
+			attrs := append(correlationAttrs(req.Context()),
+				slog.String("method", req.Method),
+				slog.String("path", req.URL.Path),
+				slog.String("panic", fmt.Sprint(recovered)),
+				slog.String("stack", string(stack)),
+			)
+			Logger.LogAttrs(req.Context(), slog.LevelError, "panic serving request", attrs...)
+
+			span := trace.SpanFromContext(req.Context())
+			span.RecordError(fmt.Errorf("panic: %v", recovered), trace.WithStackTrace(true))
+			span.SetStatus(codes.Error, "panic")
+
+			// too late to replace a response that has already started
+			if recorder.status != 0 {
+				return
+			}
+			Error(w, req, "internal server error", http.StatusInternalServerError)
+		}()
+
+		next.ServeHTTP(recorder, req)
+	})
+}
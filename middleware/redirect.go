@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// RedirectToHTTPS answers every request with a permanent redirect to the same
+// host and path over HTTPS on httpsPort. 308 keeps the method and body, so API
+// clients posting to the plain HTTP address are sent on rather than broken
+func RedirectToHTTPS(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host, _, err := net.SplitHostPort(req.Host)
+		if err != nil {
+			host = req.Host
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+
+		target := url.URL{Scheme: "https", Host: host, Path: req.URL.Path, RawPath: req.URL.RawPath, RawQuery: req.URL.RawQuery}
+		http.Redirect(w, req, target.String(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader carries the request ID in requests and responses
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// RequestIDMiddleware gives every request an ID, taken from the X-Request-ID
+// header when the client or a proxy sent a usable one, and echoes it back
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requestID := req.Header.Get(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = newRequestID()
+		}
+
+		w.Header().Set(RequestIDHeader, requestID)
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), requestIDKey{}, requestID)))
+	})
+}
+
+// RequestID returns the ID of the request ctx belongs to
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// IDs from clients end up in the logs, so only short plain ones are kept
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > 128 {
+		return false
+	}
+	for _, c := range requestID {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// the attributes tying a log entry to its request and trace
+func correlationAttrs(ctx context.Context) []slog.Attr {
+	attrs := []slog.Attr{slog.String("request_id", RequestID(ctx))}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		attrs = append(attrs, slog.String("trace_id", spanContext.TraceID().String()))
+	}
+	return attrs
+}
+
+// Printf logs like log.Printf, tagged with the request ID
+func Printf(req *http.Request, format string, v ...any) {
+	Logger.LogAttrs(req.Context(), slog.LevelInfo, fmt.Sprintf(format, v...), correlationAttrs(req.Context())...)
+}
+
+// Println logs like log.Println, tagged with the request ID
+func Println(req *http.Request, v ...any) {
+	message := fmt.Sprintln(v...)
+	Logger.LogAttrs(req.Context(), slog.LevelInfo, message[:len(message)-1], correlationAttrs(req.Context())...)
+}
+
+// Problem is an RFC 9457 problem details error body
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Error replies to the request with an application/problem+json error, the
+// counterpart of http.Error that lets users quote the request ID
+func Error(w http.ResponseWriter, req *http.Request, detail string, status int) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/problem+json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		RequestID: RequestID(req.Context()),
+	})
+}
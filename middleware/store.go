@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/johnson-oragui/golang-todo-api/schema"
+)
+
+// ShareStore holds the in-memory store for the whole request, so background
+// jobs wait for the request to finish before changing it. Requests to the
+// unshared route templates go straight through, keep the probes and the routes
+// calling out to a mail server or identity provider among them so a job never
+// waits on a slow call, nor holds up every request queued behind it
+func ShareStore(unshared ...string) func(http.Handler) http.Handler {
+	skip := map[string]bool{}
+	for _, route := range unshared {
+		skip[route] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if skip[routeTemplate(req)] {
+				next.ServeHTTP(w, req)
+				return
+			}
+
+			release := schema.Share()
+			defer release()
+
+			next.ServeHTTP(w, req)
+		})
+	}
+}
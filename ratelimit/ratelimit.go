@@ -0,0 +1,140 @@
+// Package ratelimit implements token bucket rate limiting over a pluggable store
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit allows Requests requests per Per, refilling gradually so a client that
+// used its allowance can make a request again after Per/Requests
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// ParseLimit parses a limit written as requests/period, such as 20/1m
+func ParseLimit(text string) (Limit, error) {
+	requests, per, found := strings.Cut(text, "/")
+	if !found {
+		return Limit{}, fmt.Errorf("%q is not a limit such as 20/1m", text)
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(requests))
+	if err != nil || count < 1 {
+		return Limit{}, fmt.Errorf("%q does not allow a whole number of requests", text)
+	}
+	period, err := time.ParseDuration(strings.TrimSpace(per))
+	if err != nil || period <= 0 {
+		return Limit{}, fmt.Errorf("%q does not have a positive period such as 1m", text)
+	}
+	return Limit{Requests: count, Per: period}, nil
+}
+
+// tokens added to a bucket per second
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+// Result is the state of a bucket after a request took from it
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until the next request is allowed, zero when Allowed
+}
+
+// Store keeps the buckets. Implement it over a shared database such as Redis
+// to enforce limits across several instances of the API
+type Store interface {
+	// Take removes a token from the bucket at key for a request made at now
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+}
+
+// Bucket is the state of a token bucket, for stores that keep it themselves
+type Bucket struct {
+	Tokens  float64
+	Updated time.Time
+}
+
+// Take refills the bucket for the time passed since it was last updated and
+// takes a token from it when one is available
+func (b *Bucket) Take(limit Limit, now time.Time) Result {
+	capacity := float64(limit.Requests)
+	rate := limit.rate()
+
+	if b.Updated.IsZero() {
+		b.Tokens = capacity
+	} else if elapsed := now.Sub(b.Updated).Seconds(); elapsed > 0 {
+		b.Tokens = math.Min(capacity, b.Tokens+elapsed*rate)
+	}
+	b.Updated = now
+
+	result := Result{Limit: limit.Requests}
+	if b.Tokens >= 1 {
+		b.Tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - b.Tokens) / rate)
+	}
+	result.Remaining = int(b.Tokens)
+	result.Reset = seconds((capacity - b.Tokens) / rate)
+
+	return result
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Ceil(s)) * time.Second
+}
+
+// MemoryStore keeps the buckets of a single instance in memory
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucketEntry
+	lastSweep time.Time
+}
+
+type bucketEntry struct {
+	Bucket
+	limit Limit
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucketEntry{}}
+}
+
+// how often buckets that have refilled completely are dropped
+const sweepInterval = time.Minute
+
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) > sweepInterval {
+		s.sweep(now)
+	}
+
+	entry, exists := s.buckets[key]
+	if !exists {
+		entry = &bucketEntry{}
+		s.buckets[key] = entry
+	}
+	entry.limit = limit
+
+	return entry.Take(limit, now), nil
+}
+
+// drops full buckets, a missing bucket starts full anyway
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, entry := range s.buckets {
+		if now.Sub(entry.Updated) >= entry.limit.Per {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = now
+}
@@ -0,0 +1,264 @@
+package routes
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/johnson-oragui/golang-todo-api/auth"
+	"github.com/johnson-oragui/golang-todo-api/config"
+	"github.com/johnson-oragui/golang-todo-api/middleware"
+	"github.com/johnson-oragui/golang-todo-api/schema"
+)
+
+// removes the user and everything they own, so nothing is left for a new
+// account registered under the same name to inherit
+func deleteAccount(ctx context.Context, username string) {
+	revokeUserTokens(username)
+
+	schema.DeleteTodos(ctx, username)
+
+	for hash, code := range schema.OAuthStore.Codes {
+		if code.Username == username {
+			delete(schema.OAuthStore.Codes, hash)
+		}
+	}
+	for clientID, client := range schema.OAuthStore.Clients {
+		if client.Owner == username {
+			delete(schema.OAuthStore.Clients, clientID)
+			for hash, accessToken := range schema.OAuthStore.Tokens {
+				if accessToken.ClientID == clientID {
+					delete(schema.OAuthStore.Tokens, hash)
+				}
+			}
+		}
+	}
+	for key, identity := range schema.ExternalIdentitiesDataBase.Identities {
+		if identity.Username == username {
+			delete(schema.ExternalIdentitiesDataBase.Identities, key)
+		}
+	}
+	for state, pending := range schema.OIDCLoginsDataBase.States {
+		if pending.LinkUsername == username {
+			delete(schema.OIDCLoginsDataBase.States, state)
+		}
+	}
+	for hash, verification := range schema.EmailVerificationsDataBase.Tokens {
+		if verification.Username == username {
+			delete(schema.EmailVerificationsDataBase.Tokens, hash)
+		}
+	}
+	for hash, reset := range schema.PasswordResetsDataBase.Tokens {
+		if reset.Username == username {
+			delete(schema.PasswordResetsDataBase.Tokens, hash)
+		}
+	}
+	for key, reservation := range schema.UsernameReservations.Names {
+		if reservation.ReservedFor == username {
+			delete(schema.UsernameReservations.Names, key)
+		}
+	}
+
+	auth.UsernameLoginGuard.Reset(guardKey(username))
+	schema.DeleteUser(ctx, username)
+}
+
+// keeps an account scheduled for deletion, once its owner proves they still want it
+func cancelScheduledDeletion(req *http.Request, user schema.UserBase) {
+	if user.DeleteAfter == nil {
+		return
+	}
+
+	middleware.Printf(req, "cancelled the scheduled deletion of %v", user.Username)
+	user.DeleteAfter = nil
+	schema.Database.Users[user.Username] = user
+}
+
+// deletes the accounts whose grace period ended before now, returning how many.
+// It runs outside of any request, so it finds the accounts due alongside the
+// requests and only takes the store to itself to delete them
+func PurgeScheduledDeletions(now time.Time) int {
+	due := func(user schema.UserBase) bool {
+		return user.DeleteAfter != nil && !now.Before(*user.DeleteAfter)
+	}
+
+	usernames := []string{}
+	release := schema.Share()
+	for username, user := range schema.Database.Users {
+		if due(user) {
+			usernames = append(usernames, username)
+		}
+	}
+	release()
+	if len(usernames) == 0 {
+		return 0
+	}
+
+	purged := 0
+	schema.Exclusive(func() {
+		for _, username := range usernames {
+			// the owner may have signed in and cancelled the deletion since
+			if user, exists := schema.Database.Users[username]; exists && due(user) {
+				deleteAccount(context.Background(), username)
+				purged++
+			}
+		}
+	})
+	return purged
+}
+
+// runs PurgeScheduledDeletions every auth.account_deletion_interval until ctx
+// is done, the returned channel is closed once a purge in progress has finished
+// and the worker stopped. No worker is started when accounts are deleted right
+// away, and the channel is closed already
+func StartDeletionWorker(ctx context.Context, cfg config.Config) <-chan struct{} {
+	done := make(chan struct{})
+	if cfg.Auth.AccountDeletionGracePeriod <= 0 {
+		close(done)
+		return done
+	}
+
+	interval := cfg.Auth.AccountDeletionInterval
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if purged := PurgeScheduledDeletions(now); purged > 0 {
+					slog.Info("deleted accounts after their grace period", "count", purged)
+				}
+			}
+		}
+	}()
+	return done
+}
+
+// adds a JSON file to the export archive
+func writeExportFile(archive *zip.Writer, name string, data any) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(data)
+}
+
+// download all personal data GET /api/v1/users/export
+func (r *UserRouter) HandleExportUser(w http.ResponseWriter, req *http.Request) {
+	req, span := traceHandler(req, "UserRouter.HandleExportUser")
+	defer span.End()
+
+	username, ok := req.Context().Value("username").(string)
+	if !ok {
+		middleware.Println(req, "User not authenticated")
+		middleware.Error(w, req, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	user, exists := schema.Database.Users[username]
+	if !exists {
+		middleware.Printf(req, "username %s does not exists in the database", username)
+		middleware.Error(w, req, "User does not exists", http.StatusForbidden)
+		return
+	}
+
+	todos := schema.TodosDataBase.User[username].AllTodos
+	if todos == nil {
+		todos = []schema.TodoSchema{}
+	}
+
+	tokens := []schema.PersonalAccessToken{}
+	for _, pat := range schema.TokensDataBase.Tokens {
+		if pat.Username == username {
+			tokens = append(tokens, schema.WithLastUsed(pat))
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID < tokens[j].ID })
+
+	identities := []schema.ExternalIdentity{}
+	for _, identity := range schema.ExternalIdentitiesDataBase.Identities {
+		if identity.Username == username {
+			identities = append(identities, identity)
+		}
+	}
+
+	clients := []schema.OAuthClient{}
+	for _, client := range schema.OAuthStore.Clients {
+		if client.Owner == username {
+			clients = append(clients, client)
+		}
+	}
+
+	// applications the user has granted access to
+	authorizations := []map[string]any{}
+	for _, accessToken := range schema.OAuthStore.Tokens {
+		if accessToken.Username == username {
+			authorizations = append(authorizations, map[string]any{
+				"client_id":  accessToken.ClientID,
+				"client":     schema.OAuthStore.Clients[accessToken.ClientID].Name,
+				"scopes":     accessToken.Scopes,
+				"issued_at":  accessToken.IssuedAt,
+				"expires_at": accessToken.ExpiresAt,
+			})
+		}
+	}
+
+	// admin actions taken on the account
+	auditEntries := []schema.AuditEntry{}
+	for _, entry := range schema.AuditLog.Entries {
+		if entry.Target == username {
+			auditEntries = append(auditEntries, entry)
+		}
+	}
+
+	// the archive is built in memory, so a failure can still be answered with a 500
+	buf := &bytes.Buffer{}
+	archive := zip.NewWriter(buf)
+	files := []struct {
+		name string
+		data any
+	}{
+		{"profile.json", user},
+		{"todos.json", todos},
+		{"personal_access_tokens.json", tokens},
+		{"linked_identities.json", identities},
+		{"oauth_clients.json", clients},
+		{"oauth_authorizations.json", authorizations},
+		{"audit_log.json", auditEntries},
+	}
+	for _, file := range files {
+		if err := writeExportFile(archive, file.name, file.data); err != nil {
+			middleware.Printf(req, "could not write %v to the export: %v", file.name, err)
+			middleware.Error(w, req, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		middleware.Printf(req, "could not finish the export: %v", err)
+		middleware.Error(w, req, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%v-export.zip"`, username))
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if _, err := buf.WriteTo(w); err != nil {
+		middleware.Printf(req, "could not send the export: %v", err)
+	}
+}
@@ -0,0 +1,294 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/johnson-oragui/golang-todo-api/auth"
+	"github.com/johnson-oragui/golang-todo-api/config"
+	"github.com/johnson-oragui/golang-todo-api/middleware"
+	"github.com/johnson-oragui/golang-todo-api/schema"
+)
+
+type AdminRouter struct {
+	baseURL          string
+	passwordResetTTL time.Duration
+}
+
+func NewAdminRouter(cfg config.Config) *AdminRouter {
+	return &AdminRouter{
+		baseURL:          cfg.Server.BaseURL,
+		passwordResetTTL: cfg.Auth.PasswordResetTTL,
+	}
+}
+
+// creates an administrator account, used to bootstrap the first admin on startup
+func SeedAdmin(input schema.UserSchemaInput) error {
+	if err := input.ValidateUserBase(); err != nil {
+		return err
+	}
+
+	if _, exists := schema.FindUserByUsername(context.Background(), input.Username); exists {
+		return fmt.Errorf("user %v already exists", input.Username)
+	}
+
+	hashedPassword, err := auth.HashPassword(context.Background(), input.Password)
+	if err != nil {
+		return err
+	}
+
+	return schema.SaveUser(context.Background(), schema.UserBase{
+		ID:            len(schema.Database.Users) + 1,
+		Username:      input.Username,
+		FirstName:     input.FirstName,
+		LastName:      input.LastName,
+		Email:         input.Email,
+		EmailVerified: true,
+		Password:      hashedPassword,
+		Role:          auth.RoleAdmin,
+	})
+}
+
+// records an admin action in the audit trail
+func recordAudit(actor, action, target, details string) {
+	schema.AuditLog.Entries = append(schema.AuditLog.Entries, schema.AuditEntry{
+		ID:      len(schema.AuditLog.Entries) + 1,
+		Actor:   actor,
+		Action:  action,
+		Target:  target,
+		Details: details,
+		Time:    time.Now(),
+	})
+}
+
+// writes a JSON success response
+func writeAdminResponse(w http.ResponseWriter, req *http.Request, message string, data any) {
+	response := schema.TodoResponse{
+		Response: schema.Response{
+			Message:    message,
+			StatusCode: 200,
+		},
+		Data: data,
+	}
+
+	middleware.Respond(w, req, http.StatusOK, response)
+}
+
+// list and search users GET /api/v1/admin/users?q=&role=&disabled=
+func (a *AdminRouter) HandleListUsers(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	search := strings.ToLower(query.Get("q"))
+	role := query.Get("role")
+
+	var disabled *bool
+	if value := query.Get("disabled"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			middleware.Error(w, req, "disabled must be true or false", http.StatusBadRequest)
+			return
+		}
+		disabled = &parsed
+	}
+
+	users := []schema.UserBase{}
+	for _, user := range schema.Database.Users {
+		if search != "" &&
+			!strings.Contains(strings.ToLower(user.Username), search) &&
+			!strings.Contains(strings.ToLower(user.Email), search) &&
+			!strings.Contains(strings.ToLower(user.FirstName+" "+user.LastName), search) {
+			continue
+		}
+		if role != "" && user.Role != role {
+			continue
+		}
+		if disabled != nil && user.Disabled != *disabled {
+			continue
+		}
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+
+	writeAdminResponse(w, req, "Users retrieved successfully", users)
+}
+
+// fetch any user GET /api/v1/admin/users/{username}
+func (a *AdminRouter) HandleGetUser(w http.ResponseWriter, req *http.Request) {
+	actor, _ := req.Context().Value("username").(string)
+	username := mux.Vars(req)["username"]
+
+	user, exists := schema.Database.Users[username]
+	if !exists {
+		middleware.Printf(req, "username %v does not exist", username)
+		middleware.Error(w, req, "User not Found", http.StatusNotFound)
+		return
+	}
+
+	recordAudit(actor, "view_user", username, "")
+
+	writeAdminResponse(w, req, "Retrieved successfully", user)
+}
+
+// fetch any user's todos GET /api/v1/admin/users/{username}/todos
+func (a *AdminRouter) HandleGetUserTodos(w http.ResponseWriter, req *http.Request) {
+	actor, _ := req.Context().Value("username").(string)
+	username := mux.Vars(req)["username"]
+
+	if _, exists := schema.Database.Users[username]; !exists {
+		middleware.Printf(req, "username %v does not exist", username)
+		middleware.Error(w, req, "User not Found", http.StatusNotFound)
+		return
+	}
+
+	todos := schema.TodosDataBase.User[username].AllTodos
+	if todos == nil {
+		todos = []schema.TodoSchema{}
+	}
+
+	recordAudit(actor, "view_todos", username, "")
+
+	writeAdminResponse(w, req, "Todos retrieved successfully", todos)
+}
+
+// change a user's role PUT /api/v1/admin/users/{username}/role
+func (a *AdminRouter) HandleUpdateRole(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Content-Type") != "application/json" {
+		middleware.Error(w, req, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	actor, _ := req.Context().Value("username").(string)
+	username := mux.Vars(req)["username"]
+
+	input := schema.RoleInput{}
+	if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+		middleware.Printf(req, "error decoding request body: %v", err)
+		middleware.Error(w, req, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	defer req.Body.Close()
+
+	if !auth.IsValidRole(input.Role) {
+		middleware.Error(w, req, fmt.Sprintf("invalid role '%v', allowed roles: %v", input.Role, auth.Roles), http.StatusBadRequest)
+		return
+	}
+
+	if username == actor {
+		middleware.Error(w, req, "admins cannot change their own role", http.StatusBadRequest)
+		return
+	}
+
+	user, exists := schema.Database.Users[username]
+	if !exists {
+		middleware.Printf(req, "username %v does not exist", username)
+		middleware.Error(w, req, "User not Found", http.StatusNotFound)
+		return
+	}
+
+	previous := user.Role
+	user.Role = input.Role
+	schema.Database.Users[username] = user
+
+	recordAudit(actor, "update_role", username, fmt.Sprintf("%v -> %v", previous, input.Role))
+
+	writeAdminResponse(w, req, "Role updated successfully", user)
+}
+
+// disable an account POST /api/v1/admin/users/{username}/disable
+func (a *AdminRouter) HandleDisableUser(w http.ResponseWriter, req *http.Request) {
+	a.setDisabled(w, req, true)
+}
+
+// enable an account POST /api/v1/admin/users/{username}/enable
+func (a *AdminRouter) HandleEnableUser(w http.ResponseWriter, req *http.Request) {
+	a.setDisabled(w, req, false)
+}
+
+func (a *AdminRouter) setDisabled(w http.ResponseWriter, req *http.Request, disabled bool) {
+	actor, _ := req.Context().Value("username").(string)
+	username := mux.Vars(req)["username"]
+
+	if username == actor {
+		middleware.Error(w, req, "admins cannot disable their own account", http.StatusBadRequest)
+		return
+	}
+
+	user, exists := schema.Database.Users[username]
+	if !exists {
+		middleware.Printf(req, "username %v does not exist", username)
+		middleware.Error(w, req, "User not Found", http.StatusNotFound)
+		return
+	}
+
+	user.Disabled = disabled
+	schema.Database.Users[username] = user
+
+	action, message := "enable_user", "User enabled successfully"
+	if disabled {
+		action, message = "disable_user", "User disabled successfully"
+	}
+	recordAudit(actor, action, username, "")
+
+	writeAdminResponse(w, req, message, user)
+}
+
+// force a password reset POST /api/v1/admin/users/{username}/password-reset
+func (a *AdminRouter) HandleForcePasswordReset(w http.ResponseWriter, req *http.Request) {
+	actor, _ := req.Context().Value("username").(string)
+	username := mux.Vars(req)["username"]
+
+	user, exists := schema.Database.Users[username]
+	if !exists {
+		middleware.Printf(req, "username %v does not exist", username)
+		middleware.Error(w, req, "User not Found", http.StatusNotFound)
+		return
+	}
+
+	// the user can neither log in nor use existing tokens until the password is reset
+	user.PasswordResetRequired = true
+	schema.Database.Users[username] = user
+
+	if err := a.sendPasswordResetEmail(user); err != nil {
+		middleware.Printf(req, "could not send password reset email: %v", err)
+	}
+
+	recordAudit(actor, "force_password_reset", username, "")
+
+	writeAdminResponse(w, req, "Password reset required for user", user)
+}
+
+// lift a lockout from failed logins POST /api/v1/admin/users/{username}/unlock
+func (a *AdminRouter) HandleUnlockUser(w http.ResponseWriter, req *http.Request) {
+	actor, _ := req.Context().Value("username").(string)
+	username := mux.Vars(req)["username"]
+
+	user, exists := schema.Database.Users[username]
+	if !exists {
+		middleware.Printf(req, "username %v does not exist", username)
+		middleware.Error(w, req, "User not Found", http.StatusNotFound)
+		return
+	}
+
+	auth.UsernameLoginGuard.Reset(guardKey(user.Username))
+
+	recordAudit(actor, "unlock_user", username, "")
+
+	writeAdminResponse(w, req, "User unlocked successfully", user)
+}
+
+// view the audit trail GET /api/v1/admin/audit
+func (a *AdminRouter) HandleGetAuditLog(w http.ResponseWriter, req *http.Request) {
+	entries := schema.AuditLog.Entries
+	if entries == nil {
+		entries = []schema.AuditEntry{}
+	}
+
+	writeAdminResponse(w, req, "Audit log retrieved successfully", entries)
+}
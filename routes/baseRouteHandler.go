@@ -1,10 +1,9 @@
 package routes
 
 import (
-	"encoding/json"
-	"log"
 	"net/http"
 
+	"github.com/johnson-oragui/golang-todo-api/middleware"
 	"github.com/johnson-oragui/golang-todo-api/schema"
 )
 
@@ -26,8 +25,8 @@ func (b *BaseRouter) HandleRoute(w http.ResponseWriter, req *http.Request) {
 // root handler function  GET
 func (s *BaseRouter) HomeHandler(w http.ResponseWriter, req *http.Request) {
 	if req.Method != http.MethodGet {
-		log.Println("Method not allowed")
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		middleware.Println(req, "Method not allowed")
+		middleware.Error(w, req, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 	res := schema.Response{
@@ -38,11 +37,7 @@ func (s *BaseRouter) HomeHandler(w http.ResponseWriter, req *http.Request) {
 		http.NotFound(w, req)
 		return
 	}
-	w.Header().Add("Content-Type", "applicaton/json")
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(res); err != nil {
-		http.Error(w, "Unable to encode JSON", http.StatusInternalServerError)
-	}
+	middleware.Respond(w, req, http.StatusOK, res)
 }
 
 // About page handler GET
@@ -52,10 +47,5 @@ func (b *BaseRouter) HandleAboutPage(w http.ResponseWriter, req *http.Request) {
 		Message:    "This is the about page for the golang todo API",
 	}
 
-	w.Header().Add("Content-Type", "applicaton/json")
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Println("Error encoding data for about page")
-		http.Error(w, "Error encoding data for about page", http.StatusInternalServerError)
-	}
+	middleware.Respond(w, req, http.StatusOK, response)
 }
@@ -0,0 +1,107 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"runtime/debug"
+	"sync/atomic"
+
+	"github.com/johnson-oragui/golang-todo-api/middleware"
+	"github.com/johnson-oragui/golang-todo-api/schema"
+)
+
+// ReadinessChecks must all pass for /readyz to report the API ready, add a
+// check for each dependency requests cannot be served without
+var ReadinessChecks = map[string]func(ctx context.Context) error{
+	"storage": schema.Ping,
+	"schema":  schema.CheckSchemaVersion,
+}
+
+var shuttingDown atomic.Bool
+
+// BeginShutdown fails the readiness probe so load balancers stop sending
+// requests while the server finishes the ones in flight
+func BeginShutdown() {
+	shuttingDown.Store(true)
+}
+
+type HealthRouter struct{}
+
+func NewHealthRouter() *HealthRouter {
+	return &HealthRouter{}
+}
+
+// liveness probe, the process is up and serving GET /healthz
+func (h *HealthRouter) HandleHealth(w http.ResponseWriter, req *http.Request) {
+	middleware.Respond(w, req, http.StatusOK, schema.Response{
+		Message:    "ok",
+		StatusCode: 200,
+	})
+}
+
+// readiness probe, the API can serve requests GET /readyz
+func (h *HealthRouter) HandleReady(w http.ResponseWriter, req *http.Request) {
+	checks := map[string]string{}
+	ready := true
+
+	if shuttingDown.Load() {
+		checks["shutdown"] = "shutting down"
+		ready = false
+	}
+	for name, check := range ReadinessChecks {
+		checks[name] = "ok"
+		if err := check(req.Context()); err != nil {
+			middleware.Printf(req, "readiness check %v failed: %v", name, err)
+			checks[name] = err.Error()
+			ready = false
+		}
+	}
+
+	status, message := http.StatusOK, "ready"
+	if !ready {
+		status, message = http.StatusServiceUnavailable, "not ready"
+	}
+	middleware.Respond(w, req, status, schema.TodoResponse{
+		Response: schema.Response{
+			Message:    message,
+			StatusCode: status,
+		},
+		Data: checks,
+	})
+}
+
+// build information GET /api/v1/version
+func (h *HealthRouter) HandleVersion(w http.ResponseWriter, req *http.Request) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		middleware.Println(req, "build information is not available")
+		middleware.Error(w, req, "build information is not available", http.StatusInternalServerError)
+		return
+	}
+
+	version := map[string]any{
+		"version":    info.Main.Version,
+		"go_version": info.GoVersion,
+		"commit":     "",
+		"build_time": "",
+		"modified":   false,
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			version["commit"] = setting.Value
+		case "vcs.time":
+			version["build_time"] = setting.Value
+		case "vcs.modified":
+			version["modified"] = setting.Value == "true"
+		}
+	}
+
+	middleware.Respond(w, req, http.StatusOK, schema.TodoResponse{
+		Response: schema.Response{
+			Message:    "Build information",
+			StatusCode: 200,
+		},
+		Data: version,
+	})
+}
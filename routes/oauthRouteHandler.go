@@ -0,0 +1,621 @@
+package routes
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/johnson-oragui/golang-todo-api/auth"
+	"github.com/johnson-oragui/golang-todo-api/config"
+	"github.com/johnson-oragui/golang-todo-api/middleware"
+	"github.com/johnson-oragui/golang-todo-api/oidc"
+	"github.com/johnson-oragui/golang-todo-api/schema"
+)
+
+type OAuthRouter struct {
+	codeTTL        time.Duration
+	accessTokenTTL time.Duration
+}
+
+func NewOAuthRouter(cfg config.Config) *OAuthRouter {
+	return &OAuthRouter{
+		codeTTL:        cfg.OAuth.CodeTTL,
+		accessTokenTTL: cfg.OAuth.AccessTokenTTL,
+	}
+}
+
+var consentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Authorize {{.ClientName}}</title>
+</head>
+<body>
+<h1>{{.ClientName}} wants to access your account</h1>
+<p>It will be able to:</p>
+<ul>
+{{range .Scopes}}<li>{{.}}</li>
+{{end}}</ul>
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+<form method="post" action="/api/v1/oauth/authorize">
+<input type="hidden" name="client_id" value="{{.Request.ClientID}}">
+<input type="hidden" name="redirect_uri" value="{{.Request.RedirectURI}}">
+<input type="hidden" name="scope" value="{{.Request.Scope}}">
+<input type="hidden" name="state" value="{{.Request.State}}">
+<input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="{{.Request.CodeChallengeMethod}}">
+<label>Username <input name="username" autocomplete="username" required></label>
+<label>Password <input type="password" name="password" autocomplete="current-password" required></label>
+<label>Authentication code (if 2FA is enabled) <input name="code" autocomplete="one-time-code"></label>
+<button type="submit" name="decision" value="approve">Allow</button>
+<button type="submit" name="decision" value="deny" formnovalidate>Deny</button>
+</form>
+</body>
+</html>
+`))
+
+// descriptions shown on the consent page
+var scopeDescriptions = map[string]string{
+	auth.ScopeTodosRead:    "Read your todos",
+	auth.ScopeTodosWrite:   "Create, update and delete your todos",
+	auth.ScopeProfileRead:  "Read your profile",
+	auth.ScopeProfileWrite: "Update your profile",
+}
+
+// parameters of an authorization request, carried from the consent page to its form submission
+type authorizationRequest struct {
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+
+	client schema.OAuthClient
+	scopes []string
+}
+
+// error reported to the client as defined in RFC 6749
+type oauthError struct {
+	Code        string
+	Description string
+}
+
+func (e *oauthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// only https, and plain http back to the user's own machine, can receive codes
+func validateRedirectURI(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return fmt.Errorf("redirect URI '%v' must be an absolute URL", raw)
+	}
+	if u.Fragment != "" {
+		return fmt.Errorf("redirect URI '%v' must not contain a fragment", raw)
+	}
+
+	switch u.Scheme {
+	case "https":
+		return nil
+	case "http":
+		if host := u.Hostname(); host == "localhost" || net.ParseIP(host).IsLoopback() {
+			return nil
+		}
+	}
+	return fmt.Errorf("redirect URI '%v' must use https, or http on localhost", raw)
+}
+
+// reads an authorization request. Errors about the client or redirect URI are
+// returned as plain errors since the user must not be redirected to an unverified URI
+func parseAuthorizationRequest(values url.Values) (*authorizationRequest, error) {
+	request := &authorizationRequest{
+		ClientID:            values.Get("client_id"),
+		RedirectURI:         values.Get("redirect_uri"),
+		Scope:               values.Get("scope"),
+		State:               values.Get("state"),
+		CodeChallenge:       values.Get("code_challenge"),
+		CodeChallengeMethod: values.Get("code_challenge_method"),
+	}
+
+	client, exists := schema.OAuthStore.Clients[request.ClientID]
+	if !exists {
+		return nil, errors.New("unknown client_id")
+	}
+	if !slices.Contains(client.RedirectURIs, request.RedirectURI) {
+		return nil, errors.New("redirect_uri is not registered for this client")
+	}
+	request.client = client
+
+	if responseType := values.Get("response_type"); responseType != "" && responseType != "code" {
+		return request, &oauthError{"unsupported_response_type", "only the code response type is supported"}
+	}
+	if request.CodeChallenge == "" || request.CodeChallengeMethod != "S256" {
+		return request, &oauthError{"invalid_request", "PKCE with code_challenge_method S256 is required"}
+	}
+
+	for _, scope := range strings.Fields(request.Scope) {
+		if !auth.IsValidScope(scope) {
+			return request, &oauthError{"invalid_scope", fmt.Sprintf("unknown scope '%v'", scope)}
+		}
+		if !slices.Contains(request.scopes, scope) {
+			request.scopes = append(request.scopes, scope)
+		}
+	}
+	if len(request.scopes) == 0 {
+		return request, &oauthError{"invalid_scope", "at least one scope is required"}
+	}
+
+	return request, nil
+}
+
+// sends the user back to the client with params added to the redirect URI
+func redirectToClient(w http.ResponseWriter, req *http.Request, request *authorizationRequest, params map[string]string) {
+	u, _ := url.Parse(request.RedirectURI)
+	query := u.Query()
+	for key, value := range params {
+		query.Set(key, value)
+	}
+	if request.State != "" {
+		query.Set("state", request.State)
+	}
+	u.RawQuery = query.Encode()
+
+	http.Redirect(w, req, u.String(), http.StatusSeeOther)
+}
+
+func renderConsent(w http.ResponseWriter, req *http.Request, request *authorizationRequest, status int, errorMessage string) {
+	scopes := []string{}
+	for _, scope := range request.scopes {
+		scopes = append(scopes, scopeDescriptions[scope])
+	}
+
+	// the page takes credentials, so it must never be framed or cached
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+	w.WriteHeader(status)
+
+	err := consentTemplate.Execute(w, map[string]any{
+		"ClientName": request.client.Name,
+		"Scopes":     scopes,
+		"Error":      errorMessage,
+		"Request":    request,
+	})
+	if err != nil {
+		middleware.Printf(req, "could not render consent page: %v", err)
+	}
+}
+
+// writes a JSON error response from the token, introspection and revocation endpoints
+func writeOAuthError(w http.ResponseWriter, req *http.Request, status int, err *oauthError) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":             err.Code,
+		"error_description": err.Description,
+		"request_id":        middleware.RequestID(req.Context()),
+	})
+}
+
+// identifies the client calling the token, introspection or revocation endpoint.
+// Confidential clients must authenticate with their secret
+func authenticateClient(req *http.Request) (schema.OAuthClient, bool) {
+	clientID, clientSecret, basic := req.BasicAuth()
+	if basic {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = req.PostForm.Get("client_id"), req.PostForm.Get("client_secret")
+	}
+
+	client, exists := schema.OAuthStore.Clients[clientID]
+	if !exists {
+		return schema.OAuthClient{}, false
+	}
+	if client.Confidential && subtle.ConstantTimeCompare([]byte(auth.HashToken(clientSecret)), []byte(client.SecretHash)) != 1 {
+		return schema.OAuthClient{}, false
+	}
+	return client, true
+}
+
+// register an OAuth client POST /api/v1/oauth/clients
+func (o *OAuthRouter) HandleRegisterClient(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Content-Type") != "application/json" {
+		middleware.Error(w, req, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	username, ok := req.Context().Value("username").(string)
+	if !ok {
+		middleware.Println(req, "User not authenticated")
+		middleware.Error(w, req, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	input := schema.OAuthClientInput{}
+	if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+		middleware.Printf(req, "error decoding request body: %v", err)
+		middleware.Error(w, req, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	defer req.Body.Close()
+
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" {
+		middleware.Error(w, req, "client name is required", http.StatusBadRequest)
+		return
+	}
+	if len(input.RedirectURIs) == 0 {
+		middleware.Error(w, req, "at least one redirect URI is required", http.StatusBadRequest)
+		return
+	}
+	for _, redirectURI := range input.RedirectURIs {
+		if err := validateRedirectURI(redirectURI); err != nil {
+			middleware.Println(req, err)
+			middleware.Error(w, req, fmt.Sprint(err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	clientID, err := auth.GenerateRandomToken()
+	if err != nil {
+		middleware.Printf(req, "could not generate client id: %v", err)
+		middleware.Error(w, req, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	client := schema.OAuthClient{
+		ClientID:     clientID,
+		Name:         input.Name,
+		RedirectURIs: input.RedirectURIs,
+		Confidential: input.Confidential,
+		Owner:        username,
+		CreatedAt:    time.Now(),
+	}
+
+	data := map[string]any{"client": client}
+
+	// only the hash of the secret is kept, the secret itself is returned once
+	if client.Confidential {
+		secret, err := auth.GenerateRandomToken()
+		if err != nil {
+			middleware.Printf(req, "could not generate client secret: %v", err)
+			middleware.Error(w, req, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		client.SecretHash = auth.HashToken(secret)
+		data["client_secret"] = secret
+	}
+
+	schema.OAuthStore.Clients[client.ClientID] = client
+
+	response := schema.TodoResponse{
+		Response: schema.Response{
+			Message:    "Client registered successfully",
+			StatusCode: 201,
+		},
+		Data: data,
+	}
+
+	middleware.Respond(w, req, http.StatusCreated, response)
+}
+
+// list the user's OAuth clients GET /api/v1/oauth/clients
+func (o *OAuthRouter) HandleGetClients(w http.ResponseWriter, req *http.Request) {
+	username, ok := req.Context().Value("username").(string)
+	if !ok {
+		middleware.Println(req, "User not authenticated")
+		middleware.Error(w, req, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	clients := []schema.OAuthClient{}
+	for _, client := range schema.OAuthStore.Clients {
+		if client.Owner == username {
+			clients = append(clients, client)
+		}
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].CreatedAt.Before(clients[j].CreatedAt) })
+
+	response := schema.TodoResponse{
+		Response: schema.Response{
+			Message:    "Clients retrieved successfully",
+			StatusCode: 200,
+		},
+		Data: clients,
+	}
+
+	middleware.Respond(w, req, http.StatusOK, response)
+}
+
+// delete an OAuth client and revoke its tokens DELETE /api/v1/oauth/clients/{client_id}
+func (o *OAuthRouter) HandleDeleteClient(w http.ResponseWriter, req *http.Request) {
+	username, ok := req.Context().Value("username").(string)
+	if !ok {
+		middleware.Println(req, "User not authenticated")
+		middleware.Error(w, req, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	clientID := mux.Vars(req)["client_id"]
+	client, exists := schema.OAuthStore.Clients[clientID]
+	if !exists || client.Owner != username {
+		middleware.Println(req, "client not found")
+		middleware.Error(w, req, "client not found", http.StatusNotFound)
+		return
+	}
+
+	delete(schema.OAuthStore.Clients, clientID)
+	for hash, code := range schema.OAuthStore.Codes {
+		if code.ClientID == clientID {
+			delete(schema.OAuthStore.Codes, hash)
+		}
+	}
+	for hash, accessToken := range schema.OAuthStore.Tokens {
+		if accessToken.ClientID == clientID {
+			delete(schema.OAuthStore.Tokens, hash)
+		}
+	}
+
+	response := schema.Response{
+		Message:    "Client deleted successfully",
+		StatusCode: 200,
+	}
+	middleware.Respond(w, req, http.StatusOK, response)
+}
+
+// show the consent page GET /api/v1/oauth/authorize
+func (o *OAuthRouter) HandleAuthorize(w http.ResponseWriter, req *http.Request) {
+	request, err := parseAuthorizationRequest(req.URL.Query())
+	if request == nil {
+		middleware.Printf(req, "invalid authorization request: %v", err)
+		middleware.Error(w, req, fmt.Sprint(err), http.StatusBadRequest)
+		return
+	}
+
+	var oauthErr *oauthError
+	if errors.As(err, &oauthErr) {
+		middleware.Printf(req, "invalid authorization request: %v", err)
+		redirectToClient(w, req, request, map[string]string{"error": oauthErr.Code, "error_description": oauthErr.Description})
+		return
+	}
+
+	renderConsent(w, req, request, http.StatusOK, "")
+}
+
+// approve or deny a client from the consent page POST /api/v1/oauth/authorize
+func (o *OAuthRouter) HandleAuthorizeDecision(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		middleware.Printf(req, "error parsing form: %v", err)
+		middleware.Error(w, req, "Invalid form", http.StatusBadRequest)
+		return
+	}
+
+	request, err := parseAuthorizationRequest(req.PostForm)
+	if request == nil {
+		middleware.Printf(req, "invalid authorization request: %v", err)
+		middleware.Error(w, req, fmt.Sprint(err), http.StatusBadRequest)
+		return
+	}
+
+	var oauthErr *oauthError
+	if errors.As(err, &oauthErr) {
+		middleware.Printf(req, "invalid authorization request: %v", err)
+		redirectToClient(w, req, request, map[string]string{"error": oauthErr.Code, "error_description": oauthErr.Description})
+		return
+	}
+
+	if req.PostForm.Get("decision") != "approve" {
+		middleware.Printf(req, "user denied access to client %v", request.ClientID)
+		redirectToClient(w, req, request, map[string]string{"error": "access_denied"})
+		return
+	}
+
+	// the consent form takes the same credentials as a login, with the same protection
+	user, exists := schema.FindUser(req.Context(), req.PostForm.Get("username"))
+	key := guardKey(req.PostForm.Get("username"))
+	if exists {
+		key = guardKey(user.Username)
+	}
+	ip := middleware.ClientIP(req)
+	if loginBlocked(w, req, key, ip) {
+		middleware.Printf(req, "login for %v from %v is blocked after failed attempts", key, ip)
+		return
+	}
+
+	if !exists || auth.ComparePasswords(req.Context(), req.PostForm.Get("password"), user.Password) != nil {
+		middleware.Printf(req, "invalid credentials on the consent page")
+		recordLoginFailure(key, ip)
+		renderConsent(w, req, request, http.StatusUnauthorized, "Invalid username or password")
+		return
+	}
+	if user.TOTPEnabled {
+		if !verifySecondFactor(&user, req.PostForm.Get("code"), "") {
+			middleware.Printf(req, "invalid second factor for %v", user.Username)
+			recordLoginFailure(key, ip)
+			renderConsent(w, req, request, http.StatusUnauthorized, "Invalid authentication code")
+			return
+		}
+		schema.Database.Users[user.Username] = user
+	}
+	auth.UsernameLoginGuard.Reset(key)
+
+	if user.Disabled || user.PasswordResetRequired {
+		middleware.Printf(req, "account %v cannot authorize clients", user.Username)
+		renderConsent(w, req, request, http.StatusForbidden, "This account cannot be used at the moment")
+		return
+	}
+
+	code, err := auth.GenerateRandomToken()
+	if err != nil {
+		middleware.Printf(req, "could not generate authorization code: %v", err)
+		middleware.Error(w, req, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	schema.OAuthStore.Codes[auth.HashToken(code)] = schema.OAuthAuthorizationCode{
+		ClientID:      request.ClientID,
+		Username:      user.Username,
+		RedirectURI:   request.RedirectURI,
+		Scopes:        request.scopes,
+		CodeChallenge: request.CodeChallenge,
+		ExpiresAt:     time.Now().Add(o.codeTTL),
+	}
+
+	redirectToClient(w, req, request, map[string]string{"code": code})
+}
+
+// exchange an authorization code for an access token POST /api/v1/oauth/token
+func (o *OAuthRouter) HandleToken(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		middleware.Printf(req, "error parsing form: %v", err)
+		writeOAuthError(w, req, http.StatusBadRequest, &oauthError{"invalid_request", "invalid form body"})
+		return
+	}
+
+	client, ok := authenticateClient(req)
+	if !ok {
+		middleware.Println(req, "invalid OAuth client credentials")
+		writeOAuthError(w, req, http.StatusUnauthorized, &oauthError{"invalid_client", "client authentication failed"})
+		return
+	}
+
+	if req.PostForm.Get("grant_type") != "authorization_code" {
+		writeOAuthError(w, req, http.StatusBadRequest, &oauthError{"unsupported_grant_type", "only the authorization_code grant is supported"})
+		return
+	}
+
+	codeHash := auth.HashToken(req.PostForm.Get("code"))
+	code, exists := schema.OAuthStore.Codes[codeHash]
+	// codes are single use
+	delete(schema.OAuthStore.Codes, codeHash)
+
+	if !exists || time.Now().After(code.ExpiresAt) || code.ClientID != client.ClientID || code.RedirectURI != req.PostForm.Get("redirect_uri") {
+		middleware.Println(req, "invalid authorization code")
+		writeOAuthError(w, req, http.StatusBadRequest, &oauthError{"invalid_grant", "invalid or expired authorization code"})
+		return
+	}
+
+	verifier := req.PostForm.Get("code_verifier")
+	if verifier == "" || subtle.ConstantTimeCompare([]byte(oidc.CodeChallengeS256(verifier)), []byte(code.CodeChallenge)) != 1 {
+		middleware.Println(req, "PKCE verification failed")
+		writeOAuthError(w, req, http.StatusBadRequest, &oauthError{"invalid_grant", "code_verifier does not match the code_challenge"})
+		return
+	}
+
+	user, exists := schema.Database.Users[code.Username]
+	if !exists || user.Disabled {
+		middleware.Printf(req, "user %v can no longer be authorized", code.Username)
+		writeOAuthError(w, req, http.StatusBadRequest, &oauthError{"invalid_grant", "the user can no longer be authorized"})
+		return
+	}
+
+	token, err := auth.GenerateOAuthAccessToken()
+	if err != nil {
+		middleware.Printf(req, "could not generate access token: %v", err)
+		writeOAuthError(w, req, http.StatusInternalServerError, &oauthError{"server_error", ""})
+		return
+	}
+
+	now := time.Now()
+	schema.OAuthStore.Tokens[auth.HashToken(token)] = schema.OAuthAccessToken{
+		ClientID:  client.ClientID,
+		Username:  code.Username,
+		Scopes:    code.Scopes,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(o.accessTokenTTL),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]any{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(o.accessTokenTTL.Seconds()),
+		"scope":        strings.Join(code.Scopes, " "),
+	}); err != nil {
+		middleware.Println(req, "error encoding JSON")
+	}
+}
+
+// describe an access token to the client it was issued to POST /api/v1/oauth/introspect
+func (o *OAuthRouter) HandleIntrospect(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		middleware.Printf(req, "error parsing form: %v", err)
+		writeOAuthError(w, req, http.StatusBadRequest, &oauthError{"invalid_request", "invalid form body"})
+		return
+	}
+
+	client, ok := authenticateClient(req)
+	if !ok {
+		middleware.Println(req, "invalid OAuth client credentials")
+		writeOAuthError(w, req, http.StatusUnauthorized, &oauthError{"invalid_client", "client authentication failed"})
+		return
+	}
+
+	response := map[string]any{"active": false}
+
+	// clients only learn about their own tokens
+	accessToken, exists := schema.OAuthStore.Tokens[auth.HashToken(req.PostForm.Get("token"))]
+	if exists && accessToken.ClientID == client.ClientID && time.Now().Before(accessToken.ExpiresAt) {
+		response = map[string]any{
+			"active":     true,
+			"scope":      strings.Join(accessToken.Scopes, " "),
+			"client_id":  accessToken.ClientID,
+			"username":   accessToken.Username,
+			"sub":        accessToken.Username,
+			"token_type": "Bearer",
+			"iat":        accessToken.IssuedAt.Unix(),
+			"exp":        accessToken.ExpiresAt.Unix(),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		middleware.Println(req, "error encoding JSON")
+	}
+}
+
+// revoke an access token POST /api/v1/oauth/revoke
+func (o *OAuthRouter) HandleRevoke(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		middleware.Printf(req, "error parsing form: %v", err)
+		writeOAuthError(w, req, http.StatusBadRequest, &oauthError{"invalid_request", "invalid form body"})
+		return
+	}
+
+	client, ok := authenticateClient(req)
+	if !ok {
+		middleware.Println(req, "invalid OAuth client credentials")
+		writeOAuthError(w, req, http.StatusUnauthorized, &oauthError{"invalid_client", "client authentication failed"})
+		return
+	}
+
+	// unknown tokens are not an error, the client's goal is reached either way
+	hash := auth.HashToken(req.PostForm.Get("token"))
+	if accessToken, exists := schema.OAuthStore.Tokens[hash]; exists && accessToken.ClientID == client.ClientID {
+		delete(schema.OAuthStore.Tokens, hash)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,359 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/johnson-oragui/golang-todo-api/auth"
+	"github.com/johnson-oragui/golang-todo-api/metrics"
+	"github.com/johnson-oragui/golang-todo-api/middleware"
+	"github.com/johnson-oragui/golang-todo-api/oidc"
+	"github.com/johnson-oragui/golang-todo-api/schema"
+)
+
+// name of the cookie binding a sign in to the browser that started it
+const oidcStateCookie = "oidc_state"
+
+// stores a pending sign in and returns the provider URL to send the user to
+func (s *UserRouter) startOIDCLogin(w http.ResponseWriter, req *http.Request, linkUsername string) (string, error) {
+	state, err := auth.GenerateRandomToken()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := auth.GenerateRandomToken()
+	if err != nil {
+		return "", err
+	}
+	verifier, err := oidc.GenerateCodeVerifier()
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := s.oidcProvider.AuthCodeURL(req.Context(), state, nonce, oidc.CodeChallengeS256(verifier))
+	if err != nil {
+		return "", err
+	}
+
+	schema.OIDCLoginsDataBase.States[state] = schema.OIDCLogin{
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		LinkUsername: linkUsername,
+		ExpiresAt:    time.Now().Add(s.oidcLoginTTL),
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/v1/auth/oidc",
+		MaxAge:   int(s.oidcLoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(s.baseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return authURL, nil
+}
+
+// builds a free username from the identity claims
+func oidcUsername(ctx context.Context, claims *oidc.Claims) string {
+	candidate := claims.PreferredUsername
+	if candidate == "" {
+		candidate, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	username := strings.Map(func(r rune) rune {
+		if strings.ContainsRune("!@#$%^&*()_| \\/+?><'\"", r) {
+			return -1
+		}
+		return r
+	}, candidate)
+	if schema.ValidateUsername(username) != nil {
+		username = "user"
+	}
+
+	// taken names get a number, after whatever is left once their own trailing digits are dropped
+	base := strings.TrimRight(username, "0123456789")
+	if schema.ValidateUsername(base) != nil {
+		base = "user"
+	}
+	for i := 2; ; i++ {
+		if _, exists := schema.FindUserByUsername(ctx, username); !exists && !schema.UsernameReserved(username, "") {
+			return username
+		}
+		username = fmt.Sprintf("%v%v", base, i)
+	}
+}
+
+// finds the local user for an external identity, linking or creating one on first sign in
+func userForIdentity(ctx context.Context, claims *oidc.Claims) (schema.UserBase, error) {
+	key := schema.ExternalIdentityKey(claims.Issuer, claims.Subject)
+
+	if identity, exists := schema.ExternalIdentitiesDataBase.Identities[key]; exists {
+		user, exists := schema.Database.Users[identity.Username]
+		if !exists {
+			return schema.UserBase{}, fmt.Errorf("user %v linked to %v no longer exists", identity.Username, key)
+		}
+		return user, nil
+	}
+
+	// a verified email on both sides is proof enough that the accounts belong to the same person
+	if claims.EmailVerified && claims.Email != "" {
+		if user, exists := schema.FindUserByEmail(ctx, claims.Email); exists && user.EmailVerified {
+			linkIdentity(claims, user.Username)
+			return user, nil
+		}
+	}
+
+	user := schema.UserBase{
+		ID:            len(schema.Database.Users) + 1,
+		Username:      oidcUsername(ctx, claims),
+		FirstName:     claims.GivenName,
+		LastName:      claims.FamilyName,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Role:          auth.RoleUser,
+	}
+	if err := schema.SaveUser(ctx, user); err != nil {
+		return schema.UserBase{}, err
+	}
+	linkIdentity(claims, user.Username)
+
+	return user, nil
+}
+
+func linkIdentity(claims *oidc.Claims, username string) {
+	schema.ExternalIdentitiesDataBase.Identities[schema.ExternalIdentityKey(claims.Issuer, claims.Subject)] = schema.ExternalIdentity{
+		Issuer:   claims.Issuer,
+		Subject:  claims.Subject,
+		Username: username,
+		Email:    claims.Email,
+		LinkedAt: time.Now(),
+	}
+}
+
+// start signing in with the identity provider GET /api/v1/auth/oidc/login
+func (s *UserRouter) HandleOIDCLogin(w http.ResponseWriter, req *http.Request) {
+	req, span := traceHandler(req, "UserRouter.HandleOIDCLogin")
+	defer span.End()
+
+	if s.oidcProvider == nil {
+		middleware.Println(req, "OIDC login is not configured")
+		middleware.Error(w, req, "OIDC login is not configured", http.StatusNotFound)
+		return
+	}
+
+	authURL, err := s.startOIDCLogin(w, req, "")
+	if err != nil {
+		middleware.Printf(req, "could not start OIDC login: %v", err)
+		middleware.Error(w, req, "identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	http.Redirect(w, req, authURL, http.StatusFound)
+}
+
+// complete signing in with the identity provider GET /api/v1/auth/oidc/callback
+func (s *UserRouter) HandleOIDCCallback(w http.ResponseWriter, req *http.Request) {
+	req, span := traceHandler(req, "UserRouter.HandleOIDCCallback")
+	defer span.End()
+
+	if s.oidcProvider == nil {
+		middleware.Println(req, "OIDC login is not configured")
+		middleware.Error(w, req, "OIDC login is not configured", http.StatusNotFound)
+		return
+	}
+
+	query := req.URL.Query()
+	if providerError := query.Get("error"); providerError != "" {
+		middleware.Printf(req, "identity provider returned an error: %v %v", providerError, query.Get("error_description"))
+		middleware.Error(w, req, "sign in was not completed", http.StatusUnauthorized)
+		return
+	}
+
+	state := query.Get("state")
+	pending, exists := schema.OIDCLoginsDataBase.States[state]
+	// states are single use
+	delete(schema.OIDCLoginsDataBase.States, state)
+
+	cookie, err := req.Cookie(oidcStateCookie)
+	if !exists || err != nil || cookie.Value != state || time.Now().After(pending.ExpiresAt) {
+		middleware.Println(req, "invalid OIDC state")
+		middleware.Error(w, req, "invalid or expired sign in, please try again", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/api/v1/auth/oidc", MaxAge: -1})
+
+	claims, err := s.oidcProvider.Exchange(req.Context(), query.Get("code"), pending.CodeVerifier, pending.Nonce)
+	if err != nil {
+		middleware.Printf(req, "OIDC code exchange failed: %v", err)
+		middleware.Error(w, req, "sign in was not completed", http.StatusUnauthorized)
+		return
+	}
+
+	if pending.LinkUsername != "" {
+		key := schema.ExternalIdentityKey(claims.Issuer, claims.Subject)
+		if identity, exists := schema.ExternalIdentitiesDataBase.Identities[key]; exists && identity.Username != pending.LinkUsername {
+			middleware.Printf(req, "identity %v is already linked to %v", key, identity.Username)
+			middleware.Error(w, req, "identity is already linked to another account", http.StatusConflict)
+			return
+		}
+		if _, exists := schema.Database.Users[pending.LinkUsername]; !exists {
+			middleware.Printf(req, "user %v no longer exists", pending.LinkUsername)
+			middleware.Error(w, req, "user does not exist", http.StatusNotFound)
+			return
+		}
+		linkIdentity(claims, pending.LinkUsername)
+
+		response := schema.Response{
+			Message:    "Identity linked successfully",
+			StatusCode: 200,
+		}
+		middleware.Respond(w, req, http.StatusOK, response)
+		return
+	}
+
+	user, err := userForIdentity(req.Context(), claims)
+	if errors.Is(err, schema.ErrEmailTaken) {
+		middleware.Printf(req, "email of identity %v belongs to an account that has not verified it", claims.Subject)
+		middleware.Error(w, req, "an account with this email already exists, sign in to it and link the identity instead", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		middleware.Println(req, err)
+		middleware.Error(w, req, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if user.Disabled {
+		middleware.Printf(req, "account %v is disabled", user.Username)
+		metrics.Logins.WithLabelValues(metrics.LoginOIDC, metrics.LoginFailure).Inc()
+		middleware.Error(w, req, "account is disabled", http.StatusForbidden)
+		return
+	}
+
+	if user.PasswordResetRequired {
+		middleware.Printf(req, "account %v must reset their password", user.Username)
+		metrics.Logins.WithLabelValues(metrics.LoginOIDC, metrics.LoginFailure).Inc()
+		middleware.Error(w, req, "password reset required, check your email for a reset token", http.StatusForbidden)
+		return
+	}
+
+	// an enabled second factor is still required, as with a password login
+	if user.TOTPEnabled {
+		mfaToken, err := auth.GenerateMFAToken(user.Username)
+		if err != nil {
+			middleware.Println(req, fmt.Sprintln(err))
+			middleware.Error(w, req, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		response := schema.TodoResponse{
+			Response: schema.Response{
+				StatusCode: 200,
+				Message:    "Two-factor authentication required",
+			},
+			Data: map[string]any{
+				"mfa_required": true,
+				"mfa_token":    mfaToken,
+			},
+		}
+		middleware.Respond(w, req, http.StatusOK, response)
+		return
+	}
+
+	// signing in without a pending second factor keeps the account
+	cancelScheduledDeletion(req, user)
+
+	accessToken, err := auth.GenerateJWT(user.Username)
+	if err != nil {
+		middleware.Println(req, fmt.Sprintln(err))
+		middleware.Error(w, req, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	metrics.Logins.WithLabelValues(metrics.LoginOIDC, metrics.LoginSuccess).Inc()
+
+	response := schema.TodoResponse{
+		Response: schema.Response{
+			StatusCode: 200,
+			Message:    "Login Success",
+		},
+		Data: map[string]string{
+			"access_token": accessToken,
+			"username":     user.Username,
+		},
+	}
+	middleware.Respond(w, req, http.StatusOK, response)
+}
+
+// start linking an identity to the signed in user POST /api/v1/users/identities/oidc
+func (s *UserRouter) HandleLinkOIDC(w http.ResponseWriter, req *http.Request) {
+	req, span := traceHandler(req, "UserRouter.HandleLinkOIDC")
+	defer span.End()
+
+	if s.oidcProvider == nil {
+		middleware.Println(req, "OIDC login is not configured")
+		middleware.Error(w, req, "OIDC login is not configured", http.StatusNotFound)
+		return
+	}
+
+	username, ok := req.Context().Value("username").(string)
+	if !ok {
+		middleware.Println(req, "User not authenticated")
+		middleware.Error(w, req, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	authURL, err := s.startOIDCLogin(w, req, username)
+	if err != nil {
+		middleware.Printf(req, "could not start OIDC login: %v", err)
+		middleware.Error(w, req, "identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	response := schema.TodoResponse{
+		Response: schema.Response{
+			Message:    "Visit the authorization URL to link your identity",
+			StatusCode: 200,
+		},
+		Data: map[string]string{
+			"authorization_url": authURL,
+		},
+	}
+	middleware.Respond(w, req, http.StatusOK, response)
+}
+
+// list identities linked to the signed in user GET /api/v1/users/identities
+func (s *UserRouter) HandleGetIdentities(w http.ResponseWriter, req *http.Request) {
+	req, span := traceHandler(req, "UserRouter.HandleGetIdentities")
+	defer span.End()
+
+	username, ok := req.Context().Value("username").(string)
+	if !ok {
+		middleware.Println(req, "User not authenticated")
+		middleware.Error(w, req, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	identities := []schema.ExternalIdentity{}
+	for _, identity := range schema.ExternalIdentitiesDataBase.Identities {
+		if identity.Username == username {
+			identities = append(identities, identity)
+		}
+	}
+	sort.Slice(identities, func(i, j int) bool {
+		return identities[i].LinkedAt.Before(identities[j].LinkedAt)
+	})
+
+	response := schema.TodoResponse{
+		Response: schema.Response{
+			Message:    "Identities retrieved successfully",
+			StatusCode: 200,
+		},
+		Data: identities,
+	}
+	middleware.Respond(w, req, http.StatusOK, response)
+}
@@ -0,0 +1,98 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/johnson-oragui/golang-todo-api/auth"
+	"github.com/johnson-oragui/golang-todo-api/mailer"
+	"github.com/johnson-oragui/golang-todo-api/middleware"
+	"github.com/johnson-oragui/golang-todo-api/schema"
+)
+
+// emails the user a token to set a new password with, after an admin forced a reset
+func (a *AdminRouter) sendPasswordResetEmail(user schema.UserBase) error {
+	token, err := auth.GenerateRandomToken()
+	if err != nil {
+		return err
+	}
+
+	schema.PasswordResetsDataBase.Tokens[auth.HashToken(token)] = schema.PasswordReset{
+		Username:  user.Username,
+		ExpiresAt: time.Now().Add(a.passwordResetTTL),
+	}
+
+	return mailer.Default.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %v,\n\nUse the token below with POST %v/api/v1/auth/password-reset/confirm to choose a new password:\n\n%v\n\nThe token expires in %v.\n",
+			user.FirstName, a.baseURL, token, a.passwordResetTTL),
+	})
+}
+
+// set a new password with a reset token POST /api/v1/auth/password-reset/confirm
+func (s *UserRouter) HandleConfirmPasswordReset(w http.ResponseWriter, req *http.Request) {
+	req, span := traceHandler(req, "UserRouter.HandleConfirmPasswordReset")
+	defer span.End()
+
+	if req.Header.Get("Content-Type") != "application/json" {
+		middleware.Error(w, req, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	input := schema.PasswordResetInput{}
+	if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+		middleware.Printf(req, "error decoding request body: %v", err)
+		middleware.Error(w, req, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	defer req.Body.Close()
+
+	tokenHash := auth.HashToken(input.Token)
+	reset, exists := schema.PasswordResetsDataBase.Tokens[tokenHash]
+	if !exists || time.Now().After(reset.ExpiresAt) {
+		middleware.Println(req, "invalid password reset token")
+		middleware.Error(w, req, "invalid or expired password reset token", http.StatusBadRequest)
+		return
+	}
+
+	if err := schema.ValidatePassword(input.Password); err != nil {
+		middleware.Println(req, err)
+		middleware.Error(w, req, fmt.Sprint(err), http.StatusBadRequest)
+		return
+	}
+
+	user, exists := schema.Database.Users[reset.Username]
+	if !exists {
+		middleware.Printf(req, "user %v no longer exists", reset.Username)
+		middleware.Error(w, req, "invalid or expired password reset token", http.StatusBadRequest)
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(req.Context(), input.Password)
+	if err != nil {
+		middleware.Println(req, "error hashing password")
+		middleware.Error(w, req, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// tokens are single use
+	delete(schema.PasswordResetsDataBase.Tokens, tokenHash)
+
+	user.Password = hashedPassword
+	user.PasswordResetRequired = false
+	schema.Database.Users[user.Username] = user
+	revokeUserTokens(user.Username)
+
+	// resetting the password also lifts a lockout from failed logins
+	auth.UsernameLoginGuard.Reset(guardKey(user.Username))
+
+	response := schema.Response{
+		Message:    "Password reset successfully",
+		StatusCode: 200,
+	}
+
+	middleware.Respond(w, req, http.StatusOK, response)
+}
@@ -4,25 +4,189 @@ import (
 	"net/http"
 
 	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/johnson-oragui/golang-todo-api/auth"
+	"github.com/johnson-oragui/golang-todo-api/config"
+	"github.com/johnson-oragui/golang-todo-api/metrics"
 	"github.com/johnson-oragui/golang-todo-api/middleware"
+	"github.com/johnson-oragui/golang-todo-api/ratelimit"
+	"github.com/johnson-oragui/golang-todo-api/tracing"
 )
 
 // myHandler sets the server routes
-func MyHandler() http.Handler {
+func MyHandler(cfg config.Config) http.Handler {
 	router := mux.NewRouter()
 
-	baseRouter := NewBaseRouter() // Base Handler
-	userRouter := NewUserRouter() // Users Handler
-	todoRouter := NewTodoRouter() // Todos Handler
-
-	// Define handlers
-	router.HandleFunc("/", baseRouter.HomeHandler).Methods("GET")                                                                     // root handler
-	router.HandleFunc("/api/v1/about", baseRouter.HandleAboutPage).Methods("GET")                                                     // About page handler
-	router.HandleFunc("/api/v1/auth/register", userRouter.HandleRegister)                                                             // POST
-	router.HandleFunc("/api/v1/auth/login", userRouter.HandleLogin).Methods("POST")                                                   // POST
-	router.Handle("/api/v1/users", middleware.JWTAuthMiddleware(http.HandlerFunc(userRouter.HandleUsers)))                            // GET, PUT, DELETE
-	router.Handle("/api/v1/users/todos/{todo_id}", middleware.JWTAuthMiddleware(http.HandlerFunc(todoRouter.HandleTodos)))            // GET, PUT, DELETE
-	router.Handle("/api/v1/users/todos", middleware.JWTAuthMiddleware(http.HandlerFunc(todoRouter.HandleGetTodos))).Methods("GET")    // GET
-	router.Handle("/api/v1/users/todos", middleware.JWTAuthMiddleware(http.HandlerFunc(todoRouter.HandleCreateTodo))).Methods("POST") // POST
-	return middleware.LogginMiddleware(router)
+	baseRouter := NewBaseRouter()      // Base Handler
+	userRouter := NewUserRouter(cfg)   // Users Handler
+	todoRouter := NewTodoRouter(cfg)   // Todos Handler
+	tokenRouter := NewTokenRouter()    // Personal access tokens Handler
+	adminRouter := NewAdminRouter(cfg) // Admin Handler
+	oauthRouter := NewOAuthRouter(cfg) // OAuth authorization server Handler
+	healthRouter := NewHealthRouter()  // Probes and build information Handler
+	access := newRouteAccess(cfg)      // Rate limits and authentication of the routes
+
+	adminRoles := []string{auth.RoleAdmin}
+	supportRoles := []string{auth.RoleAdmin, auth.RoleSupport}
+
+	// scopes personal access tokens and OAuth tokens need on each method of the multi-method routes
+	userScopes := map[string]string{
+		http.MethodGet: auth.ScopeProfileRead,
+		http.MethodPut: auth.ScopeProfileWrite,
+	}
+	todoScopes := map[string]string{
+		http.MethodGet:    auth.ScopeTodosRead,
+		http.MethodPut:    auth.ScopeTodosWrite,
+		http.MethodDelete: auth.ScopeTodosWrite,
+	}
+
+	// Define handlers, request bodies are only logged on routes wrapped with middleware.LogRequestBody
+	router.Handle("/", access.public(baseRouter.HomeHandler)).Methods("GET")                                                                               // root handler
+	router.Handle("/api/v1/about", access.public(baseRouter.HandleAboutPage)).Methods("GET")                                                               // About page handler
+	router.HandleFunc("/healthz", healthRouter.HandleHealth).Methods("GET")                                                                                // Liveness probe
+	router.HandleFunc("/readyz", healthRouter.HandleReady).Methods("GET")                                                                                  // Readiness probe
+	router.Handle("/api/v1/version", access.public(healthRouter.HandleVersion)).Methods("GET")                                                             // Build information
+	router.Handle("/api/v1/auth/register", access.public(userRouter.HandleRegister))                                                                       // POST
+	router.Handle("/api/v1/auth/login", access.public(userRouter.HandleLogin)).Methods("POST")                                                             // POST
+	router.Handle("/api/v1/auth/login/mfa", access.public(userRouter.HandleLoginMFA)).Methods("POST")                                                      // POST
+	router.Handle("/api/v1/auth/password-reset/confirm", access.public(userRouter.HandleConfirmPasswordReset)).Methods("POST")                             // POST
+	router.Handle("/api/v1/auth/verify-email", access.public(userRouter.HandleVerifyEmail)).Methods("GET")                                                 // GET
+	router.Handle("/api/v1/auth/oidc/login", access.public(userRouter.HandleOIDCLogin)).Methods("GET")                                                     // GET
+	router.Handle("/api/v1/auth/oidc/callback", access.public(userRouter.HandleOIDCCallback)).Methods("GET")                                               // GET
+	router.Handle("/api/v1/users", access.protected(userScopes, userRouter.HandleUsers))                                                                   // GET, PUT, DELETE
+	router.Handle("/api/v1/users/export", access.session(userRouter.HandleExportUser)).Methods("GET")                                                      // GET
+	router.Handle("/api/v1/users/username", access.session(userRouter.HandleChangeUsername)).Methods("POST")                                               // POST
+	router.Handle("/api/v1/users/password", access.session(userRouter.HandleChangePassword)).Methods("POST")                                               // POST
+	router.Handle("/api/v1/users/2fa/totp", access.session(userRouter.HandleEnrollTOTP)).Methods("POST")                                                   // POST
+	router.Handle("/api/v1/users/2fa/totp", access.session(userRouter.HandleDisableTOTP)).Methods("DELETE")                                                // DELETE
+	router.Handle("/api/v1/users/2fa/totp/confirm", access.session(userRouter.HandleConfirmTOTP)).Methods("POST")                                          // POST
+	router.Handle("/api/v1/users/identities", access.session(userRouter.HandleGetIdentities)).Methods("GET")                                               // GET
+	router.Handle("/api/v1/users/identities/oidc", access.session(userRouter.HandleLinkOIDC)).Methods("POST")                                              // POST
+	router.Handle("/api/v1/users/tokens", access.session(tokenRouter.HandleCreateToken)).Methods("POST")                                                   // POST
+	router.Handle("/api/v1/users/tokens", access.session(tokenRouter.HandleGetTokens)).Methods("GET")                                                      // GET
+	router.Handle("/api/v1/users/tokens/{token_id}", access.session(tokenRouter.HandleDeleteToken)).Methods("DELETE")                                      // DELETE
+	router.Handle("/api/v1/users/todos/{todo_id}", middleware.LogRequestBody(access.protected(todoScopes, todoRouter.HandleTodos)))                        // GET, PUT, DELETE
+	router.Handle("/api/v1/users/todos", access.scoped(auth.ScopeTodosRead, todoRouter.HandleGetTodos)).Methods("GET")                                     // GET
+	router.Handle("/api/v1/users/todos", middleware.LogRequestBody(access.scoped(auth.ScopeTodosWrite, todoRouter.HandleCreateTodo))).Methods("POST")      // POST
+	router.Handle("/api/v1/oauth/authorize", access.public(oauthRouter.HandleAuthorize)).Methods("GET")                                                    // GET
+	router.Handle("/api/v1/oauth/authorize", access.public(oauthRouter.HandleAuthorizeDecision)).Methods("POST")                                           // POST
+	router.Handle("/api/v1/oauth/token", access.public(oauthRouter.HandleToken)).Methods("POST")                                                           // POST
+	router.Handle("/api/v1/oauth/introspect", access.public(oauthRouter.HandleIntrospect)).Methods("POST")                                                 // POST
+	router.Handle("/api/v1/oauth/revoke", access.public(oauthRouter.HandleRevoke)).Methods("POST")                                                         // POST
+	router.Handle("/api/v1/oauth/clients", access.session(oauthRouter.HandleRegisterClient)).Methods("POST")                                               // POST
+	router.Handle("/api/v1/oauth/clients", access.session(oauthRouter.HandleGetClients)).Methods("GET")                                                    // GET
+	router.Handle("/api/v1/oauth/clients/{client_id}", access.session(oauthRouter.HandleDeleteClient)).Methods("DELETE")                                   // DELETE
+	router.Handle("/api/v1/admin/users", access.staff(supportRoles, adminRouter.HandleListUsers)).Methods("GET")                                           // GET
+	router.Handle("/api/v1/admin/users/{username}", access.staff(supportRoles, adminRouter.HandleGetUser)).Methods("GET")                                  // GET
+	router.Handle("/api/v1/admin/users/{username}/todos", access.staff(supportRoles, adminRouter.HandleGetUserTodos)).Methods("GET")                       // GET
+	router.Handle("/api/v1/admin/users/{username}/role", middleware.LogRequestBody(access.staff(adminRoles, adminRouter.HandleUpdateRole))).Methods("PUT") // PUT
+	router.Handle("/api/v1/admin/users/{username}/disable", access.staff(adminRoles, adminRouter.HandleDisableUser)).Methods("POST")                       // POST
+	router.Handle("/api/v1/admin/users/{username}/enable", access.staff(adminRoles, adminRouter.HandleEnableUser)).Methods("POST")                         // POST
+	router.Handle("/api/v1/admin/users/{username}/password-reset", access.staff(adminRoles, adminRouter.HandleForcePasswordReset)).Methods("POST")         // POST
+	router.Handle("/api/v1/admin/users/{username}/unlock", access.staff(adminRoles, adminRouter.HandleUnlockUser)).Methods("POST")                         // POST
+	router.Handle("/api/v1/admin/audit", access.staff(adminRoles, adminRouter.HandleGetAuditLog)).Methods("GET")                                           // GET
+
+	// Prometheus metrics, served with the API unless they have a listener of their own
+	if cfg.Metrics.Addr == "" {
+		router.Handle("/metrics", MetricsHandler(cfg)).Methods("GET")
+	}
+
+	// unmatched routes answer with the same error body as the handlers
+	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		middleware.Error(w, req, "page not found", http.StatusNotFound)
+	})
+	router.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		middleware.Error(w, req, "method not allowed", http.StatusMethodNotAllowed)
+	})
+
+	// the probes and the routes sending emails or calling the identity provider do not hold the store
+	router.Use(middleware.TraceRoute, middleware.ShareStore(
+		"/healthz",
+		"/readyz",
+		"/api/v1/auth/register",
+		"/api/v1/auth/oidc/login",
+		"/api/v1/auth/oidc/callback",
+		"/api/v1/users",
+		"/api/v1/users/identities/oidc",
+		"/api/v1/admin/users/{username}/password-reset",
+	))
+
+	// CORS answers preflights itself, before the auth on the routes asks for a token
+	cors := middleware.CORSConfig{
+		AllowedOrigins:   cfg.CORS.AllowedOrigins,
+		AllowedMethods:   cfg.CORS.AllowedMethods,
+		AllowedHeaders:   cfg.CORS.AllowedHeaders,
+		ExposedHeaders:   []string{middleware.RequestIDHeader, "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy", "Retry-After"},
+		AllowCredentials: cfg.CORS.AllowCredentials,
+		MaxAge:           cfg.CORS.MaxAge,
+	}
+	handler := middleware.CORSMiddleware(cors, router, middleware.MetricsMiddleware(router))
+
+	return middleware.RequestIDMiddleware(middleware.TracingMiddleware(middleware.LoggingMiddleware(middleware.RecoverMiddleware(router, middleware.CompressMiddleware(handler)))))
+}
+
+// serves the Prometheus metrics, only to scrapers sending the metrics bearer token when one is set
+func MetricsHandler(cfg config.Config) http.Handler {
+	return middleware.RequireBearerToken(cfg.Metrics.BearerToken, metrics.Handler())
+}
+
+// starts the span of a handler, the returned request carries it on to storage calls
+func traceHandler(req *http.Request, name string) (*http.Request, trace.Span) {
+	ctx, span := tracing.Start(req.Context(), name)
+	return req.WithContext(ctx), span
+}
+
+// routeAccess wraps the handlers of the routes in their rate limits and
+// authentication. Buckets are kept in memory, a ratelimit.Store backed by a
+// shared database would apply the limits across instances
+type routeAccess struct {
+	store  ratelimit.Store
+	limits map[string]ratelimit.Limit
+}
+
+func newRouteAccess(cfg config.Config) routeAccess {
+	limits := cfg.RateLimit.Limits()
+	for key, text := range map[string]string{middleware.DefaultRateLimit: cfg.RateLimit.Default, middleware.IPRateLimit: cfg.RateLimit.IP} {
+		if limit, err := ratelimit.ParseLimit(text); err == nil {
+			limits[key] = limit
+		}
+	}
+	return routeAccess{store: ratelimit.NewMemoryStore(), limits: limits}
+}
+
+// limits requests per user once signed in, per IP address otherwise
+func (a routeAccess) rateLimited(next http.Handler) http.Handler {
+	return middleware.RateLimit(a.store, a.limits, next)
+}
+
+// checks the token of a request, after a limit per IP address so bad or missing
+// tokens are throttled too, then limits the user it belongs to
+func (a routeAccess) authenticated(next http.Handler) http.Handler {
+	return middleware.RateLimitIP(a.store, a.limits, middleware.JWTAuthMiddleware(a.rateLimited(next)))
+}
+
+// open to anyone, limited per IP address
+func (a routeAccess) public(handler http.HandlerFunc) http.Handler {
+	return a.rateLimited(handler)
+}
+
+// requires a JWT, or a scoped token holding the scope for the request method
+func (a routeAccess) protected(scopes map[string]string, handler http.HandlerFunc) http.Handler {
+	return a.authenticated(middleware.RequireMethodScopes(scopes, handler))
+}
+
+// requires a JWT, or a scoped token holding scope
+func (a routeAccess) scoped(scope string, handler http.HandlerFunc) http.Handler {
+	return a.authenticated(middleware.RequireScope(scope, handler))
+}
+
+// requires a JWT, personal access tokens and OAuth tokens are rejected
+func (a routeAccess) session(handler http.HandlerFunc) http.Handler {
+	return a.authenticated(middleware.RequireSession(handler))
+}
+
+// requires a JWT from a user holding one of roles
+func (a routeAccess) staff(roles []string, handler http.HandlerFunc) http.Handler {
+	return a.authenticated(middleware.RequireSession(middleware.RequireRole(roles, handler)))
 }
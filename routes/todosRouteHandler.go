@@ -2,19 +2,22 @@ package routes
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
 	"strconv"
 
 	"github.com/gorilla/mux"
 
+	"github.com/johnson-oragui/golang-todo-api/config"
+	"github.com/johnson-oragui/golang-todo-api/middleware"
 	"github.com/johnson-oragui/golang-todo-api/schema"
 )
 
-type TodoRouter struct{}
+type TodoRouter struct {
+	requireVerifiedEmail bool // blocks todo creation until the email address is verified
+}
 
-func NewTodoRouter() *TodoRouter {
-	return &TodoRouter{}
+func NewTodoRouter(cfg config.Config) *TodoRouter {
+	return &TodoRouter{requireVerifiedEmail: cfg.Auth.RequireVerifiedEmail}
 }
 
 func (r *TodoRouter) HandleTodos(w http.ResponseWriter, req *http.Request) {
@@ -26,18 +29,21 @@ func (r *TodoRouter) HandleTodos(w http.ResponseWriter, req *http.Request) {
 	case http.MethodDelete:
 		r.HandledeleteTodo(w, req)
 	default:
-		log.Println("Method not allowed")
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		middleware.Println(req, "Method not allowed")
+		middleware.Error(w, req, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 }
 
 // Creates Todo POST /api/v1/users/{username}/todos
 func (r *TodoRouter) HandleCreateTodo(w http.ResponseWriter, req *http.Request) {
+	req, span := traceHandler(req, "TodoRouter.HandleCreateTodo")
+	defer span.End()
+
 	// check for content-type
 	if contentType := req.Header.Get("Content-Type"); contentType != "application/json" {
-		log.Println("Content-type must be application/json")
-		http.Error(w, "Wrong content-type", http.StatusUnsupportedMediaType)
+		middleware.Println(req, "Content-type must be application/json")
+		middleware.Error(w, req, "Wrong content-type", http.StatusUnsupportedMediaType)
 		return
 	}
 
@@ -45,22 +51,28 @@ func (r *TodoRouter) HandleCreateTodo(w http.ResponseWriter, req *http.Request)
 
 	username, ok := req.Context().Value("username").(string)
 	if !ok {
-		log.Println("User not authenticated")
-		http.Error(w, "User not authenticated", http.StatusBadRequest)
+		middleware.Println(req, "User not authenticated")
+		middleware.Error(w, req, "User not authenticated", http.StatusBadRequest)
 		return
 	}
 
 	if username == "" {
-		log.Println("username is not passed")
-		http.Error(w, "username is not passed", http.StatusBadRequest)
+		middleware.Println(req, "username is not passed")
+		middleware.Error(w, req, "username is not passed", http.StatusBadRequest)
 		return
 	}
 
 	// check if user exists in the users database
-	_, exists := schema.Database.Users[username]
+	user, exists := schema.Database.Users[username]
 	if !exists {
-		log.Println("user does not exists in the database", username)
-		http.Error(w, "user does not exists in the database", http.StatusForbidden)
+		middleware.Println(req, "user does not exists in the database", username)
+		middleware.Error(w, req, "user does not exists in the database", http.StatusForbidden)
+		return
+	}
+
+	if r.requireVerifiedEmail && !user.EmailVerified {
+		middleware.Printf(req, "user %v has not verified their email", username)
+		middleware.Error(w, req, "email address must be verified before creating todos", http.StatusForbidden)
 		return
 	}
 
@@ -69,8 +81,8 @@ func (r *TodoRouter) HandleCreateTodo(w http.ResponseWriter, req *http.Request)
 
 	// save the request body to the nill struct
 	if err := json.NewDecoder(req.Body).Decode(&todoInput); err != nil {
-		log.Println("Error decoding json", todoInput)
-		http.Error(w, "Invalid JSON", http.StatusUnsupportedMediaType)
+		middleware.Println(req, "Error decoding json", todoInput)
+		middleware.Error(w, req, "Invalid JSON", http.StatusUnsupportedMediaType)
 		return
 	}
 
@@ -78,7 +90,7 @@ func (r *TodoRouter) HandleCreateTodo(w http.ResponseWriter, req *http.Request)
 	defer req.Body.Close()
 
 	// check if user has a todo entry
-	userTodos, exists := schema.TodosDataBase.User[username]
+	userTodos, exists := schema.FindTodos(req.Context(), username)
 	if !exists {
 		// create an empty entry if user has no todo entry
 		userTodos = schema.Todos{}
@@ -89,7 +101,7 @@ func (r *TodoRouter) HandleCreateTodo(w http.ResponseWriter, req *http.Request)
 	userTodos.AllTodos = append(userTodos.AllTodos, todoInput)
 
 	// save the list to the database
-	schema.TodosDataBase.User[username] = userTodos
+	schema.SaveTodos(req.Context(), username, userTodos)
 
 	// create a response payload
 	response := schema.TodoResponse{
@@ -100,29 +112,24 @@ func (r *TodoRouter) HandleCreateTodo(w http.ResponseWriter, req *http.Request)
 		Data: todoInput,
 	}
 
-	w.Header().Add("Content-Type", "applicaton/json")
-	// return the payload
-	w.WriteHeader(http.StatusCreated)
-
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Println("Error encoding json")
-		http.Error(w, "Could not encode JSON", http.StatusInternalServerError)
-	}
+	middleware.Respond(w, req, http.StatusCreated, response)
 }
 
 // Fetch all Todos GET /api/v1/users/{username}/todos
 func (r *TodoRouter) HandleGetTodos(w http.ResponseWriter, req *http.Request) {
+	req, span := traceHandler(req, "TodoRouter.HandleGetTodos")
+	defer span.End()
 
 	username, ok := req.Context().Value("username").(string)
 	if !ok {
-		log.Println("User not authenticated")
-		http.Error(w, "User not authenticated", http.StatusBadRequest)
+		middleware.Println(req, "User not authenticated")
+		middleware.Error(w, req, "User not authenticated", http.StatusBadRequest)
 		return
 	}
 
 	if username == "" {
-		log.Println("username is not passed")
-		http.Error(w, "username is not passed", http.StatusBadRequest)
+		middleware.Println(req, "username is not passed")
+		middleware.Error(w, req, "username is not passed", http.StatusBadRequest)
 		return
 	}
 
@@ -130,15 +137,15 @@ func (r *TodoRouter) HandleGetTodos(w http.ResponseWriter, req *http.Request) {
 	_, exists := schema.Database.Users[username]
 
 	if !exists {
-		log.Printf("username %v does not exist", username)
-		http.Error(w, "username does not exist", http.StatusBadRequest)
+		middleware.Printf(req, "username %v does not exist", username)
+		middleware.Error(w, req, "username does not exist", http.StatusBadRequest)
 		return
 	}
 
-	todos, exists := schema.TodosDataBase.User[username]
+	todos, exists := schema.FindTodos(req.Context(), username)
 	if !exists {
-		log.Printf("username %v does not have a todo entry yet", username)
-		http.Error(w, "user does not have a todo entry yet", http.StatusBadRequest)
+		middleware.Printf(req, "username %v does not have a todo entry yet", username)
+		middleware.Error(w, req, "user does not have a todo entry yet", http.StatusBadRequest)
 		return
 	}
 
@@ -150,21 +157,18 @@ func (r *TodoRouter) HandleGetTodos(w http.ResponseWriter, req *http.Request) {
 		Data: todos.AllTodos,
 	}
 
-	w.Header().Add("Content-Type", "applicaton/json")
-	w.WriteHeader(http.StatusOK)
-
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Println("error encoding JSON")
-		http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
-	}
+	middleware.Respond(w, req, http.StatusOK, response)
 }
 
 // fetch a single Todo GET /api/v1/users/{username}/todos/{todo_id}
 func (r *TodoRouter) HandleGetTodo(w http.ResponseWriter, req *http.Request) {
+	req, span := traceHandler(req, "TodoRouter.HandleGetTodo")
+	defer span.End()
+
 	username, ok := req.Context().Value("username").(string)
 	if !ok {
-		log.Println("User not authenticated")
-		http.Error(w, "User not authenticated", http.StatusBadRequest)
+		middleware.Println(req, "User not authenticated")
+		middleware.Error(w, req, "User not authenticated", http.StatusBadRequest)
 		return
 	}
 
@@ -172,8 +176,8 @@ func (r *TodoRouter) HandleGetTodo(w http.ResponseWriter, req *http.Request) {
 	todoIdStr := vars["todo_id"]
 
 	if todoIdStr == "" {
-		log.Println("todo_id is not passed")
-		http.Error(w, "todo_id is not passed", http.StatusBadRequest)
+		middleware.Println(req, "todo_id is not passed")
+		middleware.Error(w, req, "todo_id is not passed", http.StatusBadRequest)
 		return
 	}
 
@@ -181,22 +185,23 @@ func (r *TodoRouter) HandleGetTodo(w http.ResponseWriter, req *http.Request) {
 	_, exists := schema.Database.Users[username]
 
 	if !exists {
-		log.Printf("username %v does not exist", username)
-		http.Error(w, "username does not exist", http.StatusBadRequest)
+		middleware.Printf(req, "username %v does not exist", username)
+		middleware.Error(w, req, "username does not exist", http.StatusBadRequest)
 		return
 	}
 
-	todos := schema.TodosDataBase.User[username].AllTodos
+	userTodos, _ := schema.FindTodos(req.Context(), username)
+	todos := userTodos.AllTodos
 	if !exists {
-		log.Printf("username %v does not exist", username)
-		http.Error(w, "user does not have a todo entry yet", http.StatusBadRequest)
+		middleware.Printf(req, "username %v does not exist", username)
+		middleware.Error(w, req, "user does not have a todo entry yet", http.StatusBadRequest)
 		return
 	}
 
 	todoId, err := strconv.Atoi(todoIdStr)
 	if err != nil {
-		log.Println("Invalid todo_id, must be an integer")
-		http.Error(w, "Invalid todo_id", http.StatusBadRequest)
+		middleware.Println(req, "Invalid todo_id, must be an integer")
+		middleware.Error(w, req, "Invalid todo_id", http.StatusBadRequest)
 		return
 	}
 
@@ -212,8 +217,8 @@ func (r *TodoRouter) HandleGetTodo(w http.ResponseWriter, req *http.Request) {
 	}
 
 	if thatTodo.ID == 0 && thatTodo.Todo == "" && !thatTodo.Completed {
-		log.Println("todo not found")
-		http.Error(w, "todo not found", http.StatusNotFound)
+		middleware.Println(req, "todo not found")
+		middleware.Error(w, req, "todo not found", http.StatusNotFound)
 		return
 	}
 
@@ -225,35 +230,32 @@ func (r *TodoRouter) HandleGetTodo(w http.ResponseWriter, req *http.Request) {
 		Data: thatTodo,
 	}
 
-	w.Header().Add("Content-Type", "applicaton/json")
-	w.WriteHeader(http.StatusOK)
-
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Println("error encoding JSON")
-		http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
-	}
+	middleware.Respond(w, req, http.StatusOK, response)
 }
 
 // update a Todo PUT /api/v1/users/{username}/todos/{todo_id}
 func (r *TodoRouter) HandleUpdateTodo(w http.ResponseWriter, req *http.Request) {
+	req, span := traceHandler(req, "TodoRouter.HandleUpdateTodo")
+	defer span.End()
+
 	if req.Header.Get("Content-Type") != "application/json" {
-		log.Println("Content-type must be application/json")
-		http.Error(w, "Wrong content-type", http.StatusUnsupportedMediaType)
+		middleware.Println(req, "Content-type must be application/json")
+		middleware.Error(w, req, "Wrong content-type", http.StatusUnsupportedMediaType)
 		return
 	}
 
 	username, ok := req.Context().Value("username").(string)
 	if !ok {
-		log.Println("User not authenticated")
-		http.Error(w, "User not authenticated", http.StatusBadRequest)
+		middleware.Println(req, "User not authenticated")
+		middleware.Error(w, req, "User not authenticated", http.StatusBadRequest)
 		return
 	}
 
 	idStr := mux.Vars(req)["todo_id"]
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		log.Println("todo-id cannote be converted to an integer")
-		http.Error(w, "todo-id is not an integer", http.StatusBadRequest)
+		middleware.Println(req, "todo-id cannote be converted to an integer")
+		middleware.Error(w, req, "todo-id is not an integer", http.StatusBadRequest)
 		return
 	}
 
@@ -261,16 +263,16 @@ func (r *TodoRouter) HandleUpdateTodo(w http.ResponseWriter, req *http.Request)
 	todoInput := schema.TodoSchema{}
 
 	if err := json.NewDecoder(req.Body).Decode(&todoInput); err != nil {
-		log.Println("invalid JSON", err)
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		middleware.Println(req, "invalid JSON", err)
+		middleware.Error(w, req, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
-	userTodos, exists := schema.TodosDataBase.User[username]
+	userTodos, exists := schema.FindTodos(req.Context(), username)
 
 	if !exists {
-		log.Println("user does not have a todo entry yet")
-		http.Error(w, "user does not have a todo entry yet", http.StatusBadRequest)
+		middleware.Println(req, "user does not have a todo entry yet")
+		middleware.Error(w, req, "user does not have a todo entry yet", http.StatusBadRequest)
 		return
 	}
 
@@ -290,12 +292,12 @@ func (r *TodoRouter) HandleUpdateTodo(w http.ResponseWriter, req *http.Request)
 	}
 
 	if !updated {
-		log.Println("Todo not found")
-		http.Error(w, "Todo not found", http.StatusNotFound)
+		middleware.Println(req, "Todo not found")
+		middleware.Error(w, req, "Todo not found", http.StatusNotFound)
 		return
 	}
 
-	schema.TodosDataBase.User[username] = userTodos
+	schema.SaveTodos(req.Context(), username, userTodos)
 
 	response := schema.TodoResponse{
 		Response: schema.Response{
@@ -305,21 +307,18 @@ func (r *TodoRouter) HandleUpdateTodo(w http.ResponseWriter, req *http.Request)
 		Data: userTodos.AllTodos,
 	}
 
-	w.Header().Add("Content-Type", "applicaton/json")
-	w.WriteHeader(http.StatusCreated)
-
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Println("error encoding JSON")
-		http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
-	}
+	middleware.Respond(w, req, http.StatusCreated, response)
 }
 
 // delete a single Todo DELETE /api/v1/users/{username}/todos/{todo_id}
 func (r *TodoRouter) HandledeleteTodo(w http.ResponseWriter, req *http.Request) {
+	req, span := traceHandler(req, "TodoRouter.HandledeleteTodo")
+	defer span.End()
+
 	username, ok := req.Context().Value("username").(string)
 	if !ok {
-		log.Println("User not authenticated")
-		http.Error(w, "User not authenticated", http.StatusBadRequest)
+		middleware.Println(req, "User not authenticated")
+		middleware.Error(w, req, "User not authenticated", http.StatusBadRequest)
 		return
 	}
 	vars := mux.Vars(req)
@@ -327,23 +326,23 @@ func (r *TodoRouter) HandledeleteTodo(w http.ResponseWriter, req *http.Request)
 	todoIdStr := vars["todo_id"]
 
 	if todoIdStr == "" {
-		log.Println("todo_id not provided")
-		http.Error(w, "todo_id not provided", http.StatusBadRequest)
+		middleware.Println(req, "todo_id not provided")
+		middleware.Error(w, req, "todo_id not provided", http.StatusBadRequest)
 		return
 	}
 
 	todoId, err := strconv.Atoi(todoIdStr)
 	if err != nil {
-		log.Println("Invalid todo_id, must be an integer")
-		http.Error(w, "Invalid todo_id", http.StatusBadRequest)
+		middleware.Println(req, "Invalid todo_id, must be an integer")
+		middleware.Error(w, req, "Invalid todo_id", http.StatusBadRequest)
 		return
 	}
 
-	userTodos, exists := schema.TodosDataBase.User[username]
+	userTodos, exists := schema.FindTodos(req.Context(), username)
 
 	if !exists {
-		log.Println("username does not exist")
-		http.Error(w, "username does not exist", http.StatusBadRequest)
+		middleware.Println(req, "username does not exist")
+		middleware.Error(w, req, "username does not exist", http.StatusBadRequest)
 		return
 	}
 
@@ -355,9 +354,8 @@ func (r *TodoRouter) HandledeleteTodo(w http.ResponseWriter, req *http.Request)
 
 	}
 
-	schema.TodosDataBase.User[username] = userTodos
+	schema.SaveTodos(req.Context(), username, userTodos)
 
-	w.Header().Add("Content-Type", "applicaton/json")
 	w.WriteHeader(http.StatusAccepted)
 
 }
@@ -0,0 +1,200 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/johnson-oragui/golang-todo-api/auth"
+	"github.com/johnson-oragui/golang-todo-api/middleware"
+	"github.com/johnson-oragui/golang-todo-api/schema"
+)
+
+type TokenRouter struct{}
+
+func NewTokenRouter() *TokenRouter {
+	return &TokenRouter{}
+}
+
+// revokes the user's login sessions, personal access tokens and tokens issued to OAuth clients
+func revokeUserTokens(username string) {
+	auth.RevokeTokens(username)
+
+	for hash, pat := range schema.TokensDataBase.Tokens {
+		if pat.Username == username {
+			schema.DeleteToken(hash)
+		}
+	}
+
+	for hash, accessToken := range schema.OAuthStore.Tokens {
+		if accessToken.Username == username {
+			delete(schema.OAuthStore.Tokens, hash)
+		}
+	}
+}
+
+// create personal access token POST /api/v1/users/tokens
+func (r *TokenRouter) HandleCreateToken(w http.ResponseWriter, req *http.Request) {
+	req, span := traceHandler(req, "TokenRouter.HandleCreateToken")
+	defer span.End()
+
+	if req.Header.Get("Content-Type") != "application/json" {
+		middleware.Error(w, req, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	username, ok := req.Context().Value("username").(string)
+	if !ok {
+		middleware.Println(req, "User not authenticated")
+		middleware.Error(w, req, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	input := schema.PersonalAccessTokenInput{}
+	if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+		middleware.Printf(req, "error decoding request body: %v", err)
+		middleware.Error(w, req, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	defer req.Body.Close()
+
+	if _, exists := schema.Database.Users[username]; !exists {
+		middleware.Printf(req, "username %s does not exists in the database", username)
+		middleware.Error(w, req, "User does not exists", http.StatusForbidden)
+		return
+	}
+
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" {
+		middleware.Error(w, req, "token name is required", http.StatusBadRequest)
+		return
+	}
+
+	if len(input.Scopes) == 0 {
+		middleware.Error(w, req, fmt.Sprintf("at least one scope is required, allowed scopes: %v", auth.Scopes), http.StatusBadRequest)
+		return
+	}
+	for _, scope := range input.Scopes {
+		if !auth.IsValidScope(scope) {
+			middleware.Printf(req, "invalid scope %v", scope)
+			middleware.Error(w, req, fmt.Sprintf("invalid scope '%v', allowed scopes: %v", scope, auth.Scopes), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if input.ExpiresInDays < 0 {
+		middleware.Error(w, req, "expires_in_days cannot be negative", http.StatusBadRequest)
+		return
+	}
+
+	token, err := auth.GeneratePersonalAccessToken()
+	if err != nil {
+		middleware.Printf(req, "could not generate token: %v", err)
+		middleware.Error(w, req, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	schema.TokensDataBase.LastID++
+	pat := schema.PersonalAccessToken{
+		ID:        schema.TokensDataBase.LastID,
+		Name:      input.Name,
+		Username:  username,
+		Hash:      auth.HashToken(token),
+		Prefix:    token[:len(auth.PersonalAccessTokenPrefix)+4],
+		Scopes:    input.Scopes,
+		CreatedAt: time.Now(),
+	}
+	if input.ExpiresInDays > 0 {
+		expiresAt := pat.CreatedAt.AddDate(0, 0, input.ExpiresInDays)
+		pat.ExpiresAt = &expiresAt
+	}
+
+	// only the hash is kept, the token itself is returned once
+	schema.TokensDataBase.Tokens[pat.Hash] = pat
+
+	response := schema.TodoResponse{
+		Response: schema.Response{
+			Message:    "Token created successfully, copy it now as it will not be shown again",
+			StatusCode: 201,
+		},
+		Data: map[string]any{
+			"token":   token,
+			"details": pat,
+		},
+	}
+
+	middleware.Respond(w, req, http.StatusCreated, response)
+}
+
+// list personal access tokens GET /api/v1/users/tokens
+func (r *TokenRouter) HandleGetTokens(w http.ResponseWriter, req *http.Request) {
+	req, span := traceHandler(req, "TokenRouter.HandleGetTokens")
+	defer span.End()
+
+	username, ok := req.Context().Value("username").(string)
+	if !ok {
+		middleware.Println(req, "User not authenticated")
+		middleware.Error(w, req, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	tokens := []schema.PersonalAccessToken{}
+	for _, pat := range schema.TokensDataBase.Tokens {
+		if pat.Username == username {
+			tokens = append(tokens, schema.WithLastUsed(pat))
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID < tokens[j].ID })
+
+	response := schema.TodoResponse{
+		Response: schema.Response{
+			Message:    "Tokens retrieved successfully",
+			StatusCode: 200,
+		},
+		Data: tokens,
+	}
+
+	middleware.Respond(w, req, http.StatusOK, response)
+}
+
+// revoke a personal access token DELETE /api/v1/users/tokens/{token_id}
+func (r *TokenRouter) HandleDeleteToken(w http.ResponseWriter, req *http.Request) {
+	req, span := traceHandler(req, "TokenRouter.HandleDeleteToken")
+	defer span.End()
+
+	username, ok := req.Context().Value("username").(string)
+	if !ok {
+		middleware.Println(req, "User not authenticated")
+		middleware.Error(w, req, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	tokenId, err := strconv.Atoi(mux.Vars(req)["token_id"])
+	if err != nil {
+		middleware.Println(req, "Invalid token_id, must be an integer")
+		middleware.Error(w, req, "Invalid token_id", http.StatusBadRequest)
+		return
+	}
+
+	for hash, pat := range schema.TokensDataBase.Tokens {
+		if pat.ID == tokenId && pat.Username == username {
+			schema.DeleteToken(hash)
+
+			response := schema.Response{
+				Message:    "Token revoked successfully",
+				StatusCode: 200,
+			}
+			middleware.Respond(w, req, http.StatusOK, response)
+			return
+		}
+	}
+
+	middleware.Println(req, "token not found")
+	middleware.Error(w, req, "token not found", http.StatusNotFound)
+}
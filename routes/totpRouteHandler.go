@@ -0,0 +1,329 @@
+package routes
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+
+	"github.com/johnson-oragui/golang-todo-api/auth"
+	"github.com/johnson-oragui/golang-todo-api/metrics"
+	"github.com/johnson-oragui/golang-todo-api/middleware"
+	"github.com/johnson-oragui/golang-todo-api/schema"
+)
+
+// checks a TOTP or recovery code for the user, consuming it on success.
+// The caller is responsible for saving the updated user.
+func verifySecondFactor(user *schema.UserBase, code, recoveryCode string) bool {
+	if code != "" {
+		step, ok := auth.ValidateTOTP(user.TOTPSecret, code, time.Now())
+		if !ok || step <= user.TOTPLastUsedStep {
+			return false
+		}
+		user.TOTPLastUsedStep = step
+		return true
+	}
+
+	if recoveryCode != "" {
+		hash := auth.HashToken(auth.NormalizeRecoveryCode(recoveryCode))
+		idx := slices.Index(user.RecoveryCodes, hash)
+		if idx == -1 {
+			return false
+		}
+		user.RecoveryCodes = slices.Delete(user.RecoveryCodes, idx, idx+1)
+		return true
+	}
+
+	return false
+}
+
+// start TOTP enrollment POST /api/v1/users/2fa/totp
+func (s *UserRouter) HandleEnrollTOTP(w http.ResponseWriter, req *http.Request) {
+	req, span := traceHandler(req, "UserRouter.HandleEnrollTOTP")
+	defer span.End()
+
+	username, ok := req.Context().Value("username").(string)
+	if !ok {
+		middleware.Println(req, "User not authenticated")
+		middleware.Error(w, req, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	user, exists := schema.Database.Users[username]
+	if !exists {
+		middleware.Printf(req, "username %s does not exists in the database", username)
+		middleware.Error(w, req, "User does not exists", http.StatusForbidden)
+		return
+	}
+
+	if user.TOTPEnabled {
+		middleware.Printf(req, "user %v already has 2FA enabled", username)
+		middleware.Error(w, req, "two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		middleware.Printf(req, "could not generate TOTP secret: %v", err)
+		middleware.Error(w, req, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	uri := auth.TOTPURI(s.totpIssuer, user.Username, secret)
+
+	qrPNG, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		middleware.Printf(req, "could not generate QR code: %v", err)
+		middleware.Error(w, req, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// the secret only becomes active once a code from it is confirmed
+	user.TOTPPendingSecret = secret
+	schema.Database.Users[username] = user
+
+	// the QR code alone when the client prefers the image, the usual formats otherwise
+	if middleware.Negotiate(req, middleware.MediaJSON, middleware.MediaMsgPack, middleware.MediaCBOR, "image/png") == "image/png" {
+		w.Header().Add("Vary", "Accept")
+		w.Header().Set("Content-Type", "image/png")
+		w.WriteHeader(http.StatusOK)
+		w.Write(qrPNG)
+		return
+	}
+
+	response := schema.TodoResponse{
+		Response: schema.Response{
+			Message:    "Scan the QR code and confirm with a code from your authenticator app",
+			StatusCode: 200,
+		},
+		Data: map[string]string{
+			"secret":      secret,
+			"otpauth_uri": uri,
+			"qr_png":      base64.StdEncoding.EncodeToString(qrPNG),
+		},
+	}
+
+	middleware.Respond(w, req, http.StatusOK, response)
+}
+
+// confirm TOTP enrollment POST /api/v1/users/2fa/totp/confirm
+func (s *UserRouter) HandleConfirmTOTP(w http.ResponseWriter, req *http.Request) {
+	req, span := traceHandler(req, "UserRouter.HandleConfirmTOTP")
+	defer span.End()
+
+	if req.Header.Get("Content-Type") != "application/json" {
+		middleware.Error(w, req, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	username, ok := req.Context().Value("username").(string)
+	if !ok {
+		middleware.Println(req, "User not authenticated")
+		middleware.Error(w, req, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	input := schema.TOTPCodeInput{}
+	if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+		middleware.Printf(req, "error decoding request body: %v", err)
+		middleware.Error(w, req, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	defer req.Body.Close()
+
+	user, exists := schema.Database.Users[username]
+	if !exists {
+		middleware.Printf(req, "username %s does not exists in the database", username)
+		middleware.Error(w, req, "User does not exists", http.StatusForbidden)
+		return
+	}
+
+	if user.TOTPPendingSecret == "" {
+		middleware.Printf(req, "user %v has no pending 2FA enrollment", username)
+		middleware.Error(w, req, "two-factor enrollment has not been started", http.StatusBadRequest)
+		return
+	}
+
+	step, valid := auth.ValidateTOTP(user.TOTPPendingSecret, input.Code, time.Now())
+	if !valid {
+		middleware.Printf(req, "invalid TOTP code during enrollment for %v", username)
+		middleware.Error(w, req, "invalid code", http.StatusBadRequest)
+		return
+	}
+
+	recoveryCodes, err := auth.GenerateRecoveryCodes(s.recoveryCodes)
+	if err != nil {
+		middleware.Printf(req, "could not generate recovery codes: %v", err)
+		middleware.Error(w, req, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	user.RecoveryCodes = nil
+	for _, code := range recoveryCodes {
+		user.RecoveryCodes = append(user.RecoveryCodes, auth.HashToken(code))
+	}
+	user.TOTPEnabled = true
+	user.TOTPSecret = user.TOTPPendingSecret
+	user.TOTPPendingSecret = ""
+	user.TOTPLastUsedStep = step
+	schema.Database.Users[username] = user
+
+	response := schema.TodoResponse{
+		Response: schema.Response{
+			Message:    "Two-factor authentication enabled, store the recovery codes somewhere safe",
+			StatusCode: 200,
+		},
+		Data: map[string][]string{
+			"recovery_codes": recoveryCodes,
+		},
+	}
+
+	middleware.Respond(w, req, http.StatusOK, response)
+}
+
+// disable TOTP DELETE /api/v1/users/2fa/totp
+func (s *UserRouter) HandleDisableTOTP(w http.ResponseWriter, req *http.Request) {
+	req, span := traceHandler(req, "UserRouter.HandleDisableTOTP")
+	defer span.End()
+
+	if req.Header.Get("Content-Type") != "application/json" {
+		middleware.Error(w, req, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	username, ok := req.Context().Value("username").(string)
+	if !ok {
+		middleware.Println(req, "User not authenticated")
+		middleware.Error(w, req, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	input := schema.TOTPCodeInput{}
+	if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+		middleware.Printf(req, "error decoding request body: %v", err)
+		middleware.Error(w, req, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	defer req.Body.Close()
+
+	user, exists := schema.Database.Users[username]
+	if !exists {
+		middleware.Printf(req, "username %s does not exists in the database", username)
+		middleware.Error(w, req, "User does not exists", http.StatusForbidden)
+		return
+	}
+
+	if !user.TOTPEnabled {
+		middleware.Printf(req, "user %v does not have 2FA enabled", username)
+		middleware.Error(w, req, "two-factor authentication is not enabled", http.StatusBadRequest)
+		return
+	}
+
+	// guesses with a stolen session count towards the same limits as logins
+	key := guardKey(username)
+	ip := middleware.ClientIP(req)
+	if loginBlocked(w, req, key, ip) {
+		middleware.Printf(req, "disabling 2FA for %v from %v is blocked after failed attempts", key, ip)
+		return
+	}
+
+	if !verifySecondFactor(&user, input.Code, input.RecoveryCode) {
+		middleware.Printf(req, "invalid second factor while disabling 2FA for %v", username)
+		recordLoginFailure(key, ip)
+		middleware.Error(w, req, "invalid code", http.StatusForbidden)
+		return
+	}
+	auth.UsernameLoginGuard.Reset(key)
+
+	user.TOTPEnabled = false
+	user.TOTPSecret = ""
+	user.TOTPLastUsedStep = 0
+	user.RecoveryCodes = nil
+	schema.Database.Users[username] = user
+
+	response := schema.Response{
+		Message:    "Two-factor authentication disabled",
+		StatusCode: 200,
+	}
+
+	middleware.Respond(w, req, http.StatusOK, response)
+}
+
+// complete a 2FA login POST /api/v1/auth/login/mfa
+func (s *UserRouter) HandleLoginMFA(w http.ResponseWriter, req *http.Request) {
+	req, span := traceHandler(req, "UserRouter.HandleLoginMFA")
+	defer span.End()
+
+	if req.Header.Get("Content-Type") != "application/json" {
+		middleware.Println(req, "content-type must be application/json")
+		middleware.Error(w, req, "content-type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	input := schema.MFALoginSchema{}
+	if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+		middleware.Println(req, "Error Decoding JSON")
+		middleware.Error(w, req, "Error Decoding JSON", http.StatusBadRequest)
+		return
+	}
+	defer req.Body.Close()
+
+	username, err := auth.DecodeMFAToken(input.MFAToken)
+	if err != nil {
+		middleware.Println(req, "invalid mfa token")
+		metrics.Logins.WithLabelValues(metrics.LoginMFA, metrics.LoginFailure).Inc()
+		middleware.Error(w, req, "invalid or expired mfa token", http.StatusUnauthorized)
+		return
+	}
+
+	user, exists := schema.Database.Users[username]
+	if !exists || !user.TOTPEnabled || user.Disabled || user.PasswordResetRequired {
+		middleware.Printf(req, "user %v cannot complete a 2FA login", username)
+		metrics.Logins.WithLabelValues(metrics.LoginMFA, metrics.LoginFailure).Inc()
+		middleware.Error(w, req, "invalid or expired mfa token", http.StatusUnauthorized)
+		return
+	}
+
+	// second factor guesses count towards the same limits as passwords
+	key := guardKey(username)
+	ip := middleware.ClientIP(req)
+	if loginBlocked(w, req, key, ip) {
+		middleware.Printf(req, "login for %v from %v is blocked after failed attempts", key, ip)
+		metrics.Logins.WithLabelValues(metrics.LoginMFA, metrics.LoginFailure).Inc()
+		return
+	}
+
+	if !verifySecondFactor(&user, input.Code, input.RecoveryCode) {
+		middleware.Printf(req, "invalid second factor for %v", username)
+		recordLoginFailure(key, ip)
+		metrics.Logins.WithLabelValues(metrics.LoginMFA, metrics.LoginFailure).Inc()
+		middleware.Error(w, req, "invalid code", http.StatusUnauthorized)
+		return
+	}
+	schema.Database.Users[username] = user
+	auth.UsernameLoginGuard.Reset(key)
+	cancelScheduledDeletion(req, user)
+
+	accessToken, err := auth.GenerateJWT(username)
+	if err != nil {
+		middleware.Println(req, fmt.Sprintln(err))
+		middleware.Error(w, req, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	metrics.Logins.WithLabelValues(metrics.LoginMFA, metrics.LoginSuccess).Inc()
+
+	response := schema.TodoResponse{
+		Response: schema.Response{
+			StatusCode: 200,
+			Message:    "Login Success",
+		},
+		Data: map[string]string{
+			"access_token": accessToken,
+		},
+	}
+	middleware.Respond(w, req, http.StatusOK, response)
+}
@@ -0,0 +1,136 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/johnson-oragui/golang-todo-api/auth"
+	"github.com/johnson-oragui/golang-todo-api/middleware"
+	"github.com/johnson-oragui/golang-todo-api/schema"
+)
+
+// moves everything keyed by or owned through the old username to the new one
+func moveUserData(oldUsername, newUsername string) {
+	if todos, exists := schema.TodosDataBase.User[oldUsername]; exists {
+		schema.TodosDataBase.User[newUsername] = todos
+		delete(schema.TodosDataBase.User, oldUsername)
+	}
+
+	for hash, pat := range schema.TokensDataBase.Tokens {
+		if pat.Username == oldUsername {
+			pat.Username = newUsername
+			schema.TokensDataBase.Tokens[hash] = pat
+		}
+	}
+	for hash, accessToken := range schema.OAuthStore.Tokens {
+		if accessToken.Username == oldUsername {
+			accessToken.Username = newUsername
+			schema.OAuthStore.Tokens[hash] = accessToken
+		}
+	}
+	for hash, code := range schema.OAuthStore.Codes {
+		if code.Username == oldUsername {
+			code.Username = newUsername
+			schema.OAuthStore.Codes[hash] = code
+		}
+	}
+	for clientID, client := range schema.OAuthStore.Clients {
+		if client.Owner == oldUsername {
+			client.Owner = newUsername
+			schema.OAuthStore.Clients[clientID] = client
+		}
+	}
+	for key, identity := range schema.ExternalIdentitiesDataBase.Identities {
+		if identity.Username == oldUsername {
+			identity.Username = newUsername
+			schema.ExternalIdentitiesDataBase.Identities[key] = identity
+		}
+	}
+	for hash, verification := range schema.EmailVerificationsDataBase.Tokens {
+		if verification.Username == oldUsername {
+			verification.Username = newUsername
+			schema.EmailVerificationsDataBase.Tokens[hash] = verification
+		}
+	}
+	for hash, reset := range schema.PasswordResetsDataBase.Tokens {
+		if reset.Username == oldUsername {
+			reset.Username = newUsername
+			schema.PasswordResetsDataBase.Tokens[hash] = reset
+		}
+	}
+}
+
+// change username handler POST /api/v1/users/username
+func (r *UserRouter) HandleChangeUsername(w http.ResponseWriter, req *http.Request) {
+	req, span := traceHandler(req, "UserRouter.HandleChangeUsername")
+	defer span.End()
+
+	if req.Header.Get("Content-Type") != "application/json" {
+		middleware.Error(w, req, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	username, ok := req.Context().Value("username").(string)
+	if !ok {
+		middleware.Println(req, "User not authenticated")
+		middleware.Error(w, req, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	input := schema.UsernameChangeInput{}
+	if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+		middleware.Printf(req, "error decoding request body: %v", err)
+		middleware.Error(w, req, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	defer req.Body.Close()
+
+	if err := schema.ValidateUsername(input.Username); err != nil {
+		middleware.Println(req, err)
+		middleware.Error(w, req, fmt.Sprint(err), http.StatusBadRequest)
+		return
+	}
+
+	if input.Username == username {
+		middleware.Error(w, req, "new username must be different from the current username", http.StatusBadRequest)
+		return
+	}
+
+	user, err := schema.RenameUser(req.Context(), username, input.Username, r.usernameCooldown)
+	if errors.Is(err, schema.ErrUsernameTaken) || errors.Is(err, schema.ErrUsernameHeld) {
+		middleware.Println(req, err)
+		middleware.Error(w, req, fmt.Sprint(err), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		middleware.Println(req, err)
+		middleware.Error(w, req, "User not Found", http.StatusNotFound)
+		return
+	}
+
+	moveUserData(username, user.Username)
+
+	// tokens naming the old username stop working, this client gets a fresh one
+	auth.RevokeTokens(username)
+
+	accessToken, err := auth.GenerateJWT(user.Username)
+	if err != nil {
+		middleware.Println(req, fmt.Sprintln(err))
+		middleware.Error(w, req, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	response := schema.TodoResponse{
+		Response: schema.Response{
+			StatusCode: 200,
+			Message:    "Username changed successfully, sign in again on other devices",
+		},
+		Data: map[string]any{
+			"access_token": accessToken,
+			"user":         user,
+		},
+	}
+	middleware.Respond(w, req, http.StatusOK, response)
+}
@@ -3,18 +3,57 @@ package routes
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/johnson-oragui/golang-todo-api/auth"
+	"github.com/johnson-oragui/golang-todo-api/config"
+	"github.com/johnson-oragui/golang-todo-api/metrics"
+	"github.com/johnson-oragui/golang-todo-api/middleware"
+	"github.com/johnson-oragui/golang-todo-api/oidc"
 	"github.com/johnson-oragui/golang-todo-api/schema"
 	"github.com/johnson-oragui/golang-todo-api/utils"
 )
 
-type UserRouter struct{}
+type UserRouter struct {
+	baseURL                    string
+	emailVerificationTTL       time.Duration
+	totpIssuer                 string
+	recoveryCodes              int
+	usernameCooldown           time.Duration
+	accountDeletionGracePeriod time.Duration  // 0 deletes accounts right away
+	oidcProvider               *oidc.Provider // nil disables OIDC login
+	oidcLoginTTL               time.Duration
+}
 
-func NewUserRouter() *UserRouter {
-	return &UserRouter{}
+func NewUserRouter(cfg config.Config) *UserRouter {
+	router := &UserRouter{
+		baseURL:                    cfg.Server.BaseURL,
+		emailVerificationTTL:       cfg.Auth.EmailVerificationTTL,
+		totpIssuer:                 cfg.Auth.TOTPIssuer,
+		recoveryCodes:              cfg.Auth.RecoveryCodes,
+		usernameCooldown:           cfg.Auth.UsernameCooldown,
+		accountDeletionGracePeriod: cfg.Auth.AccountDeletionGracePeriod,
+		oidcLoginTTL:               cfg.OIDC.LoginTTL,
+	}
+
+	// sign in with an external identity provider
+	if cfg.OIDC.Issuer != "" {
+		redirectURL := cfg.OIDC.RedirectURL
+		if redirectURL == "" {
+			redirectURL = cfg.Server.BaseURL + "/api/v1/auth/oidc/callback"
+		}
+		router.oidcProvider = oidc.NewProvider(oidc.Config{
+			Issuer:       cfg.OIDC.Issuer,
+			ClientID:     cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret,
+			RedirectURL:  redirectURL,
+		})
+	}
+	return router
 }
 
 // Users Rosource Route Handler
@@ -28,23 +67,26 @@ func (b *UserRouter) HandleUsers(w http.ResponseWriter, req *http.Request) {
 	case http.MethodDelete:
 		b.HandleDeleteUser(w, req)
 	default:
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		http.Error(w, "Method not Allowed", http.StatusMethodNotAllowed)
+		middleware.Println(req, "Method not allowed")
+		middleware.Error(w, req, "Method not Allowed", http.StatusMethodNotAllowed)
 	}
 }
 
 // create user handler POST /users
 func (s *UserRouter) HandleRegister(w http.ResponseWriter, req *http.Request) {
+	req, span := traceHandler(req, "UserRouter.HandleRegister")
+	defer span.End()
+
 	var newUser schema.UserSchemaInput
 	if req.Method != http.MethodPost {
-		log.Println("Method Not allowed in register route")
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		middleware.Println(req, "Method Not allowed in register route")
+		middleware.Error(w, req, "Method Not Allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	contentType := req.Header.Get("Content-Type")
 	if contentType != "application/json" {
-		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		middleware.Error(w, req, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
 		return
 	}
 	// Limit the size of the request body
@@ -52,31 +94,41 @@ func (s *UserRouter) HandleRegister(w http.ResponseWriter, req *http.Request) {
 
 	// Decode the JSON request body directly into the struct
 	if err := json.NewDecoder(req.Body).Decode(&newUser); err != nil {
-		log.Printf("Error decoding JSON: %v", err)
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		middleware.Printf(req, "Error decoding JSON: %v", err)
+		middleware.Error(w, req, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
 	if err := newUser.ValidateUserBase(); err != nil {
-		log.Println(err)
-		http.Error(w, fmt.Sprintln(err), http.StatusBadRequest)
+		middleware.Println(req, err)
+		middleware.Error(w, req, fmt.Sprintln(err), http.StatusBadRequest)
 		return
 	}
 
 	defer req.Body.Close()
-	// check if user already exists
-	userExists, exists := schema.Database.Users[newUser.Username]
+	// check if user already exists, usernames and emails are unique regardless of case
+	userExists, exists := schema.FindUserByUsername(req.Context(), newUser.Username)
 	if exists {
-		log.Println("User already exists, user:", userExists)
-		http.Error(w, "User already exists", http.StatusForbidden)
+		middleware.Println(req, "User already exists, user:", userExists.Username)
+		middleware.Error(w, req, "User already exists", http.StatusForbidden)
+		return
+	}
+	if schema.UsernameReserved(newUser.Username, "") {
+		middleware.Printf(req, "username %v is reserved", newUser.Username)
+		middleware.Error(w, req, "User already exists", http.StatusForbidden)
+		return
+	}
+	if _, exists := schema.FindUserByEmail(req.Context(), newUser.Email); exists {
+		middleware.Println(req, "email is already registered")
+		middleware.Error(w, req, "Email is already registered", http.StatusForbidden)
 		return
 	}
 
 	// hash password
-	hashedPassword, err := auth.HashPassword(newUser.Password)
+	hashedPassword, err := auth.HashPassword(req.Context(), newUser.Password)
 	if err != nil {
-		log.Println("error hashing password")
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		middleware.Println(req, "error hashing password")
+		middleware.Error(w, req, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
@@ -88,10 +140,19 @@ func (s *UserRouter) HandleRegister(w http.ResponseWriter, req *http.Request) {
 		LastName:  newUser.LastName,
 		ID:        len(schema.Database.Users) + 1,
 		Password:  hashedPassword,
+		Role:      auth.RoleUser,
 	}
 
 	// save user to database
-	schema.Database.Users[newUser.Username] = data
+	if err := schema.SaveUser(req.Context(), data); err != nil {
+		middleware.Println(req, err)
+		middleware.Error(w, req, "User already exists", http.StatusForbidden)
+		return
+	}
+
+	if err := s.sendVerificationEmail(data); err != nil {
+		middleware.Printf(req, "could not send verification email: %v", err)
+	}
 
 	res := schema.UserSchemaOutput{
 		Message:    "User Registered successfully",
@@ -99,64 +160,135 @@ func (s *UserRouter) HandleRegister(w http.ResponseWriter, req *http.Request) {
 		Data:       data,
 	}
 
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-
-	if err := json.NewEncoder(w).Encode(res); err != nil {
-		http.Error(w, "Could not encode JSON", http.StatusInternalServerError)
-	}
+	middleware.Respond(w, req, http.StatusCreated, res)
 }
 
 func (s *UserRouter) HandleLogin(w http.ResponseWriter, req *http.Request) {
+	req, span := traceHandler(req, "UserRouter.HandleLogin")
+	defer span.End()
+
 	if req.Header.Get("Content-Type") != "application/json" {
-		log.Println("content-type must be application/json")
-		http.Error(w, "content-type must be application/json", http.StatusUnsupportedMediaType)
+		middleware.Println(req, "content-type must be application/json")
+		middleware.Error(w, req, "content-type must be application/json", http.StatusUnsupportedMediaType)
 		return
 	}
 	loginSchema := schema.LoginSchema{}
 
 	if err := json.NewDecoder(req.Body).Decode(&loginSchema); err != nil {
-		log.Println("Error Decoding JSON")
-		http.Error(w, "Error Decoding JSON", http.StatusInternalServerError)
+		middleware.Println(req, "Error Decoding JSON")
+		middleware.Error(w, req, "Error Decoding JSON", http.StatusInternalServerError)
 		return
 	}
 
+	// the username field also accepts the email address
 	notAllowedChars := "!@#$%^&*()_| \\/+?><'\""
-	if err := utils.ContainsAny(loginSchema.Username, notAllowedChars); err {
-		log.Printf("username must not contain %v", notAllowedChars)
+	isEmail := strings.Contains(loginSchema.Username, "@")
+	if err := utils.ContainsAny(loginSchema.Username, notAllowedChars); err && !isEmail {
+		middleware.Printf(req, "username must not contain %v", notAllowedChars)
 		message := fmt.Sprintf("username must not contain %v", notAllowedChars)
-		http.Error(w, message, http.StatusNotFound)
+		middleware.Error(w, req, message, http.StatusNotFound)
+		return
+	}
+
+	// the password policy only applies to new passwords, so passwords set
+	// under an older policy keep working
+	if loginSchema.Password == "" || len(loginSchema.Password) > 1024 {
+		middleware.Println(req, "invalid password length")
+		middleware.Error(w, req, "invalid username or password", http.StatusUnauthorized)
 		return
 	}
 
-	if err := utils.ValidatePassword(loginSchema.Password); err != nil {
-		log.Printf("error: %v", err)
-		http.Error(w, fmt.Sprint(err), http.StatusNotFound)
+	// failures count against the account whichever identifier was used
+	user, exists := schema.FindUser(req.Context(), loginSchema.Username)
+	key := guardKey(loginSchema.Username)
+	if exists {
+		key = guardKey(user.Username)
+	}
+	ip := middleware.ClientIP(req)
+	if loginBlocked(w, req, key, ip) {
+		middleware.Printf(req, "login for %v from %v is blocked after failed attempts", key, ip)
+		metrics.Logins.WithLabelValues(metrics.LoginPassword, metrics.LoginFailure).Inc()
 		return
 	}
 
 	// check if user exists
-	user, exists := schema.Database.Users[loginSchema.Username]
 	if !exists {
-		log.Printf("user does not exist")
-		http.Error(w, "invalid username or password", http.StatusUnauthorized)
+		middleware.Printf(req, "user does not exist")
+		recordLoginFailure(key, ip)
+		metrics.Logins.WithLabelValues(metrics.LoginPassword, metrics.LoginFailure).Inc()
+		middleware.Error(w, req, "invalid username or password", http.StatusUnauthorized)
 		return
 	}
 
-	err := auth.ComparePasswords(loginSchema.Password, user.Password)
+	err := auth.ComparePasswords(req.Context(), loginSchema.Password, user.Password)
 
 	if err != nil {
-		log.Printf("invalid username or password")
-		http.Error(w, "invalid username or password", http.StatusForbidden)
+		middleware.Printf(req, "invalid username or password")
+		recordLoginFailure(key, ip)
+		metrics.Logins.WithLabelValues(metrics.LoginPassword, metrics.LoginFailure).Inc()
+		// the same answer as for an unknown username, so logins do not reveal which usernames exist
+		middleware.Error(w, req, "invalid username or password", http.StatusUnauthorized)
 		return
 	}
 
-	accessToken, err := auth.GenerateJWT(loginSchema.Username)
+	auth.UsernameLoginGuard.Reset(key)
+
+	// upgrade hashes created with an outdated algorithm or cost while the password is at hand
+	if auth.NeedsRehash(user.Password) {
+		if hashedPassword, err := auth.HashPassword(req.Context(), loginSchema.Password); err != nil {
+			middleware.Printf(req, "could not rehash password for %v: %v", user.Username, err)
+		} else {
+			user.Password = hashedPassword
+			schema.Database.Users[user.Username] = user
+		}
+	}
+
+	if user.Disabled {
+		middleware.Printf(req, "account %v is disabled", user.Username)
+		metrics.Logins.WithLabelValues(metrics.LoginPassword, metrics.LoginFailure).Inc()
+		middleware.Error(w, req, "account is disabled", http.StatusForbidden)
+		return
+	}
+
+	if user.PasswordResetRequired {
+		middleware.Printf(req, "account %v must reset their password", user.Username)
+		metrics.Logins.WithLabelValues(metrics.LoginPassword, metrics.LoginFailure).Inc()
+		middleware.Error(w, req, "password reset required, check your email for a reset token", http.StatusForbidden)
+		return
+	}
+
+	// users with 2FA get a challenge token to exchange at /api/v1/auth/login/mfa
+	if user.TOTPEnabled {
+		mfaToken, err := auth.GenerateMFAToken(user.Username)
+		if err != nil {
+			middleware.Println(req, fmt.Sprintln(err))
+			middleware.Error(w, req, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		response := schema.TodoResponse{
+			Response: schema.Response{
+				StatusCode: 200,
+				Message:    "Two-factor authentication required",
+			},
+			Data: map[string]any{
+				"mfa_required": true,
+				"mfa_token":    mfaToken,
+			},
+		}
+		middleware.Respond(w, req, http.StatusOK, response)
+		return
+	}
+
+	// signing in without a pending second factor keeps the account
+	cancelScheduledDeletion(req, user)
+
+	accessToken, err := auth.GenerateJWT(user.Username)
 	if err != nil {
-		log.Println(fmt.Sprintln(err))
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		middleware.Println(req, fmt.Sprintln(err))
+		middleware.Error(w, req, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	metrics.Logins.WithLabelValues(metrics.LoginPassword, metrics.LoginSuccess).Inc()
 	response := schema.TodoResponse{
 		Response: schema.Response{
 			StatusCode: 200,
@@ -166,29 +298,53 @@ func (s *UserRouter) HandleLogin(w http.ResponseWriter, req *http.Request) {
 			"access_token": accessToken,
 		},
 	}
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("Error encoding JSON")
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	middleware.Respond(w, req, http.StatusOK, response)
+
+}
+
+// returns the key failed logins for username are tracked under, the same
+// however the username was typed
+func guardKey(username string) string {
+	return schema.NormalizeUsername(username)
+}
+
+// responds with 429 when the account or client is backing off after failed logins
+func loginBlocked(w http.ResponseWriter, req *http.Request, username, ip string) bool {
+	now := time.Now()
+	retryAfter := max(auth.UsernameLoginGuard.RetryAfter(username, now), auth.IPLoginGuard.RetryAfter(ip, now))
+	if retryAfter == 0 {
+		return false
 	}
 
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	middleware.Error(w, req, fmt.Sprintf("too many failed login attempts, try again in %v seconds", seconds), http.StatusTooManyRequests)
+	return true
+}
+
+func recordLoginFailure(username, ip string) {
+	now := time.Now()
+	auth.UsernameLoginGuard.Failure(username, now)
+	auth.IPLoginGuard.Failure(ip, now)
 }
 
 // fetch user handler GET /users
 func (s *UserRouter) HandleGetUser(w http.ResponseWriter, req *http.Request) {
+	req, span := traceHandler(req, "UserRouter.HandleGetUser")
+	defer span.End()
+
 	username, ok := req.Context().Value("username").(string)
 	if !ok {
-		log.Println("User not authenticated")
-		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		middleware.Println(req, "User not authenticated")
+		middleware.Error(w, req, "User not authenticated", http.StatusUnauthorized)
 		return
 	}
 
 	user, exists := schema.Database.Users[username]
 
 	if !exists {
-		log.Printf("username %s does not exists in the database", username)
-		http.Error(w, "User does not exists", http.StatusForbidden)
+		middleware.Printf(req, "username %s does not exists in the database", username)
+		middleware.Error(w, req, "User does not exists", http.StatusForbidden)
 		return
 	}
 
@@ -198,24 +354,23 @@ func (s *UserRouter) HandleGetUser(w http.ResponseWriter, req *http.Request) {
 		Data:       user,
 	}
 
-	w.Header().Add("Content-Type", "applicaton/json")
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(res); err != nil {
-		http.Error(w, "Error ENcoding JSON", http.StatusInternalServerError)
-	}
+	middleware.Respond(w, req, http.StatusOK, res)
 }
 
 // update user handler PUT /users
 func (r *UserRouter) HandleUpdateuser(w http.ResponseWriter, req *http.Request) {
+	req, span := traceHandler(req, "UserRouter.HandleUpdateuser")
+	defer span.End()
+
 	if req.Header.Get("Content-Type") != "application/json" {
-		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		middleware.Error(w, req, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
 		return
 	}
 
 	username, ok := req.Context().Value("username").(string)
 	if !ok {
-		log.Println("User not authenticated")
-		http.Error(w, "User not authenticated", http.StatusBadRequest)
+		middleware.Println(req, "User not authenticated")
+		middleware.Error(w, req, "User not authenticated", http.StatusBadRequest)
 		return
 	}
 
@@ -225,58 +380,84 @@ func (r *UserRouter) HandleUpdateuser(w http.ResponseWriter, req *http.Request)
 	err := json.NewDecoder(req.Body).Decode(&updateUser)
 
 	if err != nil {
-		log.Printf("error decoding request body: %v", err)
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		middleware.Printf(req, "error decoding request body: %v", err)
+		middleware.Error(w, req, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
 	// close
 	defer req.Body.Close()
 
+	if updateUser.Password != "" {
+		middleware.Println(req, "password cannot be changed through the profile update")
+		middleware.Error(w, req, "use POST /api/v1/users/password to change the password", http.StatusBadRequest)
+		return
+	}
+
+	if updateUser.Username != "" && updateUser.Username != username {
+		middleware.Println(req, "username cannot be changed through the profile update")
+		middleware.Error(w, req, "use POST /api/v1/users/username to change the username", http.StatusBadRequest)
+		return
+	}
+
 	// retrieve the user from database using the username
 	user, exists := schema.Database.Users[username]
 
 	if !exists {
-		http.Error(w, "User not Found", http.StatusNotFound)
+		middleware.Error(w, req, "User not Found", http.StatusNotFound)
 		return
 	}
 	notAllowedChars := "1234567890!@#$%^&*()_| \\/+?><'\""
 
 	// update the user
-	if updateUser.Email != "" {
+	emailChanged := false
+	if updateUser.Email != "" && updateUser.Email != user.Email {
+		if err := schema.ValidateEmail(updateUser.Email); err != nil {
+			middleware.Println(req, err)
+			middleware.Error(w, req, fmt.Sprint(err), http.StatusBadRequest)
+			return
+		}
+		if owner, exists := schema.FindUserByEmail(req.Context(), updateUser.Email); exists && owner.Username != user.Username {
+			middleware.Println(req, "email is already registered")
+			middleware.Error(w, req, "Email is already registered", http.StatusForbidden)
+			return
+		}
+		// a new address has to be verified again
 		user.Email = updateUser.Email
+		user.EmailVerified = false
+		emailChanged = true
 	}
 
 	if updateUser.FirstName != "" {
 		if err := utils.ContainsAny(updateUser.FirstName, notAllowedChars); err {
-			log.Printf("firstname must not contain %v", notAllowedChars)
+			middleware.Printf(req, "firstname must not contain %v", notAllowedChars)
 			message := fmt.Sprintf("firstname must not contain %v", notAllowedChars)
-			http.Error(w, message, http.StatusNotFound)
+			middleware.Error(w, req, message, http.StatusNotFound)
 			return
 		}
 		user.FirstName = updateUser.FirstName
 	}
 	if updateUser.LastName != "" {
 		if err := utils.ContainsAny(updateUser.Username, notAllowedChars); err {
-			log.Printf("lastname must not contain %v", notAllowedChars)
+			middleware.Printf(req, "lastname must not contain %v", notAllowedChars)
 			message := fmt.Sprintf("lastname must not contain %v", notAllowedChars)
-			http.Error(w, message, http.StatusNotFound)
+			middleware.Error(w, req, message, http.StatusNotFound)
 			return
 		}
 		user.LastName = updateUser.LastName
 	}
-	if updateUser.Password != "" {
-		if err := utils.ValidatePassword(updateUser.Password); err != nil {
-			log.Printf("error: %v", err)
-			http.Error(w, fmt.Sprint(err), http.StatusNotFound)
-			return
-		}
-		user.Password = updateUser.Password
-	}
 
-	delete(schema.Database.Users, username)
+	if err := schema.SaveUser(req.Context(), user); err != nil {
+		middleware.Println(req, err)
+		middleware.Error(w, req, fmt.Sprint(err), http.StatusForbidden)
+		return
+	}
 
-	schema.Database.Users[user.Username] = user
+	if emailChanged {
+		if err := r.sendVerificationEmail(user); err != nil {
+			middleware.Printf(req, "could not send verification email: %v", err)
+		}
+	}
 
 	response := schema.UserSchemaOutput{
 		Message:    "Updated successfully",
@@ -284,45 +465,138 @@ func (r *UserRouter) HandleUpdateuser(w http.ResponseWriter, req *http.Request)
 		Data:       schema.Database.Users[user.Username],
 	}
 
-	w.Header().Add("Content-Type", "applicaton/json")
-	w.WriteHeader(201)
-	err = json.NewEncoder(w).Encode(response)
+	middleware.Respond(w, req, http.StatusCreated, response)
+}
+
+// change password handler POST /api/v1/users/password
+func (r *UserRouter) HandleChangePassword(w http.ResponseWriter, req *http.Request) {
+	req, span := traceHandler(req, "UserRouter.HandleChangePassword")
+	defer span.End()
+
+	if req.Header.Get("Content-Type") != "application/json" {
+		middleware.Error(w, req, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	username, ok := req.Context().Value("username").(string)
+	if !ok {
+		middleware.Println(req, "User not authenticated")
+		middleware.Error(w, req, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	input := schema.PasswordChangeInput{}
+	if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+		middleware.Printf(req, "error decoding request body: %v", err)
+		middleware.Error(w, req, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	defer req.Body.Close()
+
+	user, exists := schema.Database.Users[username]
+	if !exists {
+		middleware.Error(w, req, "User not Found", http.StatusNotFound)
+		return
+	}
+
+	// users who signed up through an identity provider have no password yet and
+	// set their first one here, everyone else has to prove they know the current one
+	if user.Password != "" {
+		// guessing the current password counts as a failed login
+		key := guardKey(username)
+		ip := middleware.ClientIP(req)
+		if loginBlocked(w, req, key, ip) {
+			middleware.Printf(req, "password change for %v from %v is blocked after failed attempts", key, ip)
+			return
+		}
+
+		if err := auth.ComparePasswords(req.Context(), input.CurrentPassword, user.Password); err != nil {
+			middleware.Printf(req, "invalid current password for %v", username)
+			recordLoginFailure(key, ip)
+			middleware.Error(w, req, "current password is incorrect", http.StatusForbidden)
+			return
+		}
+	}
+
+	if err := schema.ValidatePassword(input.NewPassword); err != nil {
+		middleware.Println(req, err)
+		middleware.Error(w, req, fmt.Sprint(err), http.StatusBadRequest)
+		return
+	}
+
+	if user.Password != "" && input.NewPassword == input.CurrentPassword {
+		middleware.Error(w, req, "new password must be different from the current password", http.StatusBadRequest)
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(req.Context(), input.NewPassword)
 	if err != nil {
-		log.Printf("error decoding request body: %v", err)
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		middleware.Println(req, "error hashing password")
+		middleware.Error(w, req, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
+	user.Password = hashedPassword
+	schema.Database.Users[username] = user
+
+	// sign out everywhere, then give this client a fresh token
+	revokeUserTokens(username)
+
+	accessToken, err := auth.GenerateJWT(username)
+	if err != nil {
+		middleware.Println(req, fmt.Sprintln(err))
+		middleware.Error(w, req, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	response := schema.TodoResponse{
+		Response: schema.Response{
+			StatusCode: 200,
+			Message:    "Password changed successfully, other sessions and tokens have been revoked",
+		},
+		Data: map[string]string{
+			"access_token": accessToken,
+		},
+	}
+	middleware.Respond(w, req, http.StatusOK, response)
 }
 
 // delete user handler DELETE /users
 func (r *UserRouter) HandleDeleteUser(w http.ResponseWriter, req *http.Request) {
+	req, span := traceHandler(req, "UserRouter.HandleDeleteUser")
+	defer span.End()
 
 	username, ok := req.Context().Value("username").(string)
 	if !ok {
-		log.Println("User not authenticated")
-		http.Error(w, "User not authenticated", http.StatusBadRequest)
+		middleware.Println(req, "User not authenticated")
+		middleware.Error(w, req, "User not authenticated", http.StatusBadRequest)
 		return
 	}
 
-	if _, exists := schema.Database.Users[username]; !exists {
-		log.Printf("username %v does not exist", username)
+	user, exists := schema.Database.Users[username]
+	if !exists {
+		middleware.Printf(req, "username %v does not exist", username)
 		message := fmt.Sprintf("User %v does not exist", username)
-		http.Error(w, message, http.StatusBadRequest)
+		middleware.Error(w, req, message, http.StatusBadRequest)
 		return
 	}
 
-	delete(schema.Database.Users, username)
-
 	response := schema.Response{
 		Message:    "User deleted successfully",
 		StatusCode: 200,
 	}
 
-	w.Header().Add("Content-Type", "applicaton/json")
-	w.WriteHeader(http.StatusAccepted)
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("An error occured: %v", err)
-		http.Error(w, "Could not ENcode Json response", http.StatusInternalServerError)
+	if r.accountDeletionGracePeriod > 0 {
+		// signed out everywhere now, logging in again before the date cancels the deletion
+		deleteAfter := time.Now().Add(r.accountDeletionGracePeriod)
+		user.DeleteAfter = &deleteAfter
+		schema.Database.Users[username] = user
+		revokeUserTokens(username)
+
+		response.Message = fmt.Sprintf("User scheduled for deletion on %v, log in before then to cancel", deleteAfter.Format(time.RFC3339))
+	} else {
+		deleteAccount(req.Context(), username)
 	}
+
+	middleware.Respond(w, req, http.StatusAccepted, response)
 }
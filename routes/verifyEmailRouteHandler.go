@@ -0,0 +1,84 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/johnson-oragui/golang-todo-api/auth"
+	"github.com/johnson-oragui/golang-todo-api/mailer"
+	"github.com/johnson-oragui/golang-todo-api/middleware"
+	"github.com/johnson-oragui/golang-todo-api/schema"
+)
+
+// sends a verification link to the user's current email address
+func (s *UserRouter) sendVerificationEmail(user schema.UserBase) error {
+	token, err := auth.GenerateRandomToken()
+	if err != nil {
+		return err
+	}
+
+	schema.EmailVerificationsDataBase.Tokens[auth.HashToken(token)] = schema.EmailVerification{
+		Username:  user.Username,
+		Email:     user.Email,
+		ExpiresAt: time.Now().Add(s.emailVerificationTTL),
+	}
+
+	link := fmt.Sprintf("%v/api/v1/auth/verify-email?token=%v", s.baseURL, url.QueryEscape(token))
+
+	return mailer.Default.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body:    fmt.Sprintf("Hello %v,\n\nConfirm your email address by opening the link below:\n\n%v\n", user.FirstName, link),
+	})
+}
+
+// confirm email handler GET /api/v1/auth/verify-email?token=
+func (s *UserRouter) HandleVerifyEmail(w http.ResponseWriter, req *http.Request) {
+	req, span := traceHandler(req, "UserRouter.HandleVerifyEmail")
+	defer span.End()
+
+	token := req.URL.Query().Get("token")
+	if token == "" {
+		middleware.Println(req, "verification token not provided")
+		middleware.Error(w, req, "verification token not provided", http.StatusBadRequest)
+		return
+	}
+
+	tokenHash := auth.HashToken(token)
+	verification, exists := schema.EmailVerificationsDataBase.Tokens[tokenHash]
+	if !exists {
+		middleware.Println(req, "invalid verification token")
+		middleware.Error(w, req, "invalid or expired verification token", http.StatusBadRequest)
+		return
+	}
+
+	// tokens are single use
+	delete(schema.EmailVerificationsDataBase.Tokens, tokenHash)
+
+	if time.Now().After(verification.ExpiresAt) {
+		middleware.Printf(req, "verification token for %v has expired", verification.Username)
+		middleware.Error(w, req, "invalid or expired verification token", http.StatusBadRequest)
+		return
+	}
+
+	user, exists := schema.Database.Users[verification.Username]
+	// the link is only valid for the address it was sent to
+	if !exists || user.Email != verification.Email {
+		middleware.Printf(req, "verification token for %v is no longer valid", verification.Username)
+		middleware.Error(w, req, "invalid or expired verification token", http.StatusBadRequest)
+		return
+	}
+
+	user.EmailVerified = true
+	schema.Database.Users[user.Username] = user
+
+	res := schema.UserSchemaOutput{
+		Message:    "Email verified successfully",
+		StatusCode: 200,
+		Data:       user,
+	}
+
+	middleware.Respond(w, req, http.StatusOK, res)
+}
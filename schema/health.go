@@ -0,0 +1,52 @@
+package schema
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/johnson-oragui/golang-todo-api/tracing"
+)
+
+// SchemaVersion is the version of the store layout this build reads and writes,
+// raise it along with the migration that brings a store up to it
+const SchemaVersion = 1
+
+// StoreVersion is the schema version the store is at. The in-memory store is
+// created at SchemaVersion, a database backed store would read it from its
+// migrations table
+var StoreVersion = SchemaVersion
+
+// Ping reports whether the store can serve requests. The in-memory store only
+// needs its tables to exist, a database backed store would query the database here
+func Ping(ctx context.Context) error {
+	_, span := tracing.Start(ctx, "schema.Ping")
+	defer span.End()
+
+	if Database.Users == nil || Database.Usernames == nil || Database.Emails == nil || TodosDataBase.User == nil {
+		err := errors.New("the user and todo tables are not set up")
+		tracing.Fail(span, err)
+		return err
+	}
+	return nil
+}
+
+// CheckSchemaVersion reports whether the store is at the schema version this
+// build expects, a store still waiting for its migrations, or one migrated by a
+// newer build, cannot serve requests
+func CheckSchemaVersion(ctx context.Context) error {
+	_, span := tracing.Start(ctx, "schema.CheckSchemaVersion")
+	defer span.End()
+
+	var err error
+	switch {
+	case StoreVersion < SchemaVersion:
+		err = fmt.Errorf("the store is at schema version %d, waiting for migrations to version %d", StoreVersion, SchemaVersion)
+	case StoreVersion > SchemaVersion:
+		err = fmt.Errorf("the store is at schema version %d, newer than version %d this build supports", StoreVersion, SchemaVersion)
+	}
+	if err != nil {
+		tracing.Fail(span, err)
+	}
+	return err
+}
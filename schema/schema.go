@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/johnson-oragui/golang-todo-api/utils"
 )
@@ -12,6 +13,35 @@ type LoginSchema struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 }
+type MFALoginSchema struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type TOTPCodeInput struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type PasswordResetInput struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type PasswordChangeInput struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type UsernameChangeInput struct {
+	Username string `json:"username"`
+}
+
+type RoleInput struct {
+	Role string `json:"role"`
+}
+
 type Response struct {
 	Message    string `json:"message"`
 	StatusCode int    `json:"status_code"`
@@ -26,12 +56,25 @@ type UserSchemaInput struct {
 }
 
 type UserBase struct {
-	ID        int    `json:"id"`
-	Username  string `json:"username"`
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
-	Email     string `json:"email"`
-	Password  string `json:"-"`
+	ID            int    `json:"id"`
+	Username      string `json:"username"`
+	FirstName     string `json:"first_name"`
+	LastName      string `json:"last_name"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Password      string `json:"-"`
+
+	Role                  string `json:"role"`
+	Disabled              bool   `json:"disabled"`
+	PasswordResetRequired bool   `json:"password_reset_required"`
+
+	DeleteAfter *time.Time `json:"delete_after,omitempty"` // set while a requested deletion waits out its grace period
+
+	TOTPEnabled       bool     `json:"totp_enabled"`
+	TOTPSecret        string   `json:"-"`
+	TOTPPendingSecret string   `json:"-"` // set during enrollment until the first code is confirmed
+	TOTPLastUsedStep  int64    `json:"-"` // prevents replaying a code within its validity window
+	RecoveryCodes     []string `json:"-"` // hashed single-use recovery codes
 }
 
 type UserSchemaOutput struct {
@@ -40,14 +83,157 @@ type UserSchemaOutput struct {
 	Data       UserBase `json:"data"`
 }
 
+// users keyed by username. Changes to a username or email must go through
+// SaveUser so the case-insensitive indexes stay unique
 type UsersDataBase struct {
-	Users map[string]UserBase
+	Users     map[string]UserBase
+	Usernames map[string]string // normalized username to username
+	Emails    map[string]string // normalized email to username
 }
 
 type TodoDataBase struct {
 	User map[string]Todos
 }
 
+// pending email verification, keyed by the hash of the token sent to the user
+type EmailVerification struct {
+	Username  string
+	Email     string
+	ExpiresAt time.Time
+}
+
+type EmailVerificationDataBase struct {
+	Tokens map[string]EmailVerification
+}
+
+type PersonalAccessToken struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Username   string     `json:"-"`
+	Hash       string     `json:"-"`
+	Prefix     string     `json:"prefix"` // leading characters of the token, to help users tell them apart
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+type PersonalAccessTokenInput struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+// personal access tokens keyed by the hash of the token
+type TokenDataBase struct {
+	Tokens map[string]PersonalAccessToken
+	LastID int
+}
+
+// pending password reset, keyed by the hash of the token sent to the user
+type PasswordReset struct {
+	Username  string
+	ExpiresAt time.Time
+}
+
+type PasswordResetDataBase struct {
+	Tokens map[string]PasswordReset
+}
+
+// record of an action taken by an administrator
+type AuditEntry struct {
+	ID      int       `json:"id"`
+	Actor   string    `json:"actor"`
+	Action  string    `json:"action"`
+	Target  string    `json:"target"`
+	Details string    `json:"details,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+type AuditDataBase struct {
+	Entries []AuditEntry
+}
+
+// username given up by a rename, held back so nobody else can take it over right away
+type UsernameReservation struct {
+	ReservedFor string // current username of the user who gave it up
+	ExpiresAt   time.Time
+}
+
+// reservations keyed by normalized username
+type UsernameReservationDataBase struct {
+	Names map[string]UsernameReservation
+}
+
+// pending OIDC sign in, keyed by the state sent to the identity provider
+type OIDCLogin struct {
+	Nonce        string
+	CodeVerifier string
+	LinkUsername string // set when a signed in user links an identity to their account
+	ExpiresAt    time.Time
+}
+
+type OIDCLoginDataBase struct {
+	States map[string]OIDCLogin
+}
+
+// identity at an external OIDC provider linked to a local user
+type ExternalIdentity struct {
+	Issuer   string    `json:"issuer"`
+	Subject  string    `json:"subject"`
+	Username string    `json:"-"`
+	Email    string    `json:"email,omitempty"`
+	LinkedAt time.Time `json:"linked_at"`
+}
+
+// linked identities keyed by ExternalIdentityKey
+type ExternalIdentityDataBase struct {
+	Identities map[string]ExternalIdentity
+}
+
+type OAuthClientInput struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Confidential bool     `json:"confidential"` // confidential clients can keep a secret, public clients rely on PKCE alone
+}
+
+// third-party application allowed to request access on behalf of users
+type OAuthClient struct {
+	ClientID     string    `json:"client_id"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Confidential bool      `json:"confidential"`
+	SecretHash   string    `json:"-"`
+	Owner        string    `json:"-"` // user who registered the client
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// authorization code issued after consent, keyed by the hash of the code
+type OAuthAuthorizationCode struct {
+	ClientID      string
+	Username      string
+	RedirectURI   string
+	Scopes        []string
+	CodeChallenge string
+	ExpiresAt     time.Time
+}
+
+// access token issued to a client, keyed by the hash of the token
+type OAuthAccessToken struct {
+	ClientID  string
+	Username  string
+	Scopes    []string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// clients keyed by client id, codes and tokens keyed by their hash
+type OAuthDataBase struct {
+	Clients map[string]OAuthClient
+	Codes   map[string]OAuthAuthorizationCode
+	Tokens  map[string]OAuthAccessToken
+}
+
 type TodoSchema struct {
 	ID        int    `json:"id"`
 	Todo      string `json:"todo"`
@@ -64,7 +250,9 @@ type Todos struct {
 
 // Simulated global database
 var Database UsersDataBase = UsersDataBase{
-	Users: map[string]UserBase{},
+	Users:     map[string]UserBase{},
+	Usernames: map[string]string{},
+	Emails:    map[string]string{},
 }
 
 // Simulated global database
@@ -72,17 +260,84 @@ var TodosDataBase TodoDataBase = TodoDataBase{
 	User: map[string]Todos{},
 }
 
-func (u *UserSchemaInput) ValidateUserBase() error {
-	// validate username
-	if len(strings.TrimSpace(u.Username)) < 3 {
-		return fmt.Errorf("username must be atleast 3 characters long, input: '%v'", u.Username)
+// Simulated global database
+var EmailVerificationsDataBase EmailVerificationDataBase = EmailVerificationDataBase{
+	Tokens: map[string]EmailVerification{},
+}
+
+// Simulated global database
+var TokensDataBase TokenDataBase = TokenDataBase{
+	Tokens: map[string]PersonalAccessToken{},
+}
+
+// Simulated global database
+var PasswordResetsDataBase PasswordResetDataBase = PasswordResetDataBase{
+	Tokens: map[string]PasswordReset{},
+}
+
+// Simulated global database
+var AuditLog AuditDataBase = AuditDataBase{}
+
+// Simulated global database
+var UsernameReservations UsernameReservationDataBase = UsernameReservationDataBase{
+	Names: map[string]UsernameReservation{},
+}
+
+// Simulated global database
+var OIDCLoginsDataBase OIDCLoginDataBase = OIDCLoginDataBase{
+	States: map[string]OIDCLogin{},
+}
+
+// Simulated global database
+var ExternalIdentitiesDataBase ExternalIdentityDataBase = ExternalIdentityDataBase{
+	Identities: map[string]ExternalIdentity{},
+}
+
+// Simulated global database
+var OAuthStore OAuthDataBase = OAuthDataBase{
+	Clients: map[string]OAuthClient{},
+	Codes:   map[string]OAuthAuthorizationCode{},
+	Tokens:  map[string]OAuthAccessToken{},
+}
+
+// key of an external identity, subjects are only unique per issuer
+func ExternalIdentityKey(issuer, subject string) string {
+	return issuer + " " + subject
+}
+
+// validate email format
+func ValidateEmail(email string) error {
+	emailRegex := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9]+\.[a-zA-Z]{2,8}$`)
+	if !emailRegex.MatchString(email) {
+		return fmt.Errorf("invalid email format")
+	}
+	return nil
+}
+
+// validate a new password against the password policy
+func ValidatePassword(password string) error {
+	return utils.ValidatePassword(password)
+}
+
+// validate username format
+func ValidateUsername(username string) error {
+	if len(strings.TrimSpace(username)) < 3 {
+		return fmt.Errorf("username must be atleast 3 characters long, input: '%v'", username)
 	}
 	// Disallowed characters for username
 	notAllowedChars := "!@#$%^&*()_| \\/+?><'\""
 
-	if utils.ContainsAny(u.Username, notAllowedChars) {
+	if utils.ContainsAny(username, notAllowedChars) {
 		return fmt.Errorf("username cannot contain any of the following characters: %v", notAllowedChars)
 	}
+	return nil
+}
+
+func (u *UserSchemaInput) ValidateUserBase() error {
+	// validate username
+	if err := ValidateUsername(u.Username); err != nil {
+		return err
+	}
 
 	// validate first_name
 	if len(strings.TrimSpace(u.FirstName)) < 3 {
@@ -90,7 +345,7 @@ func (u *UserSchemaInput) ValidateUserBase() error {
 	}
 
 	// Check for disallowed characters in first_name
-	notAllowedChars = "1234567890!@#$%^&*()_| \\/+?><'\""
+	notAllowedChars := "1234567890!@#$%^&*()_| \\/+?><'\""
 
 	if utils.ContainsAny(u.FirstName, notAllowedChars) {
 		return fmt.Errorf("firstname cannot contain any of the following characters: %v", notAllowedChars)
@@ -107,19 +362,10 @@ func (u *UserSchemaInput) ValidateUserBase() error {
 	}
 
 	// validate password
-	if len(strings.TrimSpace(u.Password)) < 6 {
-		return fmt.Errorf("password must be atleast 6 characters long, input: '%v'", u.Password)
-	}
-
-	if err := utils.ValidatePassword(u.Password); err != nil {
+	if err := ValidatePassword(u.Password); err != nil {
 		return err
 	}
 
 	// validate email
-	emailRegex := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9]+\.[a-zA-Z]{2,8}$`)
-	if !emailRegex.MatchString(u.Email) {
-		return fmt.Errorf("invalid email format")
-	}
-
-	return nil
+	return ValidateEmail(u.Email)
 }
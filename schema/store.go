@@ -0,0 +1,25 @@
+package schema
+
+import "sync"
+
+// store guards the in-memory databases between the requests being served and
+// background jobs. Requests share it, so they run alongside each other as they
+// always have, while a job such as the deletion worker holds it alone and
+// never changes a database under a request. A job waits for the requests in
+// progress and new requests wait for the job, so jobs keep their exclusive
+// sections short
+var store sync.RWMutex
+
+// Share holds the store alongside the requests until release is called
+func Share() (release func()) {
+	store.RLock()
+	return store.RUnlock
+}
+
+// Exclusive runs fn with the store to itself
+func Exclusive(fn func()) {
+	store.Lock()
+	defer store.Unlock()
+
+	fn()
+}
@@ -0,0 +1,46 @@
+package schema
+
+import (
+	"context"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/johnson-oragui/golang-todo-api/tracing"
+)
+
+// returns the todos of a user, and whether they have any entry yet
+func FindTodos(ctx context.Context, username string) (Todos, bool) {
+	_, span := tracing.Start(ctx, "schema.FindTodos", attribute.String("enduser.id", username))
+	defer span.End()
+
+	todos, exists := TodosDataBase.User[username]
+	return todos, exists
+}
+
+// replaces the todos of a user
+func SaveTodos(ctx context.Context, username string, todos Todos) {
+	_, span := tracing.Start(ctx, "schema.SaveTodos", attribute.String("enduser.id", username), attribute.Int("todos.count", len(todos.AllTodos)))
+	defer span.End()
+
+	todoCount.Add(int64(len(todos.AllTodos) - len(TodosDataBase.User[username].AllTodos)))
+	TodosDataBase.User[username] = todos
+}
+
+// removes all the todos of a user
+func DeleteTodos(ctx context.Context, username string) {
+	_, span := tracing.Start(ctx, "schema.DeleteTodos", attribute.String("enduser.id", username))
+	defer span.End()
+
+	todoCount.Add(-int64(len(TodosDataBase.User[username].AllTodos)))
+	delete(TodosDataBase.User, username)
+}
+
+// number of todos in TodosDataBase, counted as they are saved and deleted so
+// it can be read without walking the store
+var todoCount atomic.Int64
+
+// TodoCount returns the number of todos of all users
+func TodoCount() int64 {
+	return todoCount.Load()
+}
@@ -0,0 +1,43 @@
+package schema
+
+import (
+	"sync"
+	"time"
+)
+
+// when each personal access token was last used, keyed by the hash of the token.
+// Every request made with a token records its use, so the times are kept behind
+// a lock of their own instead of being written back into TokensDataBase
+var tokensLastUsed = struct {
+	sync.Mutex
+	times map[string]time.Time
+}{times: map[string]time.Time{}}
+
+// records that the personal access token with hash was used at now
+func TouchToken(hash string, now time.Time) {
+	tokensLastUsed.Lock()
+	defer tokensLastUsed.Unlock()
+
+	tokensLastUsed.times[hash] = now
+}
+
+// returns pat with the time it was last used filled in
+func WithLastUsed(pat PersonalAccessToken) PersonalAccessToken {
+	tokensLastUsed.Lock()
+	defer tokensLastUsed.Unlock()
+
+	if lastUsed, exists := tokensLastUsed.times[pat.Hash]; exists {
+		pat.LastUsedAt = &lastUsed
+	}
+	return pat
+}
+
+// removes a personal access token and the record of its use
+func DeleteToken(hash string) {
+	delete(TokensDataBase.Tokens, hash)
+
+	tokensLastUsed.Lock()
+	defer tokensLastUsed.Unlock()
+
+	delete(tokensLastUsed.times, hash)
+}
@@ -0,0 +1,188 @@
+package schema
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/johnson-oragui/golang-todo-api/tracing"
+)
+
+var (
+	ErrUsernameTaken = errors.New("username is already taken")
+	ErrEmailTaken    = errors.New("email is already registered")
+	ErrUsernameHeld  = errors.New("username was recently released and is reserved")
+)
+
+// usernames are unique regardless of case, "Bob" and "bob" are the same user
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// email addresses are compared without case, as users type them
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// looks up a user by username in any case
+func FindUserByUsername(ctx context.Context, username string) (UserBase, bool) {
+	_, span := tracing.Start(ctx, "schema.FindUserByUsername")
+	defer span.End()
+
+	key, exists := Database.Usernames[NormalizeUsername(username)]
+	if !exists {
+		return UserBase{}, false
+	}
+	user, exists := Database.Users[key]
+	return user, exists
+}
+
+// looks up a user by email address in any case
+func FindUserByEmail(ctx context.Context, email string) (UserBase, bool) {
+	_, span := tracing.Start(ctx, "schema.FindUserByEmail")
+	defer span.End()
+
+	key, exists := Database.Emails[NormalizeEmail(email)]
+	if !exists {
+		return UserBase{}, false
+	}
+	user, exists := Database.Users[key]
+	return user, exists
+}
+
+// looks up a user by username or email address
+func FindUser(ctx context.Context, identifier string) (UserBase, bool) {
+	ctx, span := tracing.Start(ctx, "schema.FindUser")
+	defer span.End()
+
+	if strings.Contains(identifier, "@") {
+		if user, exists := FindUserByEmail(ctx, identifier); exists {
+			return user, true
+		}
+	}
+	return FindUserByUsername(ctx, identifier)
+}
+
+// inserts or updates a user, keeping the username and email indexes in step.
+// Fails if another user already holds the username or email
+func SaveUser(ctx context.Context, user UserBase) error {
+	_, span := tracing.Start(ctx, "schema.SaveUser", attribute.String("enduser.id", user.Username))
+	defer span.End()
+
+	usernameKey := NormalizeUsername(user.Username)
+	if owner, exists := Database.Usernames[usernameKey]; exists && owner != user.Username {
+		return ErrUsernameTaken
+	}
+
+	emailKey := NormalizeEmail(user.Email)
+	if owner, exists := Database.Emails[emailKey]; emailKey != "" && exists && owner != user.Username {
+		return ErrEmailTaken
+	}
+
+	previous, existed := Database.Users[user.Username]
+	if existed {
+		delete(Database.Emails, NormalizeEmail(previous.Email))
+	} else {
+		userCount.Add(1)
+	}
+
+	Database.Users[user.Username] = user
+	Database.Usernames[usernameKey] = user.Username
+	if emailKey != "" {
+		Database.Emails[emailKey] = user.Username
+	}
+	return nil
+}
+
+// removes a user and their index entries
+func DeleteUser(ctx context.Context, username string) {
+	_, span := tracing.Start(ctx, "schema.DeleteUser", attribute.String("enduser.id", username))
+	defer span.End()
+
+	user, exists := Database.Users[username]
+	if !exists {
+		return
+	}
+
+	delete(Database.Users, username)
+	delete(Database.Usernames, NormalizeUsername(username))
+	delete(Database.Emails, NormalizeEmail(user.Email))
+	userCount.Add(-1)
+}
+
+// number of users in Database, counted as they are saved and deleted so it can
+// be read without walking the store
+var userCount atomic.Int64
+
+// UserCount returns the number of users
+func UserCount() int64 {
+	return userCount.Load()
+}
+
+// reports whether username is held back from everyone except the user now called owner
+func UsernameReserved(username, owner string) bool {
+	key := NormalizeUsername(username)
+
+	reservation, exists := UsernameReservations.Names[key]
+	if !exists {
+		return false
+	}
+	if time.Now().After(reservation.ExpiresAt) {
+		delete(UsernameReservations.Names, key)
+		return false
+	}
+	return reservation.ReservedFor != owner
+}
+
+// changes a user's username, reserving the old one for cooldown. Only the
+// user record and indexes are updated, data keyed by username is left to the caller
+func RenameUser(ctx context.Context, oldUsername, newUsername string, cooldown time.Duration) (UserBase, error) {
+	_, span := tracing.Start(ctx, "schema.RenameUser", attribute.String("enduser.id", oldUsername))
+	defer span.End()
+
+	user, exists := Database.Users[oldUsername]
+	if !exists {
+		return UserBase{}, errors.New("user does not exist")
+	}
+
+	newKey := NormalizeUsername(newUsername)
+	if owner, exists := Database.Usernames[newKey]; exists && owner != oldUsername {
+		return UserBase{}, ErrUsernameTaken
+	}
+	if UsernameReserved(newUsername, oldUsername) {
+		return UserBase{}, ErrUsernameHeld
+	}
+
+	delete(Database.Users, oldUsername)
+	delete(Database.Usernames, NormalizeUsername(oldUsername))
+
+	user.Username = newUsername
+	Database.Users[newUsername] = user
+	Database.Usernames[newKey] = newUsername
+	if emailKey := NormalizeEmail(user.Email); emailKey != "" {
+		Database.Emails[emailKey] = newUsername
+	}
+
+	// names this user reserved earlier follow them to the new name
+	for key, reservation := range UsernameReservations.Names {
+		if reservation.ReservedFor == oldUsername {
+			reservation.ReservedFor = newUsername
+			UsernameReservations.Names[key] = reservation
+		}
+	}
+	delete(UsernameReservations.Names, newKey)
+
+	// a change in case only keeps the same name
+	if oldKey := NormalizeUsername(oldUsername); oldKey != newKey && cooldown > 0 {
+		UsernameReservations.Names[oldKey] = UsernameReservation{
+			ReservedFor: newUsername,
+			ExpiresAt:   time.Now().Add(cooldown),
+		}
+	}
+
+	return user, nil
+}
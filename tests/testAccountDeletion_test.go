@@ -0,0 +1,207 @@
+package tests
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/johnson-oragui/golang-todo-api/auth"
+	"github.com/johnson-oragui/golang-todo-api/routes"
+	"github.com/johnson-oragui/golang-todo-api/schema"
+)
+
+func TestDeleteUserCascades(t *testing.T) {
+	router := routes.MyHandler(testConfig())
+
+	token := registerAndLogin(t, router, "cascadeuser", "cascadeuser@gmail.com")
+	doJSON(t, router, http.MethodPost, "/api/v1/users/todos", token, map[string]any{"todo": "private"}, nil)
+
+	pat := map[string]any{}
+	doJSON(t, router, http.MethodPost, "/api/v1/users/tokens", token, map[string]any{"name": "ci", "scopes": []string{"todos:read"}}, &pat)
+
+	rr := doJSON(t, router, http.MethodDelete, "/api/v1/users", token, nil, nil)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected to get 202, but got %v", rr.Code)
+	}
+
+	rr = doJSON(t, router, http.MethodGet, "/api/v1/users/todos", pat["token"].(string), nil, nil)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected to get 401 with a token of the deleted account, but got %v", rr.Code)
+	}
+
+	// a new account under the same name starts empty
+	token = registerAndLogin(t, router, "cascadeuser", "cascadeuser@gmail.com")
+	rr = doJSON(t, router, http.MethodGet, "/api/v1/users/todos", token, nil, nil)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected no todos to be inherited, but got %v: %v", rr.Code, rr.Body.String())
+	}
+}
+
+func TestScheduledDeletion(t *testing.T) {
+	cfg := testConfig()
+	cfg.Auth.AccountDeletionGracePeriod = time.Hour
+	router := routes.MyHandler(cfg)
+
+	token := registerAndLogin(t, router, "graceuser", "graceuser@gmail.com")
+
+	rr := doJSON(t, router, http.MethodDelete, "/api/v1/users", token, nil, nil)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected to get 202, but got %v", rr.Code)
+	}
+	rr = doJSON(t, router, http.MethodGet, "/api/v1/users", token, nil, nil)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected to be signed out, but got %v", rr.Code)
+	}
+
+	// logging in during the grace period cancels the deletion
+	token = login(t, router, "graceuser")
+	routes.PurgeScheduledDeletions(time.Now().Add(2 * time.Hour))
+	rr = doJSON(t, router, http.MethodGet, "/api/v1/users", token, nil, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected the account to be kept, but got %v", rr.Code)
+	}
+
+	doJSON(t, router, http.MethodDelete, "/api/v1/users", token, nil, nil)
+	routes.PurgeScheduledDeletions(time.Now().Add(2 * time.Hour))
+
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/auth/login", "", loginFor("graceuser"), nil)
+	if rr.Code == http.StatusOK {
+		t.Fatal("expected the account to be deleted after the grace period")
+	}
+}
+
+func TestScheduledDeletionNeedsSecondFactor(t *testing.T) {
+	cfg := testConfig()
+	cfg.Auth.AccountDeletionGracePeriod = time.Hour
+	router := routes.MyHandler(cfg)
+
+	token := registerAndLogin(t, router, "gracemfauser", "gracemfauser@gmail.com")
+	enrollment := map[string]string{}
+	doJSON(t, router, http.MethodPost, "/api/v1/users/2fa/totp", token, nil, &enrollment)
+	now := time.Now()
+	code, _ := auth.TOTPCode(enrollment["secret"], now)
+	doJSON(t, router, http.MethodPost, "/api/v1/users/2fa/totp/confirm", token, map[string]string{"code": code}, nil)
+
+	rr := doJSON(t, router, http.MethodDelete, "/api/v1/users", token, nil, nil)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected to get 202, but got %v", rr.Code)
+	}
+
+	// the password alone does not keep the account
+	challenge := map[string]any{}
+	doJSON(t, router, http.MethodPost, "/api/v1/auth/login", "", loginFor("gracemfauser"), &challenge)
+	if schema.Database.Users["gracemfauser"].DeleteAfter == nil {
+		t.Fatal("expected the deletion to stay scheduled until the second factor is verified")
+	}
+
+	nextCode, _ := auth.TOTPCode(enrollment["secret"], now.Add(30*time.Second))
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/auth/login/mfa", "", map[string]any{"mfa_token": challenge["mfa_token"], "code": nextCode}, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected to get 200, but got %v: %v", rr.Code, rr.Body.String())
+	}
+	if schema.Database.Users["gracemfauser"].DeleteAfter != nil {
+		t.Fatal("expected the completed login to cancel the deletion")
+	}
+}
+
+func TestDeletionWorkerStops(t *testing.T) {
+	cfg := testConfig()
+	cfg.Auth.AccountDeletionGracePeriod = time.Hour
+	cfg.Auth.AccountDeletionInterval = time.Millisecond
+	router := routes.MyHandler(cfg)
+
+	// registering sends an email, so it does not hold the store and goes first
+	token := registerAndLogin(t, router, "workeruser", "workeruser@gmail.com")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := routes.StartDeletionWorker(ctx, cfg)
+
+	// requests are served while the worker purges, go test -race checks they
+	// never touch the store at the same time
+	for i := 0; i < 20; i++ {
+		if rr := doJSON(t, router, http.MethodPost, "/api/v1/users/todos", token, todoOnePayload, nil); rr.Code != http.StatusCreated {
+			t.Fatalf("expected to get 201 while the worker runs, but got %v", rr.Code)
+		}
+	}
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the deletion worker to stop once its context was cancelled")
+	}
+}
+
+func TestDeletionWorkerOff(t *testing.T) {
+	// accounts are deleted right away by default, so there is nothing to purge
+	done := routes.StartDeletionWorker(context.Background(), testConfig())
+
+	select {
+	case <-done:
+	default:
+		t.Fatal("expected no deletion worker to start without a grace period")
+	}
+}
+
+func TestExportUser(t *testing.T) {
+	router := routes.MyHandler(testConfig())
+
+	token := registerAndLogin(t, router, "exportuser", "exportuser@gmail.com")
+	doJSON(t, router, http.MethodPost, "/api/v1/users/todos", token, map[string]any{"todo": "export me"}, nil)
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/users/export", bytes.NewBuffer(nil))
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %v", token))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("expected a zip archive, but got %v %v", rr.Code, rr.Header().Get("Content-Type"))
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if err != nil {
+		t.Fatalf("could not read the archive: %v", err)
+	}
+
+	files := map[string]*zip.File{}
+	for _, file := range archive.File {
+		files[file.Name] = file
+	}
+
+	profile := map[string]any{}
+	readJSONFile(t, files["profile.json"], &profile)
+	if profile["username"] != "exportuser" {
+		t.Fatalf("expected the profile, but got %v", profile)
+	}
+	if _, leaked := profile["password"]; leaked {
+		t.Fatal("expected the password hash to be left out")
+	}
+
+	todos := []map[string]any{}
+	readJSONFile(t, files["todos.json"], &todos)
+	if len(todos) != 1 || todos[0]["todo"] != "export me" {
+		t.Fatalf("expected the todos, but got %v", todos)
+	}
+}
+
+func readJSONFile(t *testing.T, file *zip.File, v any) {
+	t.Helper()
+
+	if file == nil {
+		t.Fatal("expected the file in the archive")
+	}
+	reader, err := file.Open()
+	if err != nil {
+		t.Fatalf("could not open %v: %v", file.Name, err)
+	}
+	defer reader.Close()
+
+	if err := json.NewDecoder(reader).Decode(v); err != nil {
+		t.Fatalf("could not decode %v: %v", file.Name, err)
+	}
+}
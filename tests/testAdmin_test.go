@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/johnson-oragui/golang-todo-api/routes"
+	"github.com/johnson-oragui/golang-todo-api/schema"
+)
+
+var resetTokenRegex = regexp.MustCompile(`(?m)^[A-Za-z0-9_-]{43}$`)
+
+func TestAdminAPI(t *testing.T) {
+	router := routes.MyHandler(testConfig())
+
+	err := routes.SeedAdmin(schema.UserSchemaInput{
+		Username:  "rootadmin",
+		FirstName: "admin",
+		LastName:  "admin",
+		Email:     "rootadmin@gmail.com",
+		Password:  "Testuser1234#",
+	})
+	if err != nil {
+		t.Fatalf("could not seed admin, %v", err)
+	}
+	adminToken := login(t, router, "rootadmin")
+
+	userToken := registerAndLogin(t, router, "rbacuser", "rbacuser@gmail.com")
+
+	rr := doJSON(t, router, http.MethodGet, "/api/v1/admin/users", userToken, nil, nil)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected a regular user to get 403, but got %v", rr.Code)
+	}
+
+	users := []schema.UserBase{}
+	rr = doJSON(t, router, http.MethodGet, "/api/v1/admin/users?q=rbac", adminToken, nil, &users)
+	if rr.Code != http.StatusOK || len(users) != 1 || users[0].Username != "rbacuser" {
+		t.Fatalf("expected to find rbacuser, but got %v: %v", rr.Code, rr.Body.String())
+	}
+	if users[0].Role != "user" {
+		t.Fatalf("expected role to be user, but got %v", users[0].Role)
+	}
+
+	// disabled accounts can neither use their tokens nor log in
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/admin/users/rbacuser/disable", adminToken, nil, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected to get 200, but got %v", rr.Code)
+	}
+	rr = doJSON(t, router, http.MethodGet, "/api/v1/users", userToken, nil, nil)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected a disabled user to get 403, but got %v", rr.Code)
+	}
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/auth/login", "", loginFor("rbacuser"), nil)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected a disabled user to get 403 on login, but got %v", rr.Code)
+	}
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/admin/users/rbacuser/enable", adminToken, nil, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected to get 200, but got %v", rr.Code)
+	}
+	rr = doJSON(t, router, http.MethodGet, "/api/v1/users", userToken, nil, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected an enabled user to get 200, but got %v", rr.Code)
+	}
+
+	// support staff can view accounts and todos but not change accounts
+	rr = doJSON(t, router, http.MethodPut, "/api/v1/admin/users/rbacuser/role", adminToken, map[string]string{"role": "support"}, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected to get 200, but got %v", rr.Code)
+	}
+	rr = doJSON(t, router, http.MethodGet, "/api/v1/admin/users/rootadmin", userToken, nil, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected support to get 200 viewing a user, but got %v", rr.Code)
+	}
+	rr = doJSON(t, router, http.MethodGet, "/api/v1/admin/users/rootadmin/todos", userToken, nil, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected support to get 200 viewing todos, but got %v", rr.Code)
+	}
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/admin/users/rootadmin/disable", userToken, nil, nil)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected support to get 403 disabling users, but got %v", rr.Code)
+	}
+
+	// a forced reset blocks the account until a new password is set
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/admin/users/rbacuser/password-reset", adminToken, nil, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected to get 200, but got %v", rr.Code)
+	}
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/auth/login", "", loginFor("rbacuser"), nil)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected to get 403 on login before resetting, but got %v", rr.Code)
+	}
+
+	msg, ok := testMailer.Last("rbacuser@gmail.com")
+	resetToken := resetTokenRegex.FindString(msg.Body)
+	if !ok || resetToken == "" {
+		t.Fatalf("expected a password reset email, but got %v", msg.Body)
+	}
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/auth/password-reset/confirm", "", map[string]string{"token": resetToken, "password": "Newpassword1234#"}, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected to get 200 resetting the password, but got %v: %v", rr.Code, rr.Body.String())
+	}
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/auth/login", "", map[string]string{"username": "rbacuser", "password": "Newpassword1234#"}, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected to log in with the new password, but got %v", rr.Code)
+	}
+
+	entries := []schema.AuditEntry{}
+	rr = doJSON(t, router, http.MethodGet, "/api/v1/admin/audit", adminToken, nil, &entries)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected to get 200, but got %v", rr.Code)
+	}
+	actions := []string{}
+	for _, entry := range entries {
+		actions = append(actions, entry.Action)
+	}
+	expected := []string{"disable_user", "enable_user", "update_role", "view_user", "view_todos", "force_password_reset"}
+	if len(actions) != len(expected) {
+		t.Fatalf("expected audit actions %v, but got %v", expected, actions)
+	}
+	for i := range expected {
+		if actions[i] != expected[i] {
+			t.Fatalf("expected audit actions %v, but got %v", expected, actions)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package tests
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/johnson-oragui/golang-todo-api/routes"
+)
+
+const spaOrigin = "https://app.example.com"
+
+// a router allowing spaOrigin
+func corsRouter(t *testing.T) http.Handler {
+	t.Helper()
+
+	cfg := testConfig()
+	cfg.CORS.AllowedOrigins = []string{spaOrigin}
+	cfg.CORS.AllowCredentials = true
+	return routes.MyHandler(cfg)
+}
+
+func preflight(router http.Handler, path, origin, method, headers string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(http.MethodOptions, path, bytes.NewBuffer(nil))
+	req.Header.Add("Origin", origin)
+	req.Header.Add("Access-Control-Request-Method", method)
+	if headers != "" {
+		req.Header.Add("Access-Control-Request-Headers", headers)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestCORSPreflight(t *testing.T) {
+	router := corsRouter(t)
+
+	// protected routes are preflighted without a token
+	for path, method := range map[string]string{
+		"/api/v1/users/todos/1": http.MethodPut,
+		"/api/v1/users":         http.MethodDelete,
+		"/api/v1/admin/audit":   http.MethodGet,
+		"/api/v1/auth/login":    http.MethodPost,
+	} {
+		rr := preflight(router, path, spaOrigin, method, "Authorization, Content-Type")
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("expected to get 204 preflighting %v %v, but got %v: %v", method, path, rr.Code, rr.Body.String())
+		}
+		if rr.Header().Get("Access-Control-Allow-Origin") != spaOrigin || rr.Header().Get("Access-Control-Allow-Credentials") != "true" {
+			t.Fatalf("expected the origin to be allowed with credentials, but got %v", rr.Header())
+		}
+		if rr.Header().Get("Access-Control-Allow-Headers") != "authorization, content-type" || rr.Header().Get("Access-Control-Max-Age") != "600" {
+			t.Fatalf("expected the requested headers and max age, but got %v", rr.Header())
+		}
+	}
+
+	// only the methods the route serves are offered
+	rr := preflight(router, "/api/v1/users/todos", spaOrigin, http.MethodPost, "")
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Access-Control-Allow-Methods") != "GET, POST" {
+		t.Fatalf("expected GET and POST to be allowed, but got %v: %v", rr.Code, rr.Header())
+	}
+}
+
+func TestCORSPreflightRejected(t *testing.T) {
+	router := corsRouter(t)
+
+	for name, rr := range map[string]*httptest.ResponseRecorder{
+		"origin": preflight(router, "/api/v1/users/todos", "https://evil.example.com", http.MethodGet, ""),
+		"method": preflight(router, "/api/v1/auth/login", spaOrigin, http.MethodDelete, ""),
+		"header": preflight(router, "/api/v1/users/todos", spaOrigin, http.MethodGet, "X-Debug"),
+	} {
+		if rr.Code != http.StatusForbidden || rr.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Fatalf("expected a preflight with a disallowed %v to get 403 without CORS headers, but got %v: %v", name, rr.Code, rr.Header())
+		}
+	}
+
+	if rr := preflight(router, "/no-such-page", spaOrigin, http.MethodGet, ""); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected to get 404 preflighting an unknown path, but got %v", rr.Code)
+	}
+}
+
+func TestCORSActualRequest(t *testing.T) {
+	router := corsRouter(t)
+	token := registerAndLogin(t, router, "corsuser", "corsuser@gmail.com")
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/users", bytes.NewBuffer(nil))
+	req.Header.Add("Origin", spaOrigin)
+	req.Header.Add("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || rr.Header().Get("Access-Control-Allow-Origin") != spaOrigin {
+		t.Fatalf("expected a 200 allowing the origin, but got %v: %v", rr.Code, rr.Header())
+	}
+	if rr.Header().Get("Access-Control-Expose-Headers") == "" || !slices.Contains(rr.Header().Values("Vary"), "Origin") {
+		t.Fatalf("expected the exposed headers and Vary: Origin, but got %v", rr.Header())
+	}
+
+	// other origins get the response without CORS headers, so browsers keep it from the page
+	req.Header.Set("Origin", "https://evil.example.com")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("expected no CORS headers for another origin, but got %v", rr.Header())
+	}
+}
@@ -0,0 +1,185 @@
+package tests
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johnson-oragui/golang-todo-api/config"
+	"github.com/johnson-oragui/golang-todo-api/ratelimit"
+)
+
+// writes a config file named name into a temporary directory
+func configFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("could not write the config file: %v", err)
+	}
+	return path
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	path := configFile(t, "config.yaml", `
+server:
+  addr: ":6000"
+  read_timeout: 5s
+log:
+  level: debug
+cors:
+  allowed_origins:
+    - https://app.example.com
+    - https://admin.example.com
+`)
+	t.Setenv("LISTEN_ADDR", ":7000")
+	t.Setenv("WRITE_TIMEOUT", "20s")
+
+	cfg, err := config.Load([]string{"-config", path, "-server-addr", ":8000", "-auth-require-verified-email"})
+	if err != nil {
+		t.Fatalf("expected the configuration to load, but got %v", err)
+	}
+
+	// flags beat the environment, which beats the file, which beats the defaults
+	if cfg.Server.Addr != ":8000" || cfg.Server.WriteTimeout != 20*time.Second || cfg.Server.ReadTimeout != 5*time.Second || cfg.Log.Level != "debug" {
+		t.Fatalf("expected each source to override the one before, but got %+v", cfg)
+	}
+	if cfg.Server.MaxHeaderBytes != 1<<20 || !cfg.Auth.RequireVerifiedEmail {
+		t.Fatalf("expected the default header limit and the flag, but got %+v", cfg)
+	}
+	if len(cfg.CORS.AllowedOrigins) != 2 || cfg.CORS.AllowedOrigins[1] != "https://admin.example.com" {
+		t.Fatalf("expected the origins from the file, but got %v", cfg.CORS.AllowedOrigins)
+	}
+
+	// CONFIG_FILE names the file when -config is not given, TOML files work too
+	t.Setenv("CONFIG_FILE", configFile(t, "config.toml", "[tracing]\nservice_name = \"todo-api-staging\"\n"))
+	cfg, err = config.Load(nil)
+	if err != nil || cfg.Tracing.ServiceName != "todo-api-staging" || cfg.Server.Addr != ":7000" {
+		t.Fatalf("expected the TOML file and the environment, but got %+v: %v", cfg, err)
+	}
+}
+
+func TestConfigPasswordPolicy(t *testing.T) {
+	path := configFile(t, "config.yaml", `
+auth:
+  password_policy:
+    min_length: 12
+    check_breached: false
+`)
+	t.Setenv("PASSWORD_MIN_ENTROPY_BITS", "50.5")
+
+	cfg, err := config.Load([]string{"-config", path, "-auth-password-policy-max-length", "64"})
+	if err != nil {
+		t.Fatalf("expected the configuration to load, but got %v", err)
+	}
+
+	policy := cfg.Auth.PasswordPolicy.Policy()
+	if policy.MinLength != 12 || policy.CheckBreached || policy.MinEntropyBits != 50.5 || policy.MaxLength != 64 || policy.MinCharClasses != 3 {
+		t.Fatalf("expected the policy from the file, environment, flag and defaults, but got %+v", policy)
+	}
+	if err := policy.Validate("Short1234#"); err == nil {
+		t.Fatal("expected a password under the configured minimum length to be rejected")
+	}
+
+	if _, err := config.Load([]string{"-config", configFile(t, "config.yaml", "auth:\n  password_policy:\n    min_size: 12\n")}); err == nil {
+		t.Fatal("expected an unknown password policy setting to be rejected")
+	}
+}
+
+func TestConfigRateLimits(t *testing.T) {
+	path := configFile(t, "config.yaml", `
+rate_limit:
+  default: 100/1m
+  routes:
+    - /api/v1/auth/login=5/1m
+    - /api/v1/users/todos = 50/30s
+`)
+
+	cfg, err := config.Load([]string{"-config", path, "-rate-limit-ip", "1000/1h"})
+	if err != nil {
+		t.Fatalf("expected the configuration to load, but got %v", err)
+	}
+	if cfg.RateLimit.Default != "100/1m" || cfg.RateLimit.IP != "1000/1h" {
+		t.Fatalf("expected the limits from the file and the flag, but got %+v", cfg.RateLimit)
+	}
+
+	limits := cfg.RateLimit.Limits()
+	if len(limits) != 2 || limits["/api/v1/auth/login"] != (ratelimit.Limit{Requests: 5, Per: time.Minute}) || limits["/api/v1/users/todos"] != (ratelimit.Limit{Requests: 50, Per: 30 * time.Second}) {
+		t.Fatalf("expected the route limits from the file, but got %v", limits)
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	for name, args := range map[string][]string{
+		"read timeout":         {"-server-read-timeout", "0s"},
+		"short secret":         {"-auth-jwt-secret", "short"},
+		"hash algorithm":       {"-auth-password-hash-algorithm", "md5"},
+		"bcrypt cost":          {"-auth-bcrypt-cost", "3"},
+		"argon2 memory":        {"-auth-argon2-memory", "16", "-auth-argon2-threads", "4"},
+		"max below min length": {"-auth-password-policy-min-length", "20", "-auth-password-policy-max-length", "16"},
+		"char classes":         {"-auth-password-policy-min-char-classes", "5"},
+		"origin with path":     {"-cors-allowed-origins", "https://app.example.com/login"},
+		"log level":            {"-log-level", "loud"},
+		"malformed number":     {"-server-max-header-bytes", "lots"},
+		"unknown file key":     {"-config", configFile(t, "config.yaml", "server:\n  port: 5000\n")},
+		"oidc without client":  {"-oidc-issuer", "https://accounts.example.com"},
+		"key without cert":     {"-tls-key-file", "server.key"},
+		"modern TLS 1.2":       {"-tls-cert-file", "server.crt", "-tls-key-file", "server.key", "-tls-cipher-policy", "modern"},
+		"mTLS without CA":      {"-tls-cert-file", "server.crt", "-tls-key-file", "server.key", "-tls-client-auth", "require"},
+		"redirect without TLS": {"-tls-redirect-addr", ":80"},
+		"smtp without host":    {"-mail-transport", "smtp", "-mail-from", "todo@example.com"},
+		"recovery codes":       {"-auth-recovery-codes", "0"},
+		"totp issuer":          {"-auth-totp-issuer", "todo:api"},
+		"oauth code ttl":       {"-oauth-code-ttl", "0s"},
+		"rate limit":           {"-rate-limit-default", "lots"},
+		"rate limit route":     {"-rate-limit-routes", "login=20/1m"},
+		"tracing exporter":     {"-tracing-exporter", "jaeger"},
+	} {
+		if _, err := config.Load(args); err == nil {
+			t.Fatalf("expected the %v to be rejected", name)
+		}
+	}
+
+	// console is the OpenTelemetry name of the stdout exporter
+	if _, err := config.Load([]string{"-tracing-exporter", "console"}); err != nil {
+		t.Fatalf("expected the console exporter to be accepted, but got %v", err)
+	}
+
+	// every problem is reported at once
+	_, err := config.Load([]string{"-server-read-timeout", "0s", "-log-level", "loud"})
+	if err == nil || !strings.Contains(err.Error(), "server.read_timeout") || !strings.Contains(err.Error(), "log.level") {
+		t.Fatalf("expected both settings to be reported, but got %v", err)
+	}
+}
+
+func TestConfigMasksSecrets(t *testing.T) {
+	const secret = "a-jwt-secret-of-at-least-32-bytes!"
+	t.Setenv("JWT_SECRET", secret)
+	t.Setenv("OIDC_CLIENT_SECRET", "oidc-client-secret")
+
+	cfg, err := config.Load(nil)
+	if err != nil {
+		t.Fatalf("expected the configuration to load, but got %v", err)
+	}
+
+	printed := &bytes.Buffer{}
+	cfg.Print(printed)
+	logged := &bytes.Buffer{}
+	slog.New(slog.NewJSONHandler(logged, nil)).Info("loaded configuration", "config", cfg)
+
+	for _, output := range []string{printed.String(), logged.String()} {
+		if strings.Contains(output, secret) || strings.Contains(output, "oidc-client-secret") {
+			t.Fatalf("expected the secrets to be masked, but got %v", output)
+		}
+		if !strings.Contains(output, "********") || !strings.Contains(output, ":5000") {
+			t.Fatalf("expected the masked secrets and the other settings, but got %v", output)
+		}
+	}
+	if !strings.Contains(printed.String(), "auth.jwt_secret = ********\n") {
+		t.Fatalf("expected one setting per line, but got %v", printed.String())
+	}
+}
@@ -0,0 +1,144 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/johnson-oragui/golang-todo-api/routes"
+	"github.com/johnson-oragui/golang-todo-api/schema"
+)
+
+var verifyLinkRegex = regexp.MustCompile(`/api/v1/auth/verify-email\?token=[A-Za-z0-9_-]+`)
+
+// registers a user and returns an access token for them
+func registerAndLogin(t *testing.T, router http.Handler, username, email string) string {
+	t.Helper()
+
+	payload, _ := json.Marshal(map[string]string{
+		"username":   username,
+		"first_name": "tester",
+		"last_name":  "tester",
+		"password":   "Testuser1234#",
+		"email":      email,
+	})
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/auth/register", bytes.NewBuffer(payload))
+	req.Header.Add("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected to register %v with 201, but got %v: %v", username, rr.Code, rr.Body.String())
+	}
+
+	return login(t, router, username)
+}
+
+// logs a user in with the test password and returns the access token
+func login(t *testing.T, router http.Handler, username string) string {
+	t.Helper()
+
+	payload, _ := json.Marshal(map[string]string{
+		"username": username,
+		"password": "Testuser1234#",
+	})
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/auth/login", bytes.NewBuffer(payload))
+	req.Header.Add("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected to login %v with 200, but got %v: %v", username, rr.Code, rr.Body.String())
+	}
+
+	loginResponse := struct {
+		Data map[string]string `json:"data"`
+	}{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &loginResponse); err != nil {
+		t.Fatalf("could not Unmarshal login response, %v", err)
+	}
+	return loginResponse.Data["access_token"]
+}
+
+func TestVerifyEmail(t *testing.T) {
+	router := routes.MyHandler(testConfig())
+
+	token := registerAndLogin(t, router, "verifyuser", "verifyuser@gmail.com")
+
+	msg, ok := testMailer.Last("verifyuser@gmail.com")
+	if !ok {
+		t.Fatal("expected a verification email to be sent")
+	}
+	link := verifyLinkRegex.FindString(msg.Body)
+	if link == "" {
+		t.Fatalf("expected a verification link in the email, but got %v", msg.Body)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, link, bytes.NewBuffer(nil))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected to get 200, but got %v", rr.Code)
+	}
+
+	// links are single use
+	req, _ = http.NewRequest(http.MethodGet, link, bytes.NewBuffer(nil))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected to get 400 on reuse, but got %v", rr.Code)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "/api/v1/users", bytes.NewBuffer(nil))
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %v", token))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	user := schema.UserSchemaOutput{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &user); err != nil {
+		t.Fatalf("could not Unmarshal Json, %v", err)
+	}
+	if !user.Data.EmailVerified {
+		t.Fatal("expected email to be verified")
+	}
+
+	// changing the email requires verifying it again
+	payload, _ := json.Marshal(map[string]string{"email": "verifyuser2@gmail.com"})
+	req, _ = http.NewRequest(http.MethodPut, "/api/v1/users", bytes.NewBuffer(payload))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %v", token))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	user = schema.UserSchemaOutput{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &user); err != nil {
+		t.Fatalf("could not Unmarshal Json, %v", err)
+	}
+	if user.Data.EmailVerified {
+		t.Fatal("expected email to be unverified after changing it")
+	}
+	if _, ok := testMailer.Last("verifyuser2@gmail.com"); !ok {
+		t.Fatal("expected a verification email to be sent to the new address")
+	}
+}
+
+func TestCreateTodoRequiresVerifiedEmail(t *testing.T) {
+	cfg := testConfig()
+	cfg.Auth.RequireVerifiedEmail = true
+	router := routes.MyHandler(cfg)
+
+	token := registerAndLogin(t, router, "unverified", "unverified@gmail.com")
+
+	payload, _ := json.Marshal(map[string]any{"todo": "verify first", "completed": false})
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/users/todos", bytes.NewBuffer(payload))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %v", token))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected to get 403, but got %v", rr.Code)
+	}
+}
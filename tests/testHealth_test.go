@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johnson-oragui/golang-todo-api/routes"
+	"github.com/johnson-oragui/golang-todo-api/schema"
+)
+
+type healthResponse struct {
+	Response struct {
+		Message    string `json:"message"`
+		StatusCode int    `json:"status_code"`
+	}
+	Data map[string]any `json:"data"`
+}
+
+func probe(t *testing.T, router http.Handler, path string) (int, healthResponse) {
+	t.Helper()
+
+	req, _ := http.NewRequest(http.MethodGet, path, bytes.NewBuffer(nil))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	response := healthResponse{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("could not Unmarshal Json from %v, %v", path, err)
+	}
+	return rr.Code, response
+}
+
+func TestHealthAndReadiness(t *testing.T) {
+	router := routes.MyHandler(testConfig())
+
+	if code, _ := probe(t, router, "/healthz"); code != http.StatusOK {
+		t.Fatalf("expected /healthz to get 200, but got %v", code)
+	}
+	if code, response := probe(t, router, "/readyz"); code != http.StatusOK || response.Data["storage"] != "ok" || response.Data["schema"] != "ok" {
+		t.Fatalf("expected /readyz to get 200 with the storage and schema checks, but got %v: %v", code, response)
+	}
+
+	// a failing dependency makes the API unready but still alive
+	routes.ReadinessChecks["queue"] = func(ctx context.Context) error { return errors.New("queue unreachable") }
+	defer delete(routes.ReadinessChecks, "queue")
+
+	code, response := probe(t, router, "/readyz")
+	if code != http.StatusServiceUnavailable || response.Data["queue"] != "queue unreachable" || response.Data["storage"] != "ok" {
+		t.Fatalf("expected /readyz to get 503 naming the failed check, but got %v: %v", code, response)
+	}
+	if code, _ := probe(t, router, "/healthz"); code != http.StatusOK {
+		t.Fatalf("expected /healthz to get 200, but got %v", code)
+	}
+}
+
+func TestProbesDoNotWaitForTheStore(t *testing.T) {
+	router := routes.MyHandler(testConfig())
+
+	// a background job holds the store to itself
+	held, release := make(chan struct{}), make(chan struct{})
+	go schema.Exclusive(func() {
+		close(held)
+		<-release
+	})
+	<-held
+	defer close(release)
+
+	for _, path := range []string{"/healthz", "/readyz"} {
+		answered := make(chan int, 1)
+		go func() {
+			req, _ := http.NewRequest(http.MethodGet, path, bytes.NewBuffer(nil))
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			answered <- rr.Code
+		}()
+
+		select {
+		case code := <-answered:
+			if code != http.StatusOK {
+				t.Fatalf("expected %v to get 200, but got %v", path, code)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %v to answer while the store is held", path)
+		}
+	}
+}
+
+func TestReadinessWaitsForMigrations(t *testing.T) {
+	router := routes.MyHandler(testConfig())
+	defer func(version int) { schema.StoreVersion = version }(schema.StoreVersion)
+
+	for version, expected := range map[int]string{
+		schema.SchemaVersion - 1: "waiting for migrations",
+		schema.SchemaVersion + 1: "newer than version",
+	} {
+		schema.StoreVersion = version
+
+		code, response := probe(t, router, "/readyz")
+		message, _ := response.Data["schema"].(string)
+		if code != http.StatusServiceUnavailable || !strings.Contains(message, expected) {
+			t.Fatalf("expected /readyz to get 503 for schema version %v, but got %v: %v", version, code, response)
+		}
+	}
+
+	schema.StoreVersion = schema.SchemaVersion
+	if code, response := probe(t, router, "/readyz"); code != http.StatusOK {
+		t.Fatalf("expected /readyz to get 200 once migrated, but got %v: %v", code, response)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	router := routes.MyHandler(testConfig())
+
+	code, response := probe(t, router, "/api/v1/version")
+	if code != http.StatusOK || response.Data["go_version"] != runtime.Version() {
+		t.Fatalf("expected the Go version %v, but got %v: %v", runtime.Version(), code, response)
+	}
+	for _, field := range []string{"version", "commit", "build_time", "modified"} {
+		if _, exists := response.Data[field]; !exists {
+			t.Fatalf("expected %v in the build information, but got %v", field, response.Data)
+		}
+	}
+}
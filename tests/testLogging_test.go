@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/johnson-oragui/golang-todo-api/middleware"
+	"github.com/johnson-oragui/golang-todo-api/routes"
+)
+
+// sends the access log to a buffer for the rest of the test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	previous := middleware.Logger
+	middleware.Logger = slog.New(slog.NewJSONHandler(buf, nil))
+	t.Cleanup(func() { middleware.Logger = previous })
+	return buf
+}
+
+// decodes the access log entries written to buf
+func logEntries(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	entries := []map[string]any{}
+	scanner := bufio.NewScanner(bytes.NewReader(buf.Bytes()))
+	for scanner.Scan() {
+		entry := map[string]any{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("expected a JSON log line, but got %v", scanner.Text())
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestAccessLogRedactsSecrets(t *testing.T) {
+	router := routes.MyHandler(testConfig())
+	logs := captureLogs(t)
+
+	token := registerAndLogin(t, router, "logginguser", "logginguser@gmail.com")
+	doJSON(t, router, http.MethodPost, "/api/v1/users/todos", token, map[string]any{
+		"todo":     "water the plants",
+		"password": "Todopassword1#",
+	}, nil)
+
+	for _, secret := range []string{"Testuser1234#", "Todopassword1#", token} {
+		if strings.Contains(logs.String(), secret) {
+			t.Fatalf("expected %v to be redacted, but got %v", secret, logs.String())
+		}
+	}
+
+	entries := logEntries(t, logs)
+	if len(entries) != 3 {
+		t.Fatalf("expected an entry per request, but got %v", entries)
+	}
+
+	// bodies are left out unless the route opts in
+	register, todo := entries[0], entries[2]
+	if register["path"] != "/api/v1/auth/register" || register["status"] != float64(http.StatusCreated) || register["body"] != nil {
+		t.Fatalf("expected the register request without its body, but got %v", register)
+	}
+
+	if todo["user"] != "logginguser" || todo["method"] != http.MethodPost || todo["bytes"].(float64) == 0 {
+		t.Fatalf("expected the todo request from logginguser, but got %v", todo)
+	}
+	if todo["headers"].(map[string]any)["Authorization"] != "[REDACTED]" {
+		t.Fatalf("expected the Authorization header to be redacted, but got %v", todo["headers"])
+	}
+	body := todo["body"].(map[string]any)
+	if body["todo"] != "water the plants" || body["password"] != "[REDACTED]" {
+		t.Fatalf("expected the todo body with the password redacted, but got %v", body)
+	}
+}
@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/johnson-oragui/golang-todo-api/routes"
+)
+
+func registerPayloadFor(username, email string) map[string]string {
+	return map[string]string{
+		"username":   username,
+		"first_name": "tester",
+		"last_name":  "tester",
+		"password":   "Testuser1234#",
+		"email":      email,
+	}
+}
+
+func TestUniqueUsernameAndEmail(t *testing.T) {
+	router := routes.MyHandler(testConfig())
+
+	registerAndLogin(t, router, "CaseUser", "CaseUser@Gmail.com")
+
+	rr := doJSON(t, router, http.MethodPost, "/api/v1/auth/register", "", registerPayloadFor("caseuser", "caseuser2@gmail.com"), nil)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected to get 403 for a username differing only in case, but got %v", rr.Code)
+	}
+
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/auth/register", "", registerPayloadFor("caseuser2", "CASEUSER@gmail.com"), nil)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected to get 403 for a duplicate email, but got %v", rr.Code)
+	}
+
+	// the email cannot be taken over through a profile update either
+	token := registerAndLogin(t, router, "caseuser3", "caseuser3@gmail.com")
+	rr = doJSON(t, router, http.MethodPut, "/api/v1/users", token, map[string]string{"email": "caseuser@gmail.com"}, nil)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected to get 403 for a duplicate email, but got %v", rr.Code)
+	}
+}
+
+func TestLoginWithUsernameOrEmail(t *testing.T) {
+	router := routes.MyHandler(testConfig())
+
+	registerAndLogin(t, router, "MixedCase", "mixedcase@gmail.com")
+
+	for _, identifier := range []string{"mixedcase", "MIXEDCASE", "MixedCase@Gmail.com"} {
+		data := map[string]string{}
+		rr := doJSON(t, router, http.MethodPost, "/api/v1/auth/login", "", map[string]string{
+			"username": identifier,
+			"password": "Testuser1234#",
+		}, &data)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected to login as %v with 200, but got %v: %v", identifier, rr.Code, rr.Body.String())
+		}
+
+		// tokens always name the account as registered
+		user := map[string]any{}
+		rr = doJSON(t, router, http.MethodGet, "/api/v1/users", data["access_token"], nil, &user)
+		if rr.Code != http.StatusOK || user["username"] != "MixedCase" {
+			t.Fatalf("expected to get MixedCase, but got %v: %v", rr.Code, rr.Body.String())
+		}
+	}
+}
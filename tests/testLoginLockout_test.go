@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/johnson-oragui/golang-todo-api/middleware"
+	"github.com/johnson-oragui/golang-todo-api/routes"
+	"github.com/johnson-oragui/golang-todo-api/schema"
+)
+
+func TestLoginBackoffAndUnlock(t *testing.T) {
+	router := routes.MyHandler(testConfig())
+
+	registerAndLogin(t, router, "lockuser", "lockuser@gmail.com")
+
+	wrongPassword := map[string]string{"username": "lockuser", "password": "Wrongpassword1#"}
+	for i := 0; i < 4; i++ {
+		rr := doJSON(t, router, http.MethodPost, "/api/v1/auth/login", "", wrongPassword, nil)
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("expected attempt %v to get 401, but got %v", i+1, rr.Code)
+		}
+	}
+
+	// even the right password is refused while backing off
+	rr := doJSON(t, router, http.MethodPost, "/api/v1/auth/login", "", loginFor("lockuser"), nil)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected to get 429, but got %v", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Fatal("expected a Retry-After header")
+	}
+
+	err := routes.SeedAdmin(schema.UserSchemaInput{
+		Username:  "lockadmin",
+		FirstName: "admin",
+		LastName:  "admin",
+		Email:     "lockadmin@gmail.com",
+		Password:  "Testuser1234#",
+	})
+	if err != nil {
+		t.Fatalf("could not seed admin, %v", err)
+	}
+	adminToken := login(t, router, "lockadmin")
+
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/admin/users/lockuser/unlock", adminToken, nil, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected to get 200, but got %v", rr.Code)
+	}
+
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/auth/login", "", loginFor("lockuser"), nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected to log in after unlocking, but got %v", rr.Code)
+	}
+}
+
+func TestLoginHidesUnknownUsernames(t *testing.T) {
+	router := routes.MyHandler(testConfig())
+
+	registerAndLogin(t, router, "existinguser", "existinguser@gmail.com")
+
+	// a wrong password and an unknown username get the same answer
+	answers := []middleware.Problem{}
+	for _, username := range []string{"existinguser", "missinguser"} {
+		rr := doJSON(t, router, http.MethodPost, "/api/v1/auth/login", "", map[string]string{"username": username, "password": "Wrongpassword1#"}, nil)
+		problem := middleware.Problem{}
+		if err := json.Unmarshal(rr.Body.Bytes(), &problem); err != nil {
+			t.Fatalf("could not Unmarshal Json, %v", err)
+		}
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("expected to get 401 for %v, but got %v", username, rr.Code)
+		}
+		problem.RequestID = ""
+		answers = append(answers, problem)
+	}
+	if answers[0] != answers[1] {
+		t.Fatalf("expected the same error for both, but got %v and %v", answers[0], answers[1])
+	}
+}
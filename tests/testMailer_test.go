@@ -0,0 +1,108 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johnson-oragui/golang-todo-api/mailer"
+)
+
+// accepts one email on a random port, without TLS or authentication, and
+// returns the address and a channel receiving the DATA sent
+func fakeSMTPServer(t *testing.T) (string, <-chan string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reply("220 fake.example.com ESMTP")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch command := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				reply("250 fake.example.com")
+			case command == "DATA":
+				reply("354 go ahead")
+				data := &strings.Builder{}
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				received <- data.String()
+				reply("250 queued")
+			case command == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	return listener.Addr().String(), received
+}
+
+func TestSMTPMailer(t *testing.T) {
+	addr, received := fakeSMTPServer(t)
+	host, port, _ := net.SplitHostPort(addr)
+	portNumber, _ := strconv.Atoi(port)
+
+	smtpMailer := mailer.SMTPMailer{Host: host, Port: portNumber, From: "todo@example.com", Timeout: 5 * time.Second}
+	err := smtpMailer.Send(mailer.Message{To: "jane@example.com", Subject: "Verify your email", Body: "Open the link below\nhttps://todo.example.com/verify"})
+	if err != nil {
+		t.Fatalf("expected the email to be delivered, but got %v", err)
+	}
+
+	data := <-received
+	for _, expected := range []string{"From: todo@example.com\r\n", "To: jane@example.com\r\n", "Subject: Verify your email\r\n", "Open the link below\r\nhttps://todo.example.com/verify"} {
+		if !strings.Contains(data, expected) {
+			t.Fatalf("expected the email to contain %q, but got %v", expected, data)
+		}
+	}
+
+	// a recipient smuggling extra headers is refused
+	if err := smtpMailer.Send(mailer.Message{To: "jane@example.com\r\nBcc: eve@example.com", Subject: "Hi"}); err == nil {
+		t.Fatalf("expected a line break in a header to be rejected")
+	}
+}
+
+func TestLogMailerOmitsBody(t *testing.T) {
+	buf := &bytes.Buffer{}
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	mailer.LogMailer{}.Send(mailer.Message{To: "jane@example.com", Subject: "Reset your password", Body: "token: s3cret-reset-token"})
+
+	logged := buf.String()
+	if strings.Contains(logged, "s3cret-reset-token") {
+		t.Fatalf("expected the email body to stay out of the logs, but got %v", logged)
+	}
+	if !strings.Contains(logged, "jane@example.com") || !strings.Contains(logged, `"level":"DEBUG"`) {
+		t.Fatalf("expected the recipient at debug level, but got %v", logged)
+	}
+}
@@ -3,9 +3,24 @@ package tests
 import (
 	"os"
 	"testing"
+
+	"github.com/johnson-oragui/golang-todo-api/config"
+	"github.com/johnson-oragui/golang-todo-api/mailer"
 )
 
+// captures the emails sent by the API during tests
+var testMailer = &mailer.MemoryMailer{}
+
+// the default configuration without rate limits, every test client shares one
+// address so the production limits would trip
+func testConfig() config.Config {
+	cfg := config.Default()
+	cfg.RateLimit = config.RateLimit{}
+	return cfg
+}
+
 func TestMain(m *testing.M) {
+	mailer.Default = testMailer
 
 	code := m.Run()
 
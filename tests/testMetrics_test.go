@@ -0,0 +1,108 @@
+package tests
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/johnson-oragui/golang-todo-api/metrics"
+	"github.com/johnson-oragui/golang-todo-api/routes"
+	"github.com/johnson-oragui/golang-todo-api/schema"
+)
+
+func TestMetrics(t *testing.T) {
+	router := routes.MyHandler(testConfig())
+
+	todoRequests := metrics.HTTPRequests.WithLabelValues(http.MethodGet, "/api/v1/users/todos/{todo_id}", "404")
+	missingToken := metrics.AuthFailures.WithLabelValues(metrics.AuthMissingToken)
+	loginSuccesses := metrics.Logins.WithLabelValues(metrics.LoginPassword, metrics.LoginSuccess)
+	loginFailures := metrics.Logins.WithLabelValues(metrics.LoginPassword, metrics.LoginFailure)
+	before := []float64{
+		testutil.ToFloat64(todoRequests),
+		testutil.ToFloat64(missingToken),
+		testutil.ToFloat64(loginSuccesses),
+		testutil.ToFloat64(loginFailures),
+	}
+
+	users, todos := schema.UserCount(), schema.TodoCount()
+	token := registerAndLogin(t, router, "metricsuser", "metricsuser@gmail.com")
+	doJSON(t, router, http.MethodPost, "/api/v1/users/todos", token, todoOnePayload, nil)
+	if schema.UserCount() != users+1 || schema.TodoCount() != todos+1 {
+		t.Fatalf("expected one more user and todo, but went from %v and %v to %v and %v", users, todos, schema.UserCount(), schema.TodoCount())
+	}
+	doJSON(t, router, http.MethodPost, "/api/v1/auth/login", "", map[string]string{"username": "metricsuser", "password": "Wrongpassword1#"}, nil)
+	doJSON(t, router, http.MethodGet, "/api/v1/users/todos", "", nil, nil)
+
+	// different todo IDs are counted under the one route template
+	doJSON(t, router, http.MethodGet, "/api/v1/users/todos/998", token, nil, nil)
+	doJSON(t, router, http.MethodGet, "/api/v1/users/todos/999", token, nil, nil)
+
+	after := []float64{
+		testutil.ToFloat64(todoRequests),
+		testutil.ToFloat64(missingToken),
+		testutil.ToFloat64(loginSuccesses),
+		testutil.ToFloat64(loginFailures),
+	}
+	for i, increase := range []float64{2, 1, 1, 1} {
+		if after[i]-before[i] != increase {
+			t.Fatalf("expected metric %v to increase by %v, but went from %v to %v", i, increase, before[i], after[i])
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "/metrics", bytes.NewBuffer(nil))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected to get 200, but got %v", rr.Code)
+	}
+	for _, series := range []string{
+		`todo_api_http_request_duration_seconds_bucket{method="GET",route="/api/v1/users/todos/{todo_id}",status="404",le="0.005"}`,
+		`todo_api_auth_failures_total{reason="missing_token"}`,
+		`todo_api_logins_total{method="password",result="success"}`,
+		"todo_api_users ",
+		"todo_api_todos ",
+	} {
+		if !strings.Contains(rr.Body.String(), series) {
+			t.Fatalf("expected %v in the metrics, but got %v", series, rr.Body.String())
+		}
+	}
+}
+
+func TestMetricsAccess(t *testing.T) {
+	scrape := func(router http.Handler, token string) int {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, "/metrics", bytes.NewBuffer(nil))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	cfg := testConfig()
+	cfg.Metrics.BearerToken = "scraper-token"
+	router := routes.MyHandler(cfg)
+	if code := scrape(router, ""); code != http.StatusUnauthorized {
+		t.Fatalf("expected to get 401 without the metrics token, but got %v", code)
+	}
+	if code := scrape(router, "wrong-token"); code != http.StatusUnauthorized {
+		t.Fatalf("expected to get 401 with another token, but got %v", code)
+	}
+	if code := scrape(router, "scraper-token"); code != http.StatusOK {
+		t.Fatalf("expected to get 200 with the metrics token, but got %v", code)
+	}
+
+	// metrics with a listener of their own are not served with the API
+	cfg.Metrics.Addr = "127.0.0.1:9090"
+	if code := scrape(routes.MyHandler(cfg), "scraper-token"); code != http.StatusNotFound {
+		t.Fatalf("expected to get 404 from the API, but got %v", code)
+	}
+	if code := scrape(routes.MetricsHandler(cfg), "scraper-token"); code != http.StatusOK {
+		t.Fatalf("expected to get 200 from the metrics handler, but got %v", code)
+	}
+}
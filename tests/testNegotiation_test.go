@@ -0,0 +1,124 @@
+package tests
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/johnson-oragui/golang-todo-api/routes"
+)
+
+func get(router http.Handler, path, token string, headers map[string]string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(http.MethodGet, path, bytes.NewBuffer(nil))
+	req.Header.Add("Authorization", "Bearer "+token)
+	for key, value := range headers {
+		req.Header.Add(key, value)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestResponseCompression(t *testing.T) {
+	router := routes.MyHandler(testConfig())
+	token := registerAndLogin(t, router, "compressuser", "compressuser@gmail.com")
+	for i := 0; i < 40; i++ {
+		rr := doJSON(t, router, http.MethodPost, "/api/v1/users/todos", token, map[string]any{"todo": fmt.Sprintf("water the plants in room %v", i)}, nil)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected to get 201, but got %v: %v", rr.Code, rr.Body.String())
+		}
+	}
+	plain := get(router, "/api/v1/users/todos", token, nil).Body.Bytes()
+
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"br":   func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+		"zstd": func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) },
+	}
+	for accept, encoding := range map[string]string{
+		"gzip":                    "gzip",
+		"gzip, deflate, br":       "br",
+		"gzip, deflate, br, zstd": "zstd",
+		"zstd;q=0.5, gzip;q=0.8":  "gzip",
+		"*":                       "zstd",
+		"br;q=0, zstd;q=0, gzip":  "gzip",
+	} {
+		rr := get(router, "/api/v1/users/todos", token, map[string]string{"Accept-Encoding": accept})
+		if rr.Code != http.StatusOK || rr.Header().Get("Content-Encoding") != encoding {
+			t.Fatalf("expected a %v response for %q, but got %v: %v", encoding, accept, rr.Code, rr.Header())
+		}
+		if rr.Body.Len() >= len(plain) {
+			t.Fatalf("expected the %v body to be smaller than %v bytes, but got %v", encoding, len(plain), rr.Body.Len())
+		}
+
+		reader, err := decoders[encoding](rr.Body)
+		if err != nil {
+			t.Fatalf("could not read the %v body: %v", encoding, err)
+		}
+		body, err := io.ReadAll(reader)
+		if err != nil || !bytes.Equal(body, plain) {
+			t.Fatalf("expected the %v body to decompress to the plain response, but got %v", encoding, err)
+		}
+	}
+
+	// small responses are not worth compressing
+	rr := get(router, "/api/v1/about", token, map[string]string{"Accept-Encoding": "gzip"})
+	if rr.Header().Get("Content-Encoding") != "" || rr.Header().Get("Vary") == "" {
+		t.Fatalf("expected a small response to be sent as is, but got %v", rr.Header())
+	}
+}
+
+func TestContentNegotiation(t *testing.T) {
+	router := routes.MyHandler(testConfig())
+	token := registerAndLogin(t, router, "negotiateuser", "negotiateuser@gmail.com")
+
+	decoders := map[string]func([]byte, any) error{
+		"application/json": json.Unmarshal,
+		"application/msgpack": func(data []byte, v any) error {
+			decoder := msgpack.NewDecoder(bytes.NewReader(data))
+			decoder.SetCustomStructTag("json")
+			return decoder.Decode(v)
+		},
+		"application/cbor": cbor.Unmarshal,
+	}
+	for accept, mediaType := range map[string]string{
+		"":                      "application/json",
+		"text/html":             "application/json",
+		"*/*":                   "application/json",
+		"application/msgpack":   "application/msgpack",
+		"application/x-msgpack": "application/msgpack",
+		"application/cbor":      "application/cbor",
+		"application/json;q=0.5, application/cbor": "application/cbor",
+	} {
+		rr := get(router, "/api/v1/users", token, map[string]string{"Accept": accept})
+		if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != mediaType {
+			t.Fatalf("expected a %v response for %q, but got %v: %v", mediaType, accept, rr.Code, rr.Header())
+		}
+
+		response := struct {
+			Data map[string]any `json:"data"`
+		}{}
+		if err := decoders[mediaType](rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("could not decode the %v body: %v", mediaType, err)
+		}
+		if response.Data["username"] != "negotiateuser" {
+			t.Fatalf("expected the profile in the %v body, but got %v", mediaType, response.Data)
+		}
+		// fields kept out of JSON stay out of every format
+		for _, hidden := range []string{"Password", "password", "TOTPSecret"} {
+			if _, exists := response.Data[hidden]; exists {
+				t.Fatalf("expected %v to be left out of the %v body, but got %v", hidden, mediaType, response.Data)
+			}
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/johnson-oragui/golang-todo-api/oidc"
+	"github.com/johnson-oragui/golang-todo-api/routes"
+)
+
+const oauthRedirectURI = "http://localhost:8080/callback"
+
+// registers an OAuth client owned by the user holding token
+func registerOAuthClient(t *testing.T, router http.Handler, token string, confidential bool) (string, string) {
+	t.Helper()
+
+	data := map[string]any{}
+	rr := doJSON(t, router, http.MethodPost, "/api/v1/oauth/clients", token, map[string]any{
+		"name":          "Todo Sync",
+		"redirect_uris": []string{oauthRedirectURI},
+		"confidential":  confidential,
+	}, &data)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected to get 201, but got %v: %v", rr.Code, rr.Body.String())
+	}
+
+	clientSecret, _ := data["client_secret"].(string)
+	return data["client"].(map[string]any)["client_id"].(string), clientSecret
+}
+
+// sends a form to the OAuth endpoints
+func postForm(router http.Handler, path string, form url.Values) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(http.MethodPost, path, bytes.NewBufferString(form.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+// approves clientID on the consent page and returns the redirect back to the client
+func approveOAuthClient(t *testing.T, router http.Handler, clientID, username, challenge string) *url.URL {
+	t.Helper()
+
+	rr := postForm(router, "/api/v1/oauth/authorize", url.Values{
+		"client_id":             {clientID},
+		"redirect_uri":          {oauthRedirectURI},
+		"response_type":         {"code"},
+		"scope":                 {"profile:read todos:read"},
+		"state":                 {"xyz"},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+		"username":              {username},
+		"password":              {"Testuser1234#"},
+		"decision":              {"approve"},
+	})
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected to get 303, but got %v: %v", rr.Code, rr.Body.String())
+	}
+
+	location, _ := url.Parse(rr.Header().Get("Location"))
+	return location
+}
+
+func TestOAuthAuthorizationCodeFlow(t *testing.T) {
+	router := routes.MyHandler(testConfig())
+
+	ownerToken := registerAndLogin(t, router, "oauthowner", "oauthowner@gmail.com")
+	registerAndLogin(t, router, "oauthgrantor", "oauthgrantor@gmail.com")
+	clientID, _ := registerOAuthClient(t, router, ownerToken, false)
+
+	verifier, _ := oidc.GenerateCodeVerifier()
+	challenge := oidc.CodeChallengeS256(verifier)
+
+	// the consent page names the client and what it asks for
+	query := url.Values{
+		"client_id":             {clientID},
+		"redirect_uri":          {oauthRedirectURI},
+		"response_type":         {"code"},
+		"scope":                 {"profile:read todos:read"},
+		"state":                 {"xyz"},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/oauth/authorize?"+query.Encode(), bytes.NewBuffer(nil))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Todo Sync") || !strings.Contains(rr.Body.String(), "Read your todos") {
+		t.Fatalf("expected the consent page, but got %v: %v", rr.Code, rr.Body.String())
+	}
+
+	location := approveOAuthClient(t, router, clientID, "oauthgrantor", challenge)
+	code := location.Query().Get("code")
+	if code == "" || location.Query().Get("state") != "xyz" {
+		t.Fatalf("expected a code and the state, but got %v", location)
+	}
+
+	// the code is bound to the PKCE verifier
+	rr = postForm(router, "/api/v1/oauth/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {clientID},
+		"code":          {code},
+		"redirect_uri":  {oauthRedirectURI},
+		"code_verifier": {"wrong-verifier"},
+	})
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "invalid_grant") {
+		t.Fatalf("expected invalid_grant, but got %v: %v", rr.Code, rr.Body.String())
+	}
+
+	// and single use, so a fresh code is needed after the failed attempt
+	code = approveOAuthClient(t, router, clientID, "oauthgrantor", challenge).Query().Get("code")
+	rr = postForm(router, "/api/v1/oauth/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {clientID},
+		"code":          {code},
+		"redirect_uri":  {oauthRedirectURI},
+		"code_verifier": {verifier},
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected to get 200, but got %v: %v", rr.Code, rr.Body.String())
+	}
+	tokenResponse := map[string]any{}
+	json.Unmarshal(rr.Body.Bytes(), &tokenResponse)
+	accessToken := tokenResponse["access_token"].(string)
+	if tokenResponse["scope"] != "profile:read todos:read" {
+		t.Fatalf("expected the granted scopes, but got %v", tokenResponse["scope"])
+	}
+
+	// the token acts for the user who consented, within the granted scopes
+	user := map[string]any{}
+	rr = doJSON(t, router, http.MethodGet, "/api/v1/users", accessToken, nil, &user)
+	if rr.Code != http.StatusOK || user["username"] != "oauthgrantor" {
+		t.Fatalf("expected to get oauthgrantor, but got %v: %v", rr.Code, rr.Body.String())
+	}
+	rr = doJSON(t, router, http.MethodPut, "/api/v1/users", accessToken, map[string]string{"first_name": "changed"}, nil)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected to get 403 without profile:write, but got %v", rr.Code)
+	}
+	rr = doJSON(t, router, http.MethodGet, "/api/v1/users/tokens", accessToken, nil, nil)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected to get 403 on a session only route, but got %v", rr.Code)
+	}
+
+	introspection := map[string]any{}
+	rr = postForm(router, "/api/v1/oauth/introspect", url.Values{"client_id": {clientID}, "token": {accessToken}})
+	json.Unmarshal(rr.Body.Bytes(), &introspection)
+	if introspection["active"] != true || introspection["username"] != "oauthgrantor" {
+		t.Fatalf("expected an active token, but got %v", introspection)
+	}
+
+	rr = postForm(router, "/api/v1/oauth/revoke", url.Values{"client_id": {clientID}, "token": {accessToken}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected to get 200, but got %v", rr.Code)
+	}
+
+	rr = doJSON(t, router, http.MethodGet, "/api/v1/users", accessToken, nil, nil)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected to get 401 with a revoked token, but got %v", rr.Code)
+	}
+	introspection = map[string]any{}
+	rr = postForm(router, "/api/v1/oauth/introspect", url.Values{"client_id": {clientID}, "token": {accessToken}})
+	json.Unmarshal(rr.Body.Bytes(), &introspection)
+	if introspection["active"] != false {
+		t.Fatalf("expected an inactive token, but got %v", introspection)
+	}
+}
+
+func TestOAuthConsentDenied(t *testing.T) {
+	router := routes.MyHandler(testConfig())
+
+	ownerToken := registerAndLogin(t, router, "oauthdenier", "oauthdenier@gmail.com")
+	clientID, _ := registerOAuthClient(t, router, ownerToken, false)
+
+	rr := postForm(router, "/api/v1/oauth/authorize", url.Values{
+		"client_id":             {clientID},
+		"redirect_uri":          {oauthRedirectURI},
+		"scope":                 {"todos:read"},
+		"state":                 {"abc"},
+		"code_challenge":        {oidc.CodeChallengeS256("verifier")},
+		"code_challenge_method": {"S256"},
+		"decision":              {"deny"},
+	})
+	location, _ := url.Parse(rr.Header().Get("Location"))
+	if rr.Code != http.StatusSeeOther || location.Query().Get("error") != "access_denied" || location.Query().Get("state") != "abc" {
+		t.Fatalf("expected access_denied, but got %v: %v", rr.Code, location)
+	}
+
+	// unregistered redirect URIs are never redirected to
+	rr = postForm(router, "/api/v1/oauth/authorize", url.Values{
+		"client_id":    {clientID},
+		"redirect_uri": {"https://attacker.example.com/callback"},
+		"decision":     {"deny"},
+	})
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected to get 400, but got %v", rr.Code)
+	}
+}
+
+func TestOAuthConfidentialClientAuthentication(t *testing.T) {
+	router := routes.MyHandler(testConfig())
+
+	ownerToken := registerAndLogin(t, router, "oauthconfidential", "oauthconfidential@gmail.com")
+	clientID, clientSecret := registerOAuthClient(t, router, ownerToken, true)
+	if clientSecret == "" {
+		t.Fatal("expected a client secret")
+	}
+
+	verifier, _ := oidc.GenerateCodeVerifier()
+	code := approveOAuthClient(t, router, clientID, "oauthconfidential", oidc.CodeChallengeS256(verifier)).Query().Get("code")
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {oauthRedirectURI},
+		"code_verifier": {verifier},
+	}
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/oauth/token", bytes.NewBufferString(form.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(clientID, "wrong-secret")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected to get 401, but got %v", rr.Code)
+	}
+
+	req, _ = http.NewRequest(http.MethodPost, "/api/v1/oauth/token", bytes.NewBufferString(form.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(clientID, clientSecret)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected to get 200, but got %v: %v", rr.Code, rr.Body.String())
+	}
+}
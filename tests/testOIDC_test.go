@@ -0,0 +1,233 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/johnson-oragui/golang-todo-api/config"
+	"github.com/johnson-oragui/golang-todo-api/oidc/oidctest"
+	"github.com/johnson-oragui/golang-todo-api/routes"
+	"github.com/johnson-oragui/golang-todo-api/schema"
+)
+
+// starts a mock identity provider for the duration of a test and points cfg at it
+func useMockProvider(t *testing.T, cfg *config.Config) *oidctest.Server {
+	t.Helper()
+
+	provider := oidctest.NewServer("todo-api", "todo-api-secret")
+	t.Cleanup(provider.Close)
+
+	cfg.OIDC.Issuer = provider.URL
+	cfg.OIDC.ClientID = "todo-api"
+	cfg.OIDC.ClientSecret = "todo-api-secret"
+	return provider
+}
+
+// follows authURL through the mock provider and returns the callback request the browser would make
+func authorize(t *testing.T, authURL string, stateCookie *http.Cookie) *http.Request {
+	t.Helper()
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	res, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("could not reach the mock provider: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("expected the provider to redirect, but got %v", res.StatusCode)
+	}
+
+	callback, _ := url.Parse(res.Header.Get("Location"))
+	req, _ := http.NewRequest(http.MethodGet, callback.RequestURI(), bytes.NewBuffer(nil))
+	if stateCookie != nil {
+		req.AddCookie(stateCookie)
+	}
+	return req
+}
+
+// signs in through the identity provider and returns the callback response
+func oidcLogin(t *testing.T, router http.Handler) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/auth/oidc/login", bytes.NewBuffer(nil))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusFound {
+		t.Fatalf("expected to get 302, but got %v: %v", rr.Code, rr.Body.String())
+	}
+
+	req = authorize(t, rr.Header().Get("Location"), rr.Result().Cookies()[0])
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func oidcLoginData(t *testing.T, rr *httptest.ResponseRecorder) map[string]string {
+	t.Helper()
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected to get 200, but got %v: %v", rr.Code, rr.Body.String())
+	}
+	response := struct {
+		Data map[string]string `json:"data"`
+	}{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("could not Unmarshal Json, %v", err)
+	}
+	return response.Data
+}
+
+func TestOIDCLoginCreatesUser(t *testing.T) {
+	cfg := testConfig()
+	provider := useMockProvider(t, &cfg)
+	router := routes.MyHandler(cfg)
+	provider.SetUser(oidctest.User{
+		Subject:           "oidc-subject-1",
+		Email:             "oidcnew@example.com",
+		EmailVerified:     true,
+		PreferredUsername: "oidc_new",
+		GivenName:         "Oidc",
+		FamilyName:        "Newuser",
+	})
+
+	data := oidcLoginData(t, oidcLogin(t, router))
+	if data["username"] != "oidcnew" {
+		t.Fatalf("expected username oidcnew, but got %v", data["username"])
+	}
+
+	user := map[string]any{}
+	rr := doJSON(t, router, http.MethodGet, "/api/v1/users", data["access_token"], nil, &user)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected to get 200, but got %v", rr.Code)
+	}
+	if user["email"] != "oidcnew@example.com" || user["email_verified"] != true {
+		t.Fatalf("expected the verified email from the provider, but got %v", user)
+	}
+
+	// signing in again uses the linked account
+	data = oidcLoginData(t, oidcLogin(t, router))
+	if data["username"] != "oidcnew" {
+		t.Fatalf("expected username oidcnew, but got %v", data["username"])
+	}
+
+	// users created by the provider have no password to log in with
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/auth/login", "", map[string]string{"username": "oidcnew", "password": ""}, nil)
+	if rr.Code == http.StatusOK {
+		t.Fatal("expected password login to fail")
+	}
+
+	// until they set one, without a current password to give
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/users/password", data["access_token"], map[string]string{"new_password": "Oidcpassword1234#"}, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected to set a first password, but got %v: %v", rr.Code, rr.Body.String())
+	}
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/auth/login", "", map[string]string{"username": "oidcnew", "password": "Oidcpassword1234#"}, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected to log in with the new password, but got %v", rr.Code)
+	}
+}
+
+func TestOIDCUsernameFallback(t *testing.T) {
+	cfg := testConfig()
+	provider := useMockProvider(t, &cfg)
+	router := routes.MyHandler(cfg)
+	registerAndLogin(t, router, "4242", "digits@gmail.com")
+
+	// a taken name with nothing left once its digits are dropped falls back to user
+	provider.SetUser(oidctest.User{
+		Subject:           "oidc-subject-digits",
+		Email:             "oidcdigits@example.com",
+		PreferredUsername: "4242",
+		GivenName:         "Oidc",
+		FamilyName:        "Digits",
+	})
+	data := oidcLoginData(t, oidcLogin(t, router))
+	if !strings.HasPrefix(data["username"], "user") || schema.ValidateUsername(data["username"]) != nil {
+		t.Fatalf("expected a valid username starting with user, but got %q", data["username"])
+	}
+}
+
+func TestOIDCLoginLinksVerifiedEmail(t *testing.T) {
+	cfg := testConfig()
+	provider := useMockProvider(t, &cfg)
+	router := routes.MyHandler(cfg)
+
+	registerAndLogin(t, router, "oidclocal", "oidclocal@gmail.com")
+	msg, _ := testMailer.Last("oidclocal@gmail.com")
+	req, _ := http.NewRequest(http.MethodGet, verifyLinkRegex.FindString(msg.Body), bytes.NewBuffer(nil))
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	provider.SetUser(oidctest.User{
+		Subject:       "oidc-subject-2",
+		Email:         "OIDCLocal@gmail.com",
+		EmailVerified: true,
+	})
+
+	data := oidcLoginData(t, oidcLogin(t, router))
+	if data["username"] != "oidclocal" {
+		t.Fatalf("expected to sign in as oidclocal, but got %v", data["username"])
+	}
+
+	identities := []map[string]any{}
+	rr := doJSON(t, router, http.MethodGet, "/api/v1/users/identities", data["access_token"], nil, &identities)
+	if rr.Code != http.StatusOK || len(identities) != 1 || identities[0]["subject"] != "oidc-subject-2" {
+		t.Fatalf("expected the linked identity, but got %v: %v", rr.Code, rr.Body.String())
+	}
+}
+
+func TestOIDCLinkIdentity(t *testing.T) {
+	cfg := testConfig()
+	provider := useMockProvider(t, &cfg)
+	router := routes.MyHandler(cfg)
+	provider.SetUser(oidctest.User{
+		Subject: "oidc-subject-3",
+		Email:   "someone.else@example.com",
+	})
+
+	token := registerAndLogin(t, router, "oidclinker", "oidclinker@gmail.com")
+
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/users/identities/oidc", bytes.NewBuffer(nil))
+	req.Header.Add("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	data := oidcLoginData(t, rr)
+
+	rr2 := httptest.NewRecorder()
+	router.ServeHTTP(rr2, authorize(t, data["authorization_url"], rr.Result().Cookies()[0]))
+	if rr2.Code != http.StatusOK {
+		t.Fatalf("expected to get 200, but got %v: %v", rr2.Code, rr2.Body.String())
+	}
+
+	// the unverified email did not matter, the identity now signs in as the linker
+	data = oidcLoginData(t, oidcLogin(t, router))
+	if data["username"] != "oidclinker" {
+		t.Fatalf("expected to sign in as oidclinker, but got %v", data["username"])
+	}
+}
+
+func TestOIDCCallbackRequiresState(t *testing.T) {
+	cfg := testConfig()
+	useMockProvider(t, &cfg)
+	router := routes.MyHandler(cfg)
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/auth/oidc/login", bytes.NewBuffer(nil))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	// a callback without the browser's state cookie is rejected
+	callback := authorize(t, rr.Header().Get("Location"), nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, callback)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected to get 400, but got %v", rr.Code)
+	}
+}
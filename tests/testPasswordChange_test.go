@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/johnson-oragui/golang-todo-api/routes"
+)
+
+func TestChangePassword(t *testing.T) {
+	router := routes.MyHandler(testConfig())
+
+	oldToken := registerAndLogin(t, router, "pwuser", "pwuser@gmail.com")
+
+	created := map[string]any{}
+	rr := doJSON(t, router, http.MethodPost, "/api/v1/users/tokens", oldToken, map[string]any{"name": "ci", "scopes": []string{"todos:read"}}, &created)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected to get 201, but got %v", rr.Code)
+	}
+	pat := created["token"].(string)
+
+	// the profile update no longer changes passwords
+	rr = doJSON(t, router, http.MethodPut, "/api/v1/users", oldToken, map[string]string{"password": "Changed1234#"}, nil)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected to get 400, but got %v", rr.Code)
+	}
+
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/users/password", oldToken, map[string]string{
+		"current_password": "Wrongpassword1#",
+		"new_password":     "Changed1234#",
+	}, nil)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected to get 403 with a wrong current password, but got %v", rr.Code)
+	}
+
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/users/password", oldToken, map[string]string{
+		"current_password": "Testuser1234#",
+		"new_password":     "short",
+	}, nil)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected to get 400 with a weak new password, but got %v", rr.Code)
+	}
+
+	tokens := map[string]string{}
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/users/password", oldToken, map[string]string{
+		"current_password": "Testuser1234#",
+		"new_password":     "Changed1234#",
+	}, &tokens)
+	if rr.Code != http.StatusOK || tokens["access_token"] == "" {
+		t.Fatalf("expected to get 200 and a new token, but got %v: %v", rr.Code, rr.Body.String())
+	}
+
+	for name, token := range map[string]string{"old session": oldToken, "personal access token": pat} {
+		rr = doJSON(t, router, http.MethodGet, "/api/v1/users/todos", token, nil, nil)
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("expected the %v to be revoked, but got %v", name, rr.Code)
+		}
+	}
+
+	rr = doJSON(t, router, http.MethodGet, "/api/v1/users", tokens["access_token"], nil, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected the new token to work, but got %v", rr.Code)
+	}
+
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/auth/login", "", loginFor("pwuser"), nil)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the old password to be rejected, but got %v", rr.Code)
+	}
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/auth/login", "", map[string]string{"username": "pwuser", "password": "Changed1234#"}, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected to log in with the new password, but got %v", rr.Code)
+	}
+}
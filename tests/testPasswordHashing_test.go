@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/johnson-oragui/golang-todo-api/auth"
+	"github.com/johnson-oragui/golang-todo-api/routes"
+	"github.com/johnson-oragui/golang-todo-api/schema"
+)
+
+func TestComparePasswords(t *testing.T) {
+	defer func(config auth.PasswordHashConfig) { auth.PasswordHashing = config }(auth.PasswordHashing)
+
+	for _, algorithm := range []string{auth.AlgorithmBcrypt, auth.AlgorithmArgon2id} {
+		auth.PasswordHashing.Algorithm = algorithm
+
+		hash, err := auth.HashPassword(context.Background(), "Testuser1234#")
+		if err != nil {
+			t.Fatalf("could not hash with %v, %v", algorithm, err)
+		}
+		if err := auth.ComparePasswords(context.Background(), "Testuser1234#", hash); err != nil {
+			t.Fatalf("expected %v hash to match, but got %v", algorithm, err)
+		}
+		if err := auth.ComparePasswords(context.Background(), "Testuser1234", hash); err == nil {
+			t.Fatalf("expected %v hash to not match a different password", algorithm)
+		}
+		if auth.NeedsRehash(hash) {
+			t.Fatalf("expected a fresh %v hash to not need rehashing", algorithm)
+		}
+	}
+}
+
+func TestMalformedArgon2idHashes(t *testing.T) {
+	for name, hash := range map[string]string{
+		"no passes":     "$argon2id$v=19$m=19456,t=0,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U",
+		"no threads":    "$argon2id$v=19$m=19456,t=2,p=0$c2FsdHNhbHRzYWx0c2FsdA$a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U",
+		"tiny memory":   "$argon2id$v=19$m=7,t=2,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U",
+		"empty salt":    "$argon2id$v=19$m=19456,t=2,p=1$$a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U",
+		"empty key":     "$argon2id$v=19$m=19456,t=2,p=1$c2FsdHNhbHRzYWx0c2FsdA$",
+		"wrong version": "$argon2id$v=16$m=19456,t=2,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U",
+	} {
+		if err := auth.ComparePasswords(context.Background(), "Testuser1234#", hash); !errors.Is(err, auth.ErrUnknownHashFormat) {
+			t.Fatalf("expected a hash with %v to be rejected as malformed, but got %v", name, err)
+		}
+	}
+}
+
+func TestRehashOnLogin(t *testing.T) {
+	defer func(config auth.PasswordHashConfig) { auth.PasswordHashing = config }(auth.PasswordHashing)
+
+	router := routes.MyHandler(testConfig())
+
+	// store a bcrypt hash, as created before argon2id was introduced
+	auth.PasswordHashing.Algorithm = auth.AlgorithmBcrypt
+	auth.PasswordHashing.BcryptCost = 4
+	registerAndLogin(t, router, "rehashuser", "rehashuser@gmail.com")
+
+	if !strings.HasPrefix(schema.Database.Users["rehashuser"].Password, "$2a$") {
+		t.Fatalf("expected a bcrypt hash, but got %v", schema.Database.Users["rehashuser"].Password)
+	}
+
+	auth.PasswordHashing.Algorithm = auth.AlgorithmArgon2id
+	login(t, router, "rehashuser")
+
+	stored := schema.Database.Users["rehashuser"].Password
+	if !strings.HasPrefix(stored, "$argon2id$v=19$") {
+		t.Fatalf("expected the hash to be upgraded to argon2id, but got %v", stored)
+	}
+
+	// the upgraded hash keeps working
+	login(t, router, "rehashuser")
+	if schema.Database.Users["rehashuser"].Password != stored {
+		t.Fatal("expected an up to date hash to be left alone")
+	}
+}
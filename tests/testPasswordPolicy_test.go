@@ -0,0 +1,68 @@
+package tests
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/johnson-oragui/golang-todo-api/utils"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	valid := []string{
+		"Testuser1234#",
+		"Str0ng~Pa55word",              // any symbol counts, not just @#_-
+		"correct horse battery staple", // long passphrases skip the character class rule
+		"Ünïcödé-Pässwörd-9",
+	}
+	for _, password := range valid {
+		if err := utils.ValidatePassword(password); err != nil {
+			t.Fatalf("expected %q to be valid, but got %v", password, err)
+		}
+	}
+
+	invalid := []string{
+		"Ab1#",                 // too short
+		"lowercaseonly",        // one class, shorter than a passphrase
+		"aaaaaaaaaaaaaaaaaaaa", // predictable
+		"Abcdefgh12345678",     // sequences
+		"P@ssw0rd123",          // breached
+		"Welcome@1",            // breached
+		strings.Repeat("Ab1#xyz9", 20),
+	}
+	for _, password := range invalid {
+		if err := utils.ValidatePassword(password); err == nil {
+			t.Fatalf("expected %q to be rejected", password)
+		}
+	}
+}
+
+func TestLoadBreachedPasswords(t *testing.T) {
+	hashed := "Zebra-Crossing-77"
+	sum := sha1.Sum([]byte(hashed))
+
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	content := "# extra list\nZebra-Crossing-99\n" + strings.ToUpper(hex.EncodeToString(sum[:])) + ":42\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, password := range []string{"Zebra-Crossing-99", hashed} {
+		if err := utils.ValidatePassword(password); err != nil {
+			t.Fatalf("expected %q to be valid before loading the list, but got %v", password, err)
+		}
+	}
+
+	if err := utils.LoadBreachedPasswords(path); err != nil {
+		t.Fatalf("could not load breached passwords, %v", err)
+	}
+
+	for _, password := range []string{"Zebra-Crossing-99", "zebra-crossing-99", hashed} {
+		if err := utils.ValidatePassword(password); err == nil {
+			t.Fatalf("expected %q to be rejected after loading the list", password)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/johnson-oragui/golang-todo-api/config"
+	"github.com/johnson-oragui/golang-todo-api/ratelimit"
+	"github.com/johnson-oragui/golang-todo-api/routes"
+)
+
+// builds a router with limits, each router keeps buckets of its own
+func rateLimitedRouter(limits config.RateLimit) http.Handler {
+	cfg := testConfig()
+	cfg.RateLimit = limits
+	return routes.MyHandler(cfg)
+}
+
+func TestRateLimitPerIP(t *testing.T) {
+	router := rateLimitedRouter(config.RateLimit{
+		Default: "100/1m",
+		Routes:  []string{"/api/v1/auth/register=2/1h"},
+	})
+
+	register := func(username, remoteAddr string) *httptest.ResponseRecorder {
+		payload, _ := json.Marshal(registerPayloadFor(username, username+"@gmail.com"))
+		req, _ := http.NewRequest(http.MethodPost, "/api/v1/auth/register", bytes.NewBuffer(payload))
+		req.Header.Add("Content-Type", "application/json")
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := register("ratelimitone", "198.51.100.7:4000")
+	if rr.Code != http.StatusCreated || rr.Header().Get("RateLimit-Limit") != "2" || rr.Header().Get("RateLimit-Remaining") != "1" {
+		t.Fatalf("expected 201 with one request left, but got %v: %v", rr.Code, rr.Header())
+	}
+	register("ratelimittwo", "198.51.100.7:4001")
+
+	rr = register("ratelimitthree", "198.51.100.7:4002")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "1800" || rr.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("expected 429 with a Retry-After of half an hour, but got %v: %v", rr.Code, rr.Header())
+	}
+
+	// other clients and other routes have buckets of their own
+	if rr = register("ratelimitthree", "203.0.113.9:4000"); rr.Code != http.StatusCreated {
+		t.Fatalf("expected another address to register, but got %v", rr.Code)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/about", bytes.NewBuffer(nil))
+	req.RemoteAddr = "198.51.100.7:4003"
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("RateLimit-Limit") != "100" {
+		t.Fatalf("expected the default limit on another route, but got %v: %v", rr.Code, rr.Header())
+	}
+}
+
+func TestRateLimitPerUser(t *testing.T) {
+	router := rateLimitedRouter(config.RateLimit{
+		Default: "100/1m",
+		Routes:  []string{"/api/v1/users/todos=3/1m"},
+	})
+
+	first := registerAndLogin(t, router, "ratelimituser", "ratelimituser@gmail.com")
+	second := registerAndLogin(t, router, "ratelimitother", "ratelimitother@gmail.com")
+
+	// GET and POST share the route's bucket
+	doJSON(t, router, http.MethodPost, "/api/v1/users/todos", first, todoOnePayload, nil)
+	doJSON(t, router, http.MethodGet, "/api/v1/users/todos", first, nil, nil)
+	doJSON(t, router, http.MethodGet, "/api/v1/users/todos", first, nil, nil)
+	rr := doJSON(t, router, http.MethodGet, "/api/v1/users/todos", first, nil, nil)
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "20" {
+		t.Fatalf("expected 429 with a Retry-After of 20 seconds, but got %v: %v", rr.Code, rr.Header())
+	}
+
+	// users on the same address are limited separately
+	if rr = doJSON(t, router, http.MethodPost, "/api/v1/users/todos", second, todoOnePayload, nil); rr.Code != http.StatusCreated {
+		t.Fatalf("expected another user to be allowed, but got %v", rr.Code)
+	}
+}
+
+func TestRateLimitBeforeAuth(t *testing.T) {
+	router := rateLimitedRouter(config.RateLimit{
+		Default: "100/1m",
+		IP:      "3/1m",
+	})
+
+	guess := func(path, remoteAddr string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodGet, path, bytes.NewBuffer(nil))
+		req.Header.Set("Authorization", "Bearer not-a-token")
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// bad tokens are throttled per address, across the routes needing a token
+	for _, path := range []string{"/api/v1/users", "/api/v1/users/todos", "/api/v1/users/tokens"} {
+		if rr := guess(path, "198.51.100.20:4000"); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("expected 401 for a bad token, but got %v", rr.Code)
+		}
+	}
+	if rr := guess("/api/v1/users", "198.51.100.20:4001"); rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 once the address used up its limit, but got %v", rr.Code)
+	}
+	if rr := guess("/api/v1/users", "203.0.113.20:4000"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected another address to reach the token check, but got %v", rr.Code)
+	}
+}
+
+func TestTokenBucketRefills(t *testing.T) {
+	store := ratelimit.NewMemoryStore()
+	limit := ratelimit.Limit{Requests: 2, Per: time.Minute}
+	now := time.Now()
+
+	for i, expected := range []bool{true, true, false} {
+		result, _ := store.Take(context.Background(), "key", limit, now)
+		if result.Allowed != expected {
+			t.Fatalf("expected request %v allowed to be %v, but got %v", i, expected, result)
+		}
+	}
+
+	// a token is back after Per/Requests
+	if result, _ := store.Take(context.Background(), "key", limit, now.Add(30*time.Second)); !result.Allowed || result.Remaining != 0 {
+		t.Fatalf("expected a refilled token, but got %v", result)
+	}
+	if result, _ := store.Take(context.Background(), "key", limit, now.Add(10*time.Minute)); !result.Allowed || result.Remaining != 1 {
+		t.Fatalf("expected a full bucket, but got %v", result)
+	}
+}
@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/johnson-oragui/golang-todo-api/metrics"
+	"github.com/johnson-oragui/golang-todo-api/middleware"
+	"github.com/johnson-oragui/golang-todo-api/routes"
+)
+
+func TestRecoverFromPanic(t *testing.T) {
+	router := mux.NewRouter()
+	router.HandleFunc("/panic", func(w http.ResponseWriter, req *http.Request) {
+		var claims map[string]any
+		claims["sub"] = "nobody"
+	})
+	handler := middleware.RequestIDMiddleware(middleware.LoggingMiddleware(middleware.RecoverMiddleware(router, middleware.MetricsMiddleware(router))))
+	logs := captureLogs(t)
+	panics := metrics.Panics.WithLabelValues("/panic")
+	failures := metrics.HTTPRequests.WithLabelValues(http.MethodGet, "/panic", "500")
+	before, failuresBefore := testutil.ToFloat64(panics), testutil.ToFloat64(failures)
+
+	req, _ := http.NewRequest(http.MethodGet, "/panic", bytes.NewBuffer(nil))
+	req.Header.Add("X-Request-ID", "crash-report-1")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusInternalServerError || rr.Header().Get("Content-Type") != "application/problem+json" {
+		t.Fatalf("expected a problem+json 500, but got %v: %v", rr.Code, rr.Body.String())
+	}
+	problem := middleware.Problem{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &problem); err != nil || problem.RequestID != "crash-report-1" {
+		t.Fatalf("expected the request ID in the error body, but got %v", rr.Body.String())
+	}
+	if strings.Contains(rr.Body.String(), "nil map") {
+		t.Fatalf("expected the panic to stay out of the response, but got %v", rr.Body.String())
+	}
+
+	if got := testutil.ToFloat64(panics) - before; got != 1 {
+		t.Fatalf("expected the panic to be counted once, but got %v", got)
+	}
+	if got := testutil.ToFloat64(failures) - failuresBefore; got != 1 {
+		t.Fatalf("expected the request to be counted as a 500, but got %v", got)
+	}
+
+	// the crash report and the access log both carry the request ID
+	entries := logEntries(t, logs)
+	if len(entries) != 2 {
+		t.Fatalf("expected two log entries, but got %v", entries)
+	}
+	crash, access := entries[0], entries[1]
+	stack, _ := crash["stack"].(string)
+	if crash["request_id"] != "crash-report-1" || !strings.Contains(crash["panic"].(string), "nil map") || !strings.Contains(stack, "testRecover_test.go") {
+		t.Fatalf("expected the panic and its stack in the log, but got %v", crash)
+	}
+	if access["request_id"] != "crash-report-1" || access["status"] != float64(http.StatusInternalServerError) {
+		t.Fatalf("expected the access log to record the 500, but got %v", access)
+	}
+}
+
+func TestMalformedTokenRejected(t *testing.T) {
+	router := routes.MyHandler(testConfig())
+
+	for _, token := range []string{"not-a-token", "a.b.c", ""} {
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/users/todos", bytes.NewBuffer(nil))
+		req.Header.Add("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("expected to get 401 for %q, but got %v: %v", token, rr.Code, rr.Body.String())
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/johnson-oragui/golang-todo-api/middleware"
+	"github.com/johnson-oragui/golang-todo-api/routes"
+)
+
+func TestRequestIDInResponsesAndLogs(t *testing.T) {
+	router := routes.MyHandler(testConfig())
+	logs := captureLogs(t)
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/users/todos/1", bytes.NewBuffer(nil))
+	req.Header.Add("X-Request-ID", "support-ticket-42")
+	req.Header.Add("Authorization", "Bearer tdp_unknown")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized || rr.Header().Get("X-Request-ID") != "support-ticket-42" {
+		t.Fatalf("expected a 401 echoing the request ID, but got %v: %v", rr.Code, rr.Header())
+	}
+
+	problem := middleware.Problem{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("expected a problem+json body, but got %v", rr.Body.String())
+	}
+	if rr.Header().Get("Content-Type") != "application/problem+json" || problem.Status != http.StatusUnauthorized || problem.RequestID != "support-ticket-42" {
+		t.Fatalf("expected the request ID in the error body, but got %v", problem)
+	}
+
+	// the handler's own log line and the access log can both be found by the ID
+	entries := logEntries(t, logs)
+	if len(entries) != 2 {
+		t.Fatalf("expected two log entries, but got %v", entries)
+	}
+	for _, entry := range entries {
+		if entry["request_id"] != "support-ticket-42" {
+			t.Fatalf("expected every entry to carry the request ID, but got %v", entry)
+		}
+	}
+}
+
+func TestRequestIDGenerated(t *testing.T) {
+	router := routes.MyHandler(testConfig())
+
+	ids := map[string]bool{}
+	for _, sent := range []string{"", "", "bad id\nwith a newline", strings.Repeat("a", 200)} {
+		req, _ := http.NewRequest(http.MethodGet, "/no-such-page", bytes.NewBuffer(nil))
+		if sent != "" {
+			req.Header.Add("X-Request-ID", sent)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		requestID := rr.Header().Get("X-Request-ID")
+		if requestID == "" || requestID == sent || ids[requestID] {
+			t.Fatalf("expected a new request ID in place of %q, but got %q", sent, requestID)
+		}
+		ids[requestID] = true
+
+		problem := middleware.Problem{}
+		json.Unmarshal(rr.Body.Bytes(), &problem)
+		if rr.Code != http.StatusNotFound || problem.RequestID != requestID {
+			t.Fatalf("expected a 404 carrying %v, but got %v: %v", requestID, rr.Code, rr.Body.String())
+		}
+	}
+}
@@ -40,7 +40,7 @@ var todoOnePayload map[string]any = map[string]any{
 }
 
 func TestRegister(t *testing.T) {
-	router := routes.MyHandler()
+	router := routes.MyHandler(testConfig())
 
 	// register user
 
@@ -83,7 +83,7 @@ func TestRegister(t *testing.T) {
 }
 
 func TestLogin(t *testing.T) {
-	router := routes.MyHandler()
+	router := routes.MyHandler(testConfig())
 
 	payload, err := json.Marshal(loginPayload)
 	if err != nil {
@@ -124,7 +124,7 @@ func TestLogin(t *testing.T) {
 
 func TestGetUser(t *testing.T) {
 	// setup router
-	router := routes.MyHandler()
+	router := routes.MyHandler(testConfig())
 
 	bearer := fmt.Sprintf("Bearer %v", accessToken)
 
@@ -162,7 +162,7 @@ func TestGetUser(t *testing.T) {
 }
 
 func TestUpdateUser(t *testing.T) {
-	router := routes.MyHandler()
+	router := routes.MyHandler(testConfig())
 
 	userUpdateInput := map[string]string{
 		"first_name": "testusergreat",
@@ -208,7 +208,7 @@ func TestUpdateUser(t *testing.T) {
 }
 
 func TestDeleteUser(t *testing.T) {
-	router := routes.MyHandler()
+	router := routes.MyHandler(testConfig())
 
 	req, _ := http.NewRequest("DELETE", "/api/v1/users", bytes.NewReader(nil))
 	req.Header.Add("Content-Type", "application/json")
@@ -227,7 +227,7 @@ func TestDeleteUser(t *testing.T) {
 
 func TestGetUserNotFound(t *testing.T) {
 	// setup router
-	router := routes.MyHandler()
+	router := routes.MyHandler(testConfig())
 
 	bearer := fmt.Sprintf("Bearer %v", accessToken)
 
@@ -239,8 +239,9 @@ func TestGetUserNotFound(t *testing.T) {
 
 	router.ServeHTTP(responseRecorder, req)
 
-	if responseRecorder.Code != http.StatusForbidden {
-		t.Fatalf("expected to get 403, but got %v", responseRecorder.Code)
+	// deleting the account revoked its tokens
+	if responseRecorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected to get 401, but got %v", responseRecorder.Code)
 	}
 
 	userResPayload := schema.UserSchemaOutput{}
@@ -254,7 +255,7 @@ func TestGetUserNotFound(t *testing.T) {
 
 func TestGetUserWithoutAuthBearer(t *testing.T) {
 	// setup router
-	router := routes.MyHandler()
+	router := routes.MyHandler(testConfig())
 
 	req, _ := http.NewRequest(http.MethodGet, "/api/v1/users", bytes.NewBuffer(nil))
 	req.Header.Add("Content-Type", "application/json")
@@ -269,8 +270,18 @@ func TestGetUserWithoutAuthBearer(t *testing.T) {
 
 }
 
+func TestUsersMethodNotAllowed(t *testing.T) {
+	router := routes.MyHandler(testConfig())
+	token := registerAndLogin(t, router, "methoduser", "methoduser@example.com")
+
+	rr := doJSON(t, router, http.MethodPatch, "/api/v1/users", token, nil, nil)
+	if rr.Code != http.StatusMethodNotAllowed || rr.Header().Get("Content-Type") != "application/problem+json" {
+		t.Fatalf("expected a 405 problem, but got %v with %v", rr.Code, rr.Header().Get("Content-Type"))
+	}
+}
+
 func TestCreateTodo(t *testing.T) {
-	router := routes.MyHandler()
+	router := routes.MyHandler(testConfig())
 
 	// re-create user
 	payload, _ := json.Marshal(registerPayload)
@@ -292,6 +303,9 @@ func TestCreateTodo(t *testing.T) {
 		t.Fatalf("expected to get 201, but got %v", rr.Code)
 	}
 
+	// tokens of the deleted account do not carry over to the new one
+	accessToken = login(t, router, "testuser")
+
 	// create todo
 
 	payload, err = json.Marshal(todoOnePayload)
@@ -328,7 +342,7 @@ func TestCreateTodo(t *testing.T) {
 }
 
 func TestGetTodo(t *testing.T) {
-	router := routes.MyHandler()
+	router := routes.MyHandler(testConfig())
 
 	req, _ := http.NewRequest(http.MethodGet, "/api/v1/users/todos/1", bytes.NewBuffer(nil))
 	req.Header.Add("Content-Type", "application/json")
@@ -356,7 +370,7 @@ func TestGetTodo(t *testing.T) {
 }
 
 func TestGetTodos(t *testing.T) {
-	router := routes.MyHandler()
+	router := routes.MyHandler(testConfig())
 
 	req, _ := http.NewRequest(http.MethodGet, "/api/v1/users/todos", bytes.NewBuffer(nil))
 	req.Header.Add("Content-Type", "application/json")
@@ -397,7 +411,7 @@ func TestGetTodos(t *testing.T) {
 }
 
 func TestUpdateTodo(t *testing.T) {
-	router := routes.MyHandler()
+	router := routes.MyHandler(testConfig())
 	newPayload := todoOnePayload
 	newPayload["todo"] = "Must be"
 
@@ -440,7 +454,7 @@ func TestUpdateTodo(t *testing.T) {
 }
 
 func TestDeleteTodo(t *testing.T) {
-	router := routes.MyHandler()
+	router := routes.MyHandler(testConfig())
 
 	req, _ := http.NewRequest("DELETE", "/api/v1/users/todos/1", bytes.NewBuffer(nil))
 	req.Header.Add("Content-type", "application/json")
@@ -457,7 +471,7 @@ func TestDeleteTodo(t *testing.T) {
 }
 
 func TestGetTodoNotFound(t *testing.T) {
-	router := routes.MyHandler()
+	router := routes.MyHandler(testConfig())
 
 	req, _ := http.NewRequest(http.MethodGet, "/api/v1/users/todos/1", bytes.NewBuffer(nil))
 	req.Header.Add("Content-Type", "application/json")
@@ -0,0 +1,269 @@
+package tests
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/johnson-oragui/golang-todo-api/middleware"
+	"github.com/johnson-oragui/golang-todo-api/routes"
+	"github.com/johnson-oragui/golang-todo-api/tlsconfig"
+)
+
+// a certificate and its key, issued by a test CA
+type testCertificate struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	certPEM     []byte
+	keyPEM      []byte
+}
+
+// issues a certificate for name signed by issuer, or a CA when issuer is nil
+func issueCertificate(t *testing.T, name string, issuer *testCertificate) *testCertificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate a key: %v", err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	parent, signer := template, key
+	if issuer == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		parent, signer = issuer.certificate, issuer.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatalf("could not create the certificate: %v", err)
+	}
+	certificate, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return &testCertificate{
+		certificate: certificate,
+		key:         key,
+		certPEM:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:      pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// writes data to path with a modification time in the future, so a rewrite
+// within the same clock tick is still seen as a change
+func writeFile(t *testing.T, path string, data []byte, modified time.Time) {
+	t.Helper()
+
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("could not write %v: %v", path, err)
+	}
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatalf("could not touch %v: %v", path, err)
+	}
+}
+
+// serves the API over TLS on a random port, as main does, and returns its URL
+func serveTLS(t *testing.T, options tlsconfig.Options) (string, *tlsconfig.Reloader) {
+	t.Helper()
+
+	tlsConfig, reloader, err := tlsconfig.New(options)
+	if err != nil {
+		t.Fatalf("could not set up TLS: %v", err)
+	}
+	server := &http.Server{Handler: routes.MyHandler(testConfig()), TLSConfig: tlsConfig}
+	if !options.HTTP2 {
+		server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	go server.ServeTLS(listener, "", "")
+	t.Cleanup(func() { server.Close() })
+
+	return "https://" + listener.Addr().String(), reloader
+}
+
+// a client trusting ca, presenting certificate when it is not nil, on a new connection every time
+func tlsClient(ca *testCertificate, certificate *testCertificate, maxVersion uint16) *http.Client {
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.certificate)
+	config := &tls.Config{RootCAs: roots, MaxVersion: maxVersion}
+	if certificate != nil {
+		config.Certificates = []tls.Certificate{{
+			Certificate: [][]byte{certificate.certificate.Raw},
+			PrivateKey:  certificate.key,
+		}}
+	}
+	return &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: config, ForceAttemptHTTP2: true, DisableKeepAlives: true},
+	}
+}
+
+// writes a server certificate signed by ca and returns options serving it
+func tlsOptions(t *testing.T, ca *testCertificate) tlsconfig.Options {
+	dir := t.TempDir()
+	server := issueCertificate(t, "first", ca)
+	options := tlsconfig.Options{
+		CertFile:     filepath.Join(dir, "server.crt"),
+		KeyFile:      filepath.Join(dir, "server.key"),
+		MinVersion:   "1.2",
+		CipherPolicy: tlsconfig.PolicyIntermediate,
+		HTTP2:        true,
+	}
+	writeFile(t, options.CertFile, server.certPEM, time.Now())
+	writeFile(t, options.KeyFile, server.keyPEM, time.Now())
+	return options
+}
+
+func TestTLSServesHTTP2(t *testing.T) {
+	ca := issueCertificate(t, "Test CA", nil)
+	options := tlsOptions(t, ca)
+
+	url, _ := serveTLS(t, options)
+	resp, err := tlsClient(ca, nil, 0).Get(url + "/healthz")
+	if err != nil {
+		t.Fatalf("expected the TLS request to succeed, but got %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ProtoMajor != 2 {
+		t.Fatalf("expected 200 over HTTP/2, but got %v over %v", resp.StatusCode, resp.Proto)
+	}
+
+	// TLS 1.2 clients are served the intermediate cipher suites
+	resp, err = tlsClient(ca, nil, tls.VersionTLS12).Get(url + "/healthz")
+	if err != nil {
+		t.Fatalf("expected a TLS 1.2 client to be served, but got %v", err)
+	}
+	resp.Body.Close()
+	if resp.TLS.Version != tls.VersionTLS12 {
+		t.Fatalf("expected TLS 1.2, but got %x", resp.TLS.Version)
+	}
+
+	// the modern policy turns TLS 1.2 clients away
+	options.CipherPolicy, options.MinVersion, options.HTTP2 = tlsconfig.PolicyModern, "1.3", false
+	url, _ = serveTLS(t, options)
+	if _, err := tlsClient(ca, nil, tls.VersionTLS12).Get(url + "/healthz"); err == nil {
+		t.Fatalf("expected a TLS 1.2 client to be refused by the modern policy")
+	}
+	resp, err = tlsClient(ca, nil, 0).Get(url + "/healthz")
+	if err != nil {
+		t.Fatalf("expected a TLS 1.3 client to be served, but got %v", err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 1 || resp.TLS.Version != tls.VersionTLS13 {
+		t.Fatalf("expected HTTP/1.1 over TLS 1.3 with HTTP/2 off, but got %v over %x", resp.Proto, resp.TLS.Version)
+	}
+}
+
+func TestTLSClientCertificates(t *testing.T) {
+	ca := issueCertificate(t, "Test CA", nil)
+	options := tlsOptions(t, ca)
+	options.ClientCAFile = filepath.Join(t.TempDir(), "clients.crt")
+	options.ClientAuth = tlsconfig.ClientAuthRequire
+	writeFile(t, options.ClientCAFile, ca.certPEM, time.Now())
+
+	url, _ := serveTLS(t, options)
+
+	if _, err := tlsClient(ca, nil, 0).Get(url + "/healthz"); err == nil {
+		t.Fatalf("expected a client without a certificate to be refused")
+	}
+	stranger := issueCertificate(t, "stranger", issueCertificate(t, "Other CA", nil))
+	if _, err := tlsClient(ca, stranger, 0).Get(url + "/healthz"); err == nil {
+		t.Fatalf("expected a certificate from another CA to be refused")
+	}
+
+	resp, err := tlsClient(ca, issueCertificate(t, "client", ca), 0).Get(url + "/healthz")
+	if err != nil {
+		t.Fatalf("expected a client with a certificate from the CA to be served, but got %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, but got %v", resp.StatusCode)
+	}
+}
+
+func TestTLSCertificateReload(t *testing.T) {
+	ca := issueCertificate(t, "Test CA", nil)
+	options := tlsOptions(t, ca)
+	url, reloader := serveTLS(t, options)
+
+	servedName := func() string {
+		t.Helper()
+		resp, err := tlsClient(ca, nil, 0).Get(url + "/healthz")
+		if err != nil {
+			t.Fatalf("expected the TLS request to succeed, but got %v", err)
+		}
+		resp.Body.Close()
+		return resp.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	if name := servedName(); name != "first" {
+		t.Fatalf("expected the first certificate, but got %v", name)
+	}
+
+	// a half written certificate is not loaded and the previous one stays in use
+	writeFile(t, options.CertFile, []byte("not a certificate"), time.Now().Add(time.Minute))
+	if err := reloader.Reload(); err == nil {
+		t.Fatalf("expected the broken certificate to be rejected")
+	}
+	if name := servedName(); name != "first" {
+		t.Fatalf("expected the first certificate to still be served, but got %v", name)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reloader.Watch(ctx, 10*time.Millisecond)
+
+	renewed := issueCertificate(t, "second", ca)
+	writeFile(t, options.KeyFile, renewed.keyPEM, time.Now().Add(2*time.Minute))
+	writeFile(t, options.CertFile, renewed.certPEM, time.Now().Add(2*time.Minute))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for servedName() != "second" {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the renewed certificate to be served after it changed on disk")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	for port, location := range map[string]string{
+		"8443": "https://todo.example.com:8443/api/v1/todos?page=2",
+		"443":  "https://todo.example.com/api/v1/todos?page=2",
+	} {
+		req := httptest.NewRequest(http.MethodPost, "http://todo.example.com:8080/api/v1/todos?page=2", nil)
+		rr := httptest.NewRecorder()
+		middleware.RedirectToHTTPS(port).ServeHTTP(rr, req)
+
+		// 308 keeps the method, so the POST is repeated over HTTPS
+		if rr.Code != http.StatusPermanentRedirect || rr.Header().Get("Location") != location {
+			t.Fatalf("expected a 308 to %v, but got %v to %v", location, rr.Code, rr.Header().Get("Location"))
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/johnson-oragui/golang-todo-api/auth"
+	"github.com/johnson-oragui/golang-todo-api/routes"
+	"github.com/johnson-oragui/golang-todo-api/schema"
+)
+
+// sends a JSON request and decodes the "data" field of the response
+func doJSON(t *testing.T, router http.Handler, method, path, token string, body any, data any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	payload, _ := json.Marshal(body)
+	req, _ := http.NewRequest(method, path, bytes.NewBuffer(payload))
+	req.Header.Add("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %v", token))
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if data != nil && rr.Code < 300 {
+		response := struct {
+			Data any `json:"data"`
+		}{Data: data}
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("could not Unmarshal Json, %v", err)
+		}
+	}
+	return rr
+}
+
+func TestTOTPLogin(t *testing.T) {
+	cfg := testConfig()
+	cfg.Auth.RecoveryCodes = 4
+	router := routes.MyHandler(cfg)
+
+	token := registerAndLogin(t, router, "totpuser", "totpuser@gmail.com")
+
+	enrollment := map[string]string{}
+	rr := doJSON(t, router, http.MethodPost, "/api/v1/users/2fa/totp", token, nil, &enrollment)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected to get 200, but got %v", rr.Code)
+	}
+	if enrollment["secret"] == "" || enrollment["qr_png"] == "" {
+		t.Fatalf("expected a secret and QR code, but got %v", enrollment)
+	}
+
+	now := time.Now()
+	code, _ := auth.TOTPCode(enrollment["secret"], now)
+
+	confirmation := map[string][]string{}
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/users/2fa/totp/confirm", token, map[string]string{"code": code}, &confirmation)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected to get 200, but got %v", rr.Code)
+	}
+	recoveryCodes := confirmation["recovery_codes"]
+	if len(recoveryCodes) != 4 {
+		t.Fatalf("expected the 4 configured recovery codes, but got %v", recoveryCodes)
+	}
+
+	// password login now returns a challenge instead of an access token
+	challenge := map[string]any{}
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/auth/login", "", loginFor("totpuser"), &challenge)
+	if rr.Code != http.StatusOK || challenge["mfa_required"] != true {
+		t.Fatalf("expected an mfa challenge, but got %v: %v", rr.Code, rr.Body.String())
+	}
+	mfaToken := challenge["mfa_token"].(string)
+
+	// the challenge token is not an access token
+	rr = doJSON(t, router, http.MethodGet, "/api/v1/users", mfaToken, nil, nil)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected to get 401 with an mfa token, but got %v", rr.Code)
+	}
+
+	// a code that was already used is rejected
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/auth/login/mfa", "", map[string]string{"mfa_token": mfaToken, "code": code}, nil)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected to get 401 on a replayed code, but got %v", rr.Code)
+	}
+
+	nextCode, _ := auth.TOTPCode(enrollment["secret"], now.Add(30*time.Second))
+	tokens := map[string]string{}
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/auth/login/mfa", "", map[string]string{"mfa_token": mfaToken, "code": nextCode}, &tokens)
+	if rr.Code != http.StatusOK || tokens["access_token"] == "" {
+		t.Fatalf("expected an access token, but got %v: %v", rr.Code, rr.Body.String())
+	}
+
+	// recovery codes work exactly once
+	recovery := map[string]string{"mfa_token": mfaToken, "recovery_code": recoveryCodes[0]}
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/auth/login/mfa", "", recovery, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected to get 200 with a recovery code, but got %v", rr.Code)
+	}
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/auth/login/mfa", "", recovery, nil)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected to get 401 on a reused recovery code, but got %v", rr.Code)
+	}
+
+	rr = doJSON(t, router, http.MethodDelete, "/api/v1/users/2fa/totp", tokens["access_token"], map[string]string{"recovery_code": recoveryCodes[1]}, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected to get 200 when disabling 2FA, but got %v", rr.Code)
+	}
+
+	login := map[string]any{}
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/auth/login", "", loginFor("totpuser"), &login)
+	if rr.Code != http.StatusOK || login["access_token"] == nil {
+		t.Fatalf("expected an access token after disabling 2FA, but got %v: %v", rr.Code, rr.Body.String())
+	}
+}
+
+func TestDisableTOTPBackoff(t *testing.T) {
+	router := routes.MyHandler(testConfig())
+
+	token := registerAndLogin(t, router, "totpguessed", "totpguessed@gmail.com")
+	enrollment := map[string]string{}
+	doJSON(t, router, http.MethodPost, "/api/v1/users/2fa/totp", token, nil, &enrollment)
+	code, _ := auth.TOTPCode(enrollment["secret"], time.Now())
+	doJSON(t, router, http.MethodPost, "/api/v1/users/2fa/totp/confirm", token, map[string]string{"code": code}, nil)
+
+	disable := func(code string) *httptest.ResponseRecorder {
+		payload, _ := json.Marshal(map[string]string{"code": code})
+		req, _ := http.NewRequest(http.MethodDelete, "/api/v1/users/2fa/totp", bytes.NewBuffer(payload))
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("Authorization", "Bearer "+token)
+		req.RemoteAddr = "198.51.100.30:4000"
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// a stolen session cannot keep guessing codes to remove 2FA
+	for i := 0; i < 4; i++ {
+		if rr := disable("000000"); rr.Code != http.StatusForbidden {
+			t.Fatalf("expected attempt %v to get 403, but got %v", i+1, rr.Code)
+		}
+	}
+	rr := disable(code)
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected to get 429 with a Retry-After, but got %v: %v", rr.Code, rr.Header())
+	}
+	if !schema.Database.Users["totpguessed"].TOTPEnabled {
+		t.Fatal("expected 2FA to stay enabled")
+	}
+}
+
+func loginFor(username string) map[string]string {
+	return map[string]string{
+		"username": username,
+		"password": "Testuser1234#",
+	}
+}
+
+func TestEnrollTOTPQRCode(t *testing.T) {
+	router := routes.MyHandler(testConfig())
+	token := registerAndLogin(t, router, "totpqruser", "totpqruser@gmail.com")
+
+	expected := map[string]string{
+		"image/png":                         "image/png",
+		"image/png, */*;q=0.8":              "image/png",
+		"application/json;q=0.5, image/png": "image/png",
+		"image/*":                           "image/png",
+		"application/json, image/png;q=0.5": "application/json",
+		"*/*":                               "application/json",
+		"application/cbor, image/png;q=0.9": "application/cbor",
+		"":                                  "application/json",
+	}
+	for accept, contentType := range expected {
+		req, _ := http.NewRequest(http.MethodPost, "/api/v1/users/2fa/totp", nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %v", token))
+		if accept != "" {
+			req.Header.Add("Accept", accept)
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Accept %q: expected to get 200, but got %v", accept, rr.Code)
+		}
+		if got := rr.Header().Get("Content-Type"); got != contentType {
+			t.Errorf("Accept %q: expected %v, but got %v", accept, contentType, got)
+		}
+		if contentType == "image/png" && !bytes.HasPrefix(rr.Body.Bytes(), []byte("\x89PNG")) {
+			t.Errorf("Accept %q: expected a PNG image", accept)
+		}
+	}
+}
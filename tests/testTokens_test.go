@@ -0,0 +1,79 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/johnson-oragui/golang-todo-api/routes"
+)
+
+func TestPersonalAccessTokens(t *testing.T) {
+	router := routes.MyHandler(testConfig())
+
+	token := registerAndLogin(t, router, "patuser", "patuser@gmail.com")
+
+	rr := doJSON(t, router, http.MethodPost, "/api/v1/users/todos", token, todoOnePayload, nil)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected to get 201, but got %v", rr.Code)
+	}
+
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/users/tokens", token, map[string]any{"name": "ci", "scopes": []string{"admin"}}, nil)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected to get 400 for an unknown scope, but got %v", rr.Code)
+	}
+
+	created := map[string]any{}
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/users/tokens", token, map[string]any{"name": "ci", "scopes": []string{"todos:read"}}, &created)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected to get 201, but got %v", rr.Code)
+	}
+	pat, _ := created["token"].(string)
+	if !strings.HasPrefix(pat, "tdp_") {
+		t.Fatalf("expected a personal access token, but got %v", created["token"])
+	}
+
+	rr = doJSON(t, router, http.MethodGet, "/api/v1/users/todos", pat, nil, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected to read todos with todos:read, but got %v", rr.Code)
+	}
+
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/users/todos", pat, todoOnePayload, nil)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected to get 403 creating a todo without todos:write, but got %v", rr.Code)
+	}
+
+	rr = doJSON(t, router, http.MethodGet, "/api/v1/users", pat, nil, nil)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected to get 403 reading the profile without profile:read, but got %v", rr.Code)
+	}
+
+	// tokens cannot be used to mint more tokens
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/users/tokens", pat, map[string]any{"name": "ci", "scopes": []string{"todos:write"}}, nil)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected to get 403 creating a token with a token, but got %v", rr.Code)
+	}
+
+	tokens := []map[string]any{}
+	rr = doJSON(t, router, http.MethodGet, "/api/v1/users/tokens", token, nil, &tokens)
+	if rr.Code != http.StatusOK || len(tokens) != 1 {
+		t.Fatalf("expected to list one token, but got %v: %v", rr.Code, rr.Body.String())
+	}
+	if strings.Contains(rr.Body.String(), pat) {
+		t.Fatal("expected the token to not be returned when listing")
+	}
+	if tokens[0]["last_used_at"] == nil {
+		t.Fatalf("expected the token to record when it was last used, but got %v", tokens[0])
+	}
+
+	rr = doJSON(t, router, http.MethodDelete, fmt.Sprintf("/api/v1/users/tokens/%v", tokens[0]["id"]), token, nil, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected to get 200 revoking the token, but got %v", rr.Code)
+	}
+
+	rr = doJSON(t, router, http.MethodGet, "/api/v1/users/todos", pat, nil, nil)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected to get 401 with a revoked token, but got %v", rr.Code)
+	}
+}
@@ -0,0 +1,92 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/johnson-oragui/golang-todo-api/routes"
+	"github.com/johnson-oragui/golang-todo-api/tracing"
+)
+
+// the fields of the spans written by the stdout exporter that the tests check
+type exportedSpan struct {
+	Name        string
+	SpanContext struct {
+		TraceID string
+	}
+}
+
+func TestTracingPropagation(t *testing.T) {
+	router := routes.MyHandler(testConfig())
+	token := registerAndLogin(t, router, "tracinguser", "tracinguser@gmail.com")
+	logs := captureLogs(t)
+
+	exported := &bytes.Buffer{}
+	shutdown, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:    tracing.ExporterStdout,
+		ServiceName: "todo-api-test",
+		Writer:      exported,
+	})
+	if err != nil {
+		t.Fatalf("could not set up tracing: %v", err)
+	}
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	payload, _ := json.Marshal(todoOnePayload)
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/users/todos", bytes.NewBuffer(payload))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Add("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected to get 201, but got %v: %v", rr.Code, rr.Body.String())
+	}
+
+	login(t, router, "tracinguser")
+
+	// flushes the spans to the buffer
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("could not shut down tracing: %v", err)
+	}
+
+	spans := map[string]string{}
+	decoder := json.NewDecoder(exported)
+	for {
+		span := exportedSpan{}
+		if err := decoder.Decode(&span); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatalf("could not decode the exported spans: %v", err)
+		}
+		spans[span.Name] = span.SpanContext.TraceID
+	}
+
+	// the todo request continues the caller's trace through every layer
+	for _, name := range []string{"POST /api/v1/users/todos", "JWTAuthMiddleware", "TodoRouter.HandleCreateTodo", "schema.FindTodos", "schema.SaveTodos"} {
+		if spans[name] != traceID {
+			t.Fatalf("expected a %v span in trace %v, but got %v", name, traceID, spans)
+		}
+	}
+
+	// the login starts a trace of its own
+	for _, name := range []string{"POST /api/v1/auth/login", "UserRouter.HandleLogin", "schema.FindUser", "auth.ComparePasswords"} {
+		if spans[name] == "" || spans[name] == traceID {
+			t.Fatalf("expected a %v span in a new trace, but got %v", name, spans)
+		}
+	}
+
+	if entries := logEntries(t, logs); len(entries) == 0 || entries[0]["trace_id"] != traceID {
+		t.Fatalf("expected the access log to carry the trace ID, but got %v", entries)
+	}
+}
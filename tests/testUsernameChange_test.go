@@ -0,0 +1,74 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/johnson-oragui/golang-todo-api/routes"
+)
+
+func TestChangeUsername(t *testing.T) {
+	router := routes.MyHandler(testConfig())
+
+	oldToken := registerAndLogin(t, router, "renameme", "renameme@gmail.com")
+
+	rr := doJSON(t, router, http.MethodPost, "/api/v1/users/todos", oldToken, map[string]any{"todo": "survive a rename"}, nil)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected to get 201, but got %v", rr.Code)
+	}
+
+	pat := map[string]any{}
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/users/tokens", oldToken, map[string]any{"name": "ci", "scopes": []string{"todos:read"}}, &pat)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected to get 201, but got %v", rr.Code)
+	}
+
+	// the generic profile update cannot rename
+	rr = doJSON(t, router, http.MethodPut, "/api/v1/users", oldToken, map[string]string{"username": "renamed"}, nil)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected to get 400, but got %v", rr.Code)
+	}
+
+	data := map[string]any{}
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/users/username", oldToken, map[string]string{"username": "renamed"}, &data)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected to get 200, but got %v: %v", rr.Code, rr.Body.String())
+	}
+	newToken := data["access_token"].(string)
+
+	rr = doJSON(t, router, http.MethodGet, "/api/v1/users", oldToken, nil, nil)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected to get 401 with a token for the old username, but got %v", rr.Code)
+	}
+
+	// todos and personal access tokens moved with the account
+	for _, token := range []string{newToken, pat["token"].(string)} {
+		todos := []map[string]any{}
+		rr = doJSON(t, router, http.MethodGet, "/api/v1/users/todos", token, nil, &todos)
+		if rr.Code != http.StatusOK || len(todos) != 1 {
+			t.Fatalf("expected the todo to move, but got %v: %v", rr.Code, rr.Body.String())
+		}
+	}
+	login(t, router, "renamed")
+
+	// the old name is reserved for its previous owner
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/auth/register", "", registerPayloadFor("RenameMe", "renameme2@gmail.com"), nil)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected to get 403 registering a reserved username, but got %v", rr.Code)
+	}
+
+	otherToken := registerAndLogin(t, router, "renamethief", "renamethief@gmail.com")
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/users/username", otherToken, map[string]string{"username": "renameme"}, nil)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected to get 403 taking a reserved username, but got %v", rr.Code)
+	}
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/users/username", otherToken, map[string]string{"username": "Renamed"}, nil)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected to get 403 taking a used username, but got %v", rr.Code)
+	}
+
+	rr = doJSON(t, router, http.MethodPost, "/api/v1/users/username", newToken, map[string]string{"username": "renameme"}, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected the previous owner to get the name back, but got %v: %v", rr.Code, rr.Body.String())
+	}
+}
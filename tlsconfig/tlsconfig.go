@@ -0,0 +1,244 @@
+// Package tlsconfig builds the TLS configuration of the server and reloads
+// its certificates when the files change on disk
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// cipher policies, named after the Mozilla server side TLS recommendations
+const (
+	// PolicyModern allows TLS 1.3 only, whose cipher suites are all strong
+	PolicyModern = "modern"
+	// PolicyIntermediate also allows TLS 1.2 with forward secret AEAD cipher suites
+	PolicyIntermediate = "intermediate"
+)
+
+// client certificate modes
+const (
+	ClientAuthNone     = "none"
+	ClientAuthOptional = "optional" // verified when the client sends one
+	ClientAuthRequire  = "require"
+)
+
+// TLS 1.2 cipher suites of PolicyIntermediate, the first is required by HTTP/2
+var intermediateCipherSuites = []uint16{
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// Options describes the TLS setup of the server
+type Options struct {
+	CertFile     string
+	KeyFile      string
+	MinVersion   string // 1.2 or 1.3
+	CipherPolicy string // modern or intermediate
+	ClientCAFile string // CA bundle client certificates are verified against
+	ClientAuth   string // none, optional or require
+	HTTP2        bool
+}
+
+// ParseMinVersion returns the TLS version named by version, 1.2 or 1.3
+func ParseMinVersion(version string) (uint16, error) {
+	switch version {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version %q, use 1.2 or 1.3", version)
+}
+
+// ParseClientAuth returns the client certificate policy named by mode
+func ParseClientAuth(mode string) (tls.ClientAuthType, error) {
+	switch mode {
+	case "", ClientAuthNone:
+		return tls.NoClientCert, nil
+	case ClientAuthOptional:
+		return tls.VerifyClientCertIfGiven, nil
+	case ClientAuthRequire:
+		return tls.RequireAndVerifyClientCert, nil
+	}
+	return 0, fmt.Errorf("unsupported client auth %q, use %v, %v or %v", mode, ClientAuthNone, ClientAuthOptional, ClientAuthRequire)
+}
+
+// New loads the certificate, and the client CA when there is one, and returns
+// a server configuration that always serves what the Reloader last loaded
+func New(options Options) (*tls.Config, *Reloader, error) {
+	minVersion, err := ParseMinVersion(options.MinVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+	clientAuth, err := ParseClientAuth(options.ClientAuth)
+	if err != nil {
+		return nil, nil, err
+	}
+	if clientAuth != tls.NoClientCert && options.ClientCAFile == "" {
+		return nil, nil, errors.New("verifying client certificates needs a client CA file")
+	}
+	switch options.CipherPolicy {
+	case PolicyModern:
+		// the TLS 1.3 cipher suites are not configurable and all strong
+		minVersion = tls.VersionTLS13
+	case PolicyIntermediate:
+	default:
+		return nil, nil, fmt.Errorf("unsupported cipher policy %q, use %v or %v", options.CipherPolicy, PolicyModern, PolicyIntermediate)
+	}
+
+	reloader := &Reloader{
+		certFile:     options.CertFile,
+		keyFile:      options.KeyFile,
+		clientCAFile: options.ClientCAFile,
+	}
+	if err := reloader.Reload(); err != nil {
+		return nil, nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion: minVersion,
+		ClientAuth: clientAuth,
+		NextProtos: []string{"http/1.1"},
+	}
+	if options.CipherPolicy == PolicyIntermediate {
+		config.CipherSuites = intermediateCipherSuites
+	}
+	if options.HTTP2 {
+		config.NextProtos = []string{"h2", "http/1.1"}
+	}
+
+	config.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		certificate, _ := reloader.current()
+		return certificate, nil
+	}
+	// each handshake gets the certificate and client CAs loaded last
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		certificate, clientCAs := reloader.current()
+		handshake := config.Clone()
+		handshake.GetConfigForClient = nil
+		handshake.Certificates = []tls.Certificate{*certificate}
+		handshake.ClientCAs = clientCAs
+		return handshake, nil
+	}
+
+	return config, reloader, nil
+}
+
+// Reloader keeps the certificate and client CAs loaded from disk current
+type Reloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	mu          sync.RWMutex
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+	versions    map[string]fileVersion
+}
+
+// identifies a version of a file, certificate managers replace files rather than edit them
+type fileVersion struct {
+	modified time.Time
+	size     int64
+}
+
+func (r *Reloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.certificate, r.clientCAs
+}
+
+func (r *Reloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.clientCAFile != "" {
+		files = append(files, r.clientCAFile)
+	}
+	return files
+}
+
+// Reload reads the files again. What was loaded before stays in use when they
+// cannot be read, such as while a certificate and its key are being replaced
+func (r *Reloader) Reload() error {
+	versions := map[string]fileVersion{}
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		versions[file] = fileVersion{modified: info.ModTime(), size: info.Size()}
+	}
+
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("could not load the TLS certificate: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if r.clientCAFile != "" {
+		pem, err := os.ReadFile(r.clientCAFile)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %v", r.clientCAFile)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.certificate = &certificate
+	r.clientCAs = clientCAs
+	r.versions = versions
+	return nil
+}
+
+// changed reports whether any file differs from the version last loaded
+func (r *Reloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		if (fileVersion{modified: info.ModTime(), size: info.Size()}) != r.versions[file] {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch reloads the files whenever they change, checking every interval until ctx is done
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				slog.Error("could not reload the TLS certificate, still serving the previous one", "error", err)
+				continue
+			}
+			slog.Info("reloaded the TLS certificate", "cert_file", r.certFile)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Package tracing sets up OpenTelemetry tracing and the spans the API records
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/johnson-oragui/golang-todo-api"
+
+// exporters Setup can send spans to
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	// ExporterConsole is the OpenTelemetry name of the stdout exporter
+	ExporterConsole = "console"
+)
+
+type Config struct {
+	Exporter    string    // none, otlp, stdout or console
+	ServiceName string    // reported as service.name
+	Writer      io.Writer // where the stdout exporter writes, os.Stdout when nil
+}
+
+func init() {
+	// W3C traceparent and baggage headers are honoured even when spans are not exported
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// Setup installs the global tracer provider. The OTLP exporter is configured
+// through the standard OTEL_EXPORTER_OTLP_* environment variables and defaults
+// to a collector on localhost:4318, and the sampler through OTEL_TRACES_SAMPLER.
+// The returned function flushes the pending spans and stops the provider
+func Setup(ctx context.Context, config Config) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch config.Exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		exporter, err = otlptracehttp.New(ctx)
+	case ExporterStdout, ExporterConsole:
+		options := []stdouttrace.Option{}
+		if config.Writer != nil {
+			options = append(options, stdouttrace.WithWriter(config.Writer))
+		}
+		exporter, err = stdouttrace.New(options...)
+	default:
+		return nil, fmt.Errorf("unsupported trace exporter %v", config.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(config.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the tracer of the API from the global tracer provider
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Start begins a span as a child of any span in ctx
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// Fail marks span as failed with err
+func Fail(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,217 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+type PasswordPolicyConfig struct {
+	MinLength        int     // minimum number of characters
+	MaxLength        int     // maximum number of characters
+	MinCharClasses   int     // of lowercase, uppercase, digits and symbols
+	PassphraseLength int     // passwords at least this long are exempt from MinCharClasses, 0 disables
+	MinEntropyBits   float64 // minimum estimated entropy, 0 disables
+	CheckBreached    bool    // reject passwords found in the breached password list
+}
+
+// PasswordPolicy is the policy new passwords are validated against
+var PasswordPolicy = PasswordPolicyConfig{
+	MinLength:        8,
+	MaxLength:        128,
+	MinCharClasses:   3,
+	PassphraseLength: 16,
+	MinEntropyBits:   40,
+	CheckBreached:    true,
+}
+
+//go:embed breached_passwords.txt
+var bundledBreachedPasswords string
+
+// breached passwords, stored lowercased, and SHA-1 hashes of breached passwords
+var breached = struct {
+	sync.RWMutex
+	passwords map[string]struct{}
+	sha1      map[string]struct{}
+}{
+	passwords: map[string]struct{}{},
+	sha1:      map[string]struct{}{},
+}
+
+func init() {
+	if err := loadBreachedPasswords(strings.NewReader(bundledBreachedPasswords)); err != nil {
+		panic(err)
+	}
+}
+
+// LoadBreachedPasswords adds the passwords in a file to the breached password list.
+// Lines are either plain passwords or upper case SHA-1 hashes as published by
+// Have I Been Pwned ("HASH" or "HASH:count"). Empty lines and lines starting with # are skipped.
+func LoadBreachedPasswords(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return loadBreachedPasswords(file)
+}
+
+func loadBreachedPasswords(r io.Reader) error {
+	breached.Lock()
+	defer breached.Unlock()
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if hash, _, _ := strings.Cut(line, ":"); isSHA1Hex(hash) {
+			breached.sha1[strings.ToUpper(hash)] = struct{}{}
+			continue
+		}
+		breached.passwords[strings.ToLower(line)] = struct{}{}
+	}
+	return scanner.Err()
+}
+
+func isSHA1Hex(s string) bool {
+	if len(s) != 40 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// IsBreachedPassword reports whether the password is on the breached password list
+func IsBreachedPassword(password string) bool {
+	breached.RLock()
+	defer breached.RUnlock()
+
+	if _, found := breached.passwords[strings.ToLower(password)]; found {
+		return true
+	}
+
+	sum := sha1.Sum([]byte(password))
+	_, found := breached.sha1[strings.ToUpper(hex.EncodeToString(sum[:]))]
+	return found
+}
+
+// character classes of a password
+type charClasses struct {
+	lower, upper, digit, symbol bool
+}
+
+func classify(password string) charClasses {
+	classes := charClasses{}
+	for _, c := range password {
+		switch {
+		case unicode.IsLower(c):
+			classes.lower = true
+		case unicode.IsUpper(c):
+			classes.upper = true
+		case unicode.IsDigit(c):
+			classes.digit = true
+		default:
+			classes.symbol = true
+		}
+	}
+	return classes
+}
+
+func (c charClasses) count() int {
+	n := 0
+	for _, present := range []bool{c.lower, c.upper, c.digit, c.symbol} {
+		if present {
+			n++
+		}
+	}
+	return n
+}
+
+// EstimateEntropy gives a rough estimate of a password's entropy in bits.
+// Each character adds log2 of the size of the character classes in use, except
+// characters repeating or continuing a sequence from the previous one, which add one bit.
+func EstimateEntropy(password string) float64 {
+	classes := classify(password)
+
+	pool := 0
+	if classes.lower {
+		pool += 26
+	}
+	if classes.upper {
+		pool += 26
+	}
+	if classes.digit {
+		pool += 10
+	}
+	if classes.symbol {
+		pool += 33
+	}
+	if pool == 0 {
+		return 0
+	}
+
+	bitsPerChar := math.Log2(float64(pool))
+
+	bits := 0.0
+	var previous rune = -1
+	for _, c := range strings.ToLower(password) {
+		if previous != -1 && (c == previous || c == previous+1 || c == previous-1) {
+			bits++
+		} else {
+			bits += bitsPerChar
+		}
+		previous = c
+	}
+	return bits
+}
+
+// Validate checks a new password against the policy
+func (p PasswordPolicyConfig) Validate(password string) error {
+	length := utf8.RuneCountInString(password)
+
+	if length < p.MinLength {
+		return fmt.Errorf("password must be atleast %v characters long", p.MinLength)
+	}
+
+	if p.MaxLength > 0 && length > p.MaxLength {
+		return fmt.Errorf("password must be at most %v characters long", p.MaxLength)
+	}
+
+	if p.PassphraseLength == 0 || length < p.PassphraseLength {
+		if classify(password).count() < p.MinCharClasses {
+			message := fmt.Sprintf("password must contain atleast %v of: lowercase letters, uppercase letters, digits and symbols", p.MinCharClasses)
+			if p.PassphraseLength > 0 {
+				message += fmt.Sprintf(", or be atleast %v characters long", p.PassphraseLength)
+			}
+			return fmt.Errorf("%v", message)
+		}
+	}
+
+	if p.MinEntropyBits > 0 && EstimateEntropy(password) < p.MinEntropyBits {
+		return fmt.Errorf("password is too predictable, avoid repeated characters and sequences")
+	}
+
+	if p.CheckBreached && IsBreachedPassword(password) {
+		return fmt.Errorf("password is too common or has appeared in a data breach, choose another one")
+	}
+
+	return nil
+}
+
+// Validate the password against the password policy
+func ValidatePassword(password string) error {
+	return PasswordPolicy.Validate(password)
+}